@@ -1,15 +1,31 @@
 package main
 
 import (
+	"context"
+	"crypto/subtle"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
-	"github.com/mzfarshad/tlg_bot/internal/bot"
+	botpkg "github.com/mzfarshad/tlg_bot/internal/bot"
+	"github.com/mzfarshad/tlg_bot/internal/cbdata"
 	"github.com/mzfarshad/tlg_bot/internal/config"
+	"github.com/mzfarshad/tlg_bot/internal/deeplink"
+	"github.com/mzfarshad/tlg_bot/internal/i18ncheck"
+	"github.com/mzfarshad/tlg_bot/internal/webapp"
+	"github.com/mzfarshad/tlg_bot/internal/webhook"
 )
 
+// shutdownTimeout is the time given to the requests in progress when the program stops.
+const shutdownTimeout = 10 * time.Second
+
 // init function is executed before the main function.
 // It loads environment variables from the .env file.
 // If the file is not found, the program will terminate with a fatal error.
@@ -21,6 +37,11 @@ import (
 
 func main() {
 
+	// Tools run by name instead of the bot, such as go run ./cmd i18n-check
+	if len(os.Args) > 1 {
+		os.Exit(runTool(os.Args[1], os.Args[2:]))
+	}
+
 	// Retrieve the bot token from the environment variables.
 	// If the token is not available, the program will terminate with a panic.
 	token, err := config.TokenFromENV()
@@ -28,38 +49,245 @@ func main() {
 		log.Panic(err)
 	}
 
-	// Create a new instance of the bot using the token.
-	// If the bot cannot be initialized, the program will terminate with a panic
-	bot, err := bot.NewBot(token)
+	// Sign the callback data of buttons, so forged button presses are rejected.
+	// Signing is turned off if no secret is set.
+	cbdata.SetSecret(config.CallbackSecretFromENV())
+
+	// Sign the payloads of deep links, so only links created by the bot change the settings of users.
+	// Without a secret the payloads are only validated.
+	deeplink.SetSecret(config.DeepLinkSecretFromENV())
+
+	// Create a new instance of the bot using the token and the menu definition.
+	// If the bot cannot be initialized or the menus are invalid, the program will terminate with a panic
+	bot, err := botpkg.NewBot(token, config.MenuFileFromENV())
+	if err != nil {
+		log.Panic(err)
+	}
+
+	// Retrieve the admin user IDs from the environment variables.
+	// If the list is malformed, the program will terminate with a panic.
+	bot.Admins, err = config.AdminIDsFromENV()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	// Set the Telegram command menus in every interface language.
+	// The bot still works without them, so a failure is only logged.
+	if err := bot.PublishCommands(); err != nil {
+		log.Println(err)
+	}
+
+	// Open the Mini App from the menu button of private chats, if its address is set.
+	if url := config.WebAppURLFromENV(); url != "" {
+		if err := bot.SetWebAppMenuButton(url); err != nil {
+			log.Println(err)
+		}
+	}
+
+	// Handle the updates in a pool of workers, each handling the updates of its chats in order.
+	// Invalid worker settings terminate the program with a panic.
+	var workers botpkg.WorkerConfig
+	if workers.Workers, err = config.WorkersFromENV(); err != nil {
+		log.Panic(err)
+	}
+	if workers.QueueSize, err = config.WorkerQueueSizeFromENV(); err != nil {
+		log.Panic(err)
+	}
+	if workers.Overflow, err = config.QueueOverflowFromENV(); err != nil {
+		log.Panic(err)
+	}
+	bot.StartWorkers(workers)
+
+	// Drop the updates Telegram delivers again, remembering the IDs of the last processed updates,
+	// in the database too if set. Invalid settings or an unreadable database terminate the program with a panic.
+	window, err := config.DedupWindowFromENV()
+	if err != nil {
+		log.Panic(err)
+	}
+	persist, err := config.DedupPersistFromENV()
 	if err != nil {
 		log.Panic(err)
 	}
+	if err := bot.DeduplicateUpdates(window, persist); err != nil {
+		log.Panic(err)
+	}
 
 	// Enable debug mode for the bot's API.
 	bot.API.Debug = true
 
 	log.Printf("Authorized on account %s", bot.API.Self.UserName)
 
+	// Decide how the updates arrive: posted by Telegram to /webhook, or fetched by polling.
+	// An invalid mode or polling setting terminates the program with a panic.
+	mode, err := config.UpdateModeFromENV()
+	if err != nil {
+		log.Panic(err)
+	}
+
 	router := gin.Default()
 
-	router.POST("/webhook", func(ctx *gin.Context) {
-		var update tgbotapi.Update
+	var deleteWebhook bool
+	if mode == config.WebhookMode {
+		// Accept updates only with the secret token, and register the webhook if its address is set.
+		// A webhook registered at startup gets a generated secret if none is set; a webhook set by hand
+		// needs the secret it was set with. Invalid webhook settings or a failed registration terminate
+		// the program with a panic.
+		secret, err := config.WebhookSecretFromENV()
+		if err != nil {
+			log.Panic(err)
+		}
+		url := config.WebhookURLFromENV()
+		if secret == "" && url == "" {
+			log.Panic("WEBHOOK_SECRET must be set to the secret token of the webhook when WEBHOOK_URL isn't set")
+		}
+		if secret == "" {
+			if secret, err = webhook.NewSecret(); err != nil {
+				log.Panic(err)
+			}
+			log.Println("WEBHOOK_SECRET isn't set, registering the webhook with a generated secret token")
+		}
+		hook, err := webhook.New(bot, secret)
+		if err != nil {
+			log.Panic(err)
+		}
+		hook.Register(router)
+
+		if url != "" {
+			maxConnections, err := config.WebhookMaxConnectionsFromENV()
+			if err != nil {
+				log.Panic(err)
+			}
+			err = bot.SetWebhook(botpkg.WebhookConfig{
+				URL:            url,
+				SecretToken:    secret,
+				AllowedUpdates: config.AllowedUpdatesFromENV(),
+				MaxConnections: maxConnections,
+			})
+			if err != nil {
+				log.Panic(err)
+			}
+		}
 
-		if err := ctx.ShouldBindJSON(&update); err != nil {
-			log.Printf("error parsing update: %v\n", err)
-			ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		if deleteWebhook, err = config.DeleteWebhookOnShutdownFromENV(); err != nil {
+			log.Panic(err)
 		}
+	}
 
-		bot.HandleUpdate(update)
+	// The Mini App editing the settings, glossary and history of users
+	webapp.New(token).Register(router)
 
-		ctx.JSON(http.StatusOK, gin.H{"status": "ok"})
-	})
+	// Counters and handling times of the updates, per kind of update, and the depth of the update queue,
+	// only for requests with the metrics token
+	if metricsToken := config.MetricsTokenFromENV(); metricsToken != "" {
+		router.GET("/metrics", requireBearerToken(metricsToken), func(ctx *gin.Context) {
+			ctx.JSON(http.StatusOK, bot.Metrics.Snapshot())
+		})
+	} else {
+		log.Println("METRICS_TOKEN isn't set, /metrics isn't served")
+	}
 
 	router.GET("/", func(ctx *gin.Context) {
 		ctx.String(200, "Welcome to Translate Bot")
 	})
 
-	if err := router.Run(":7171"); err != nil {
-		log.Fatalf("error starting server: %v", err)
+	// Stop on Ctrl+C or when the container is stopped
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	server := &http.Server{Addr: ":7171", Handler: router}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("error starting server: %v", err)
+		}
+	}()
+
+	if mode == config.PollingMode {
+		polling := botpkg.PollingConfig{AllowedUpdates: config.AllowedUpdatesFromENV()}
+		if polling.Offset, err = config.PollingOffsetFromENV(); err != nil {
+			log.Panic(err)
+		}
+		if polling.Timeout, err = config.PollingTimeoutFromENV(); err != nil {
+			log.Panic(err)
+		}
+		// Polling handles the updates until the program is stopped
+		if err := bot.Poll(ctx, polling); err != nil {
+			log.Panic(err)
+		}
+	}
+	<-ctx.Done()
+
+	log.Println("shutting down")
+	if deleteWebhook {
+		// Telegram keeps the updates sent while the bot is down for the next start
+		if err := bot.DeleteWebhook(); err != nil {
+			log.Println(err)
+		}
+	}
+	shutdown, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdown); err != nil {
+		log.Printf("error shutting down server: %v", err)
+	}
+	// Handle the updates already acknowledged to Telegram before stopping
+	if err := bot.StopWorkers(shutdown); err != nil {
+		log.Printf("error stopping workers: %v", err)
+	}
+}
+
+// requireBearerToken rejects requests without the token in their Authorization header, as in "Bearer <token>".
+
+func requireBearerToken(token string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		given, found := strings.CutPrefix(ctx.GetHeader("Authorization"), "Bearer ")
+		if !found || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+		}
+	}
+}
+
+// runTool runs the tool with the given name and returns the exit code of the program.
+
+func runTool(name string, args []string) int {
+
+	switch name {
+	case "i18n-check":
+		// Checks the locale catalogs against the code under the given directory, the working directory by default
+		root := "."
+		if len(args) > 0 {
+			root = args[0]
+		}
+		reports, err := i18ncheck.Check(root)
+		if err != nil {
+			log.Println(err)
+			return 2
+		}
+		if problems := i18ncheck.Print(os.Stdout, reports); problems > 0 {
+			return 1
+		}
+		return 0
+
+	case "webhook-info":
+		// Shows the webhook Telegram delivers the updates to, the pending updates and the last delivery error
+		token, err := config.TokenFromENV()
+		if err != nil {
+			log.Println(err)
+			return 2
+		}
+		api, err := tgbotapi.NewBotAPI(token)
+		if err != nil {
+			log.Println(err)
+			return 2
+		}
+		info, err := botpkg.GetWebhookInfo(api)
+		if err != nil {
+			log.Println(err)
+			return 2
+		}
+		info.Print(os.Stdout)
+		return 0
+
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q, available: i18n-check, webhook-info\n", name)
+		return 2
 	}
 }
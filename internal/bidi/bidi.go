@@ -0,0 +1,177 @@
+// Package bidi keeps text of mixed writing directions readable by wrapping each segment
+// in Unicode bidirectional isolates.
+//
+// When Persian or Arabic text is combined with English, numbers or URLs, the Unicode bidi algorithm
+// reorders the neutral characters between them, so punctuation and arrows end up on the wrong side.
+// An isolate (LRI, RLI or FSI up to a PDI) lays out its content on its own and is seen from outside
+// as a single neutral character, so every segment keeps its order whatever surrounds it.
+package bidi
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Direction is the writing direction of a text.
+type Direction int
+
+// Directions of a text
+const (
+	Neutral Direction = iota // No strong character, such as numbers and punctuation
+	LTR                      // Left to right, such as English
+	RTL                      // Right to left, such as Persian and Arabic
+)
+
+// Isolate formatting characters, see https://www.unicode.org/reports/tr9/#Explicit_Directional_Isolates
+const (
+	LRI = '\u2066' // Left-to-right isolate
+	RLI = '\u2067' // Right-to-left isolate
+	FSI = '\u2068' // First strong isolate, with the direction of its first strong character
+	PDI = '\u2069' // Pop directional isolate, closing the last isolate
+)
+
+// rtlScripts are the scripts written from right to left.
+var rtlScripts = []*unicode.RangeTable{
+	unicode.Arabic, unicode.Hebrew, unicode.Syriac, unicode.Thaana, unicode.Nko,
+	unicode.Samaritan, unicode.Mandaic, unicode.Adlam,
+}
+
+// rtlLanguages are the ISO 639-1 codes of the languages written from right to left.
+var rtlLanguages = map[string]bool{
+	"ar": true, "fa": true, "he": true, "iw": true, "ur": true, "ps": true, "sd": true,
+	"ug": true, "yi": true, "dv": true, "ku": true, "ckb": true,
+}
+
+// LanguageDirection returns the writing direction of a language code, such as "fa" or "en-US".
+
+func LanguageDirection(code string) Direction {
+	base, _, _ := strings.Cut(strings.ToLower(code), "-")
+	if rtlLanguages[base] {
+		return RTL
+	}
+	return LTR
+}
+
+// Detect returns the direction of the first strong character of a text, as the bidi algorithm does
+// for a paragraph. Isolated segments count as neutral, as they do for the text around them.
+
+func Detect(text string) Direction {
+
+	depth := 0
+	for _, r := range text {
+		switch {
+		case r == LRI || r == RLI || r == FSI:
+			depth++
+		case r == PDI:
+			if depth > 0 {
+				depth--
+			}
+		case depth > 0:
+		case isRTL(r):
+			return RTL
+		case unicode.IsLetter(r):
+			return LTR
+		}
+	}
+	return Neutral
+}
+
+// isRTL reports whether a character is a strong right-to-left character.
+
+func isRTL(r rune) bool {
+	if r == '\u200f' || r == '\u061c' { // Right-to-left and Arabic letter marks
+		return true
+	}
+	return unicode.IsLetter(r) && unicode.In(r, rtlScripts...)
+}
+
+// Isolate wraps each line of a text in an isolate of its direction, an LRI or RLI,
+// or an FSI when it has no strong character yet. Lines are isolated one by one
+// because an isolate can't span a paragraph. Empty lines and already isolated lines are kept as is.
+
+func Isolate(text string) string {
+	if !strings.Contains(text, "\n") {
+		return isolateLine(text)
+	}
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = isolateLine(line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func isolateLine(line string) string {
+	if line == "" || isolated(line) {
+		return line
+	}
+	open := FSI
+	switch Detect(line) {
+	case LTR:
+		open = LRI
+	case RTL:
+		open = RLI
+	}
+	return string(open) + line + string(PDI)
+}
+
+// isolated reports whether a line is a single isolate already.
+
+func isolated(line string) bool {
+	runes := []rune(line)
+	if len(runes) < 2 || runes[len(runes)-1] != PDI {
+		return false
+	}
+	switch runes[0] {
+	case LRI, RLI, FSI:
+	default:
+		return false
+	}
+
+	// The isolate opened first must be the one the last PDI closes
+	depth := 0
+	for i, r := range runes {
+		switch r {
+		case LRI, RLI, FSI:
+			depth++
+		case PDI:
+			depth--
+			if depth == 0 && i < len(runes)-1 {
+				return false
+			}
+		}
+	}
+	return depth == 0
+}
+
+// IsolateIn isolates a text combined with text of the given direction, such as a message in
+// the user's language, when its own direction differs. Text of the same direction is returned as is.
+
+func IsolateIn(dir Direction, text string) string {
+	if own := Detect(text); own == Neutral || own == dir {
+		return text
+	}
+	return Isolate(text)
+}
+
+// Join isolates each segment and joins them with the separator.
+
+func Join(sep string, segments ...string) string {
+	parts := make([]string, len(segments))
+	for i, segment := range segments {
+		parts[i] = Isolate(segment)
+	}
+	return strings.Join(parts, sep)
+}
+
+// Strip removes the isolate characters of a text, such as a message sent back by a user
+// that contains text the bot isolated.
+
+func Strip(text string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case LRI, RLI, FSI, PDI:
+			return -1
+		}
+		return r
+	}, text)
+}
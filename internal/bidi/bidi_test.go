@@ -0,0 +1,47 @@
+package bidi
+
+import "testing"
+
+func TestIsolate(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"hello", "\u2066hello\u2069"},
+		{"سلام world", "\u2067سلام world\u2069"},
+		{"12, hello", "\u206612, hello\u2069"},
+		{"12.5%", "\u206812.5%\u2069"},
+		{"سلام\nhi", "\u2067سلام\u2069\n\u2066hi\u2069"},
+		{"", ""},
+		{"\u2066hi\u2069", "\u2066hi\u2069"},
+		{"\u2066a\u2069 \u2067ب\u2069", "\u2068\u2066a\u2069 \u2067ب\u2069\u2069"},
+	}
+	for _, test := range tests {
+		if got := Isolate(test.text); got != test.want {
+			t.Errorf("Isolate(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+func TestIsolateIn(t *testing.T) {
+	tests := []struct {
+		dir  Direction
+		text string
+		want string
+	}{
+		{RTL, "https://t.me/bot", "\u2066https://t.me/bot\u2069"},
+		{RTL, "سلام", "سلام"},
+		{LTR, "سلام!", "\u2067سلام!\u2069"},
+		{LTR, "hello", "hello"},
+		{RTL, "123", "123"},
+		{RTL, "\u2066fa → en\u2069", "\u2066fa → en\u2069"},
+	}
+	for _, test := range tests {
+		if got := IsolateIn(test.dir, test.text); got != test.want {
+			t.Errorf("IsolateIn(%d, %q) = %q, want %q", test.dir, test.text, got, test.want)
+		}
+	}
+	if got := Strip(Join("\n", "سلام", "hi")); got != "سلام\nhi" {
+		t.Errorf("Strip(Join()) = %q", got)
+	}
+}
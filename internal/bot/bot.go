@@ -1,13 +1,14 @@
 package bot
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
-	"strings"
+	"sync"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/mzfarshad/tlg_bot/internal/fsm"
 	"github.com/mzfarshad/tlg_bot/internal/key"
-	"github.com/mzfarshad/tlg_bot/internal/setting"
 	"github.com/mzfarshad/tlg_bot/internal/storange"
 )
 
@@ -16,12 +17,24 @@ import (
 type Bot struct {
 	API            *tgbotapi.BotAPI // API instance to interact with Telegram API
 	HandlerManager *HandlerManager  // Manager for handling different commands and interactions
+	Commands       *CommandRegistry // Commands of the bot and their handlers
 	MenuManager    *MenuManager     // Manager for handling menu logic
+	Conversations  *fsm.Machine     // State machine of the multi-step conversations
+	Metrics        *Metrics         // Counters of the handled updates
+	Admins         []int64          // Telegram user IDs allowed to use admin features
+
+	dispatch  UpdateHandler // Router wrapped in the middleware chain
+	throttle  *throttle     // Per-user limit of updates
+	workers   *workerPool   // Workers handling the queued updates, nil until StartWorkers
+	updates   *dedup        // IDs of the last processed updates, to drop updates delivered again
+	answered  sync.Map      // IDs of the callback queries already answered while being handled
+	seenUsers *seenUsers    // Users seen recently, whose language was inferred
 }
 
-// NewBot creates a new instance of Bot, initializes API, handlers, and database connection.
+// NewBot creates a new instance of Bot, initializes API, handlers, menus, conversations and database connection.
+// The menus are read from menuFile, or the built-in menu definition if it's empty.
 
-func NewBot(tkn string) (*Bot, error) {
+func NewBot(tkn, menuFile string) (*Bot, error) {
 
 	// Create a new bot API instance with the given token
 	botApi, err := tgbotapi.NewBotAPI(tkn)
@@ -31,100 +44,148 @@ func NewBot(tkn string) (*Bot, error) {
 
 	// Initialize the Bot struct with the API instance
 	bot := &Bot{
-		API: botApi,
+		API:       botApi,
+		Metrics:   newMetrics(),
+		throttle:  newThrottle(throttleRate, throttleBurst),
+		updates:   newDedup(defaultDedupWindow),
+		seenUsers: newSeenUsers(),
 	}
 
 	// Create handler and menu managers for the bot
 	bot.HandlerManager = CreateHandlerManager(bot)
-	bot.MenuManager = CreateMenuManager(bot)
+	bot.Commands = CreateCommandRegistry(bot)
+	bot.MenuManager, err = CreateMenuManager(bot, menuFile)
+	if err != nil {
+		return nil, err
+	}
+	bot.Conversations, err = CreateConversations()
+	if err != nil {
+		return nil, err
+	}
+
+	// Every update goes through the middlewares before it's routed to its handler
+	bot.dispatch = chain(bot.route, bot.defaultMiddlewares()...)
 
 	// Initialize the database connection
 	if err := storange.InitDB(); err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
+	go expireMenuStates()
+	go expireTranslationReplies()
 
 	return bot, nil
 }
 
-// Start begins polling for updates from Telegram and processes each update.
+// HandleUpdate processes incoming updates from Telegram, including messages and callback queries.
+// The update goes through the middleware chain and is then routed to its handler.
+// An update already processed is dropped.
 
-// func (b *Bot) Start() {
+func (b *Bot) HandleUpdate(update tgbotapi.Update) {
+	if ctx := newContext(update); b.firstDelivery(ctx) {
+		b.handle(ctx)
+	}
+}
 
-// 	// Create an Update configuration with a timeout of 60 seconds
-// 	u := tgbotapi.NewUpdate(0)
-// 	u.Timeout = 60
+// HandleUpdateJSON decodes an update as sent by Telegram and processes it like HandleUpdate.
+// Decoding the raw update also finds the forum topic of its message, used to look up the settings of the topic.
 
-// 	// Get a channel of updates from the Telegram API
-// 	updates := b.API.GetUpdatesChan(u)
+func (b *Bot) HandleUpdateJSON(body []byte) error {
 
-// 	// Process each update received
-// 	for update := range updates {
-// 		b.HandleUpdate(update)
-// 	}
-// }
+	ctx, err := decodeUpdate(body)
+	if err != nil {
+		return err
+	}
+	if b.firstDelivery(ctx) {
+		b.handle(ctx)
+	}
+	return nil
+}
 
-// handleUpdate processes incoming updates from Telegram, including messages and callback queries.
+// decodeUpdate decodes an update as sent by Telegram into its context.
 
-func (b *Bot) HandleUpdate(update tgbotapi.Update) {
+func decodeUpdate(body []byte) (*Context, error) {
 
-	var chatID int64
-	var msg *tgbotapi.Message
-	var lang key.Language
-	var err error
+	var update tgbotapi.Update
+	if err := json.Unmarshal(body, &update); err != nil {
+		return nil, fmt.Errorf("error parsing update: %v", err)
+	}
+	var topic topicUpdate
+	if err := json.Unmarshal(body, &topic); err != nil {
+		return nil, fmt.Errorf("error parsing update topic: %v", err)
+	}
 
-	if update.Message != nil {
+	ctx := newContext(update)
+	ctx.ThreadID = topic.threadID()
+	return ctx, nil
+}
 
-		// Handle incoming message updates
-		msg = update.Message
-		chatID = update.Message.Chat.ID
+// handle passes the context of an update through the middleware chain to its handler.
 
-		// Retrieve the language setting for the bot based on the user ID and chat ID
-		lang, err = setting.BotLanguage(int(update.Message.From.ID), chatID)
-		if err != nil {
-			log.Println(err)
-			return
-		}
-		// Process commands in the message
-		if msg.IsCommand() {
-			cmd := msg.Command()
-			cmd = strings.ToLower(cmd)
-			if cmd == string(key.StartHandler) {
-
-				// Handle the /start command
-				startHandler := &StartHandler{bot: b}
-				startHandler.Handle(chatID, update.Message, lang)
-			} else {
-				selectLangPairs := &SelectLanguagePairs{bot: b}
-				selectLangPairs.Handle(chatID, msg, lang)
-			}
-		}
-	} else if update.CallbackQuery != nil {
-		// Handle callback queries
-		if update.CallbackQuery.Message != nil {
-			chatID = update.CallbackQuery.Message.Chat.ID
+func (b *Bot) handle(ctx *Context) {
 
-			// Retrieve the language setting for the bot based on the user ID and chat ID
-			lang, err = setting.BotLanguage(int(update.CallbackQuery.From.ID), chatID)
-			if err != nil {
-				log.Println(err)
-				return
-			}
+	b.dispatch(ctx)
 
-			// Handle the callback interaction based on the callback data
-			handlerName := update.CallbackQuery.Data
-			b.HandlerManager.handlInteraction(handlerName, update.CallbackQuery, chatID, lang)
+	// Stop the loading indicator of the button if the handler didn't answer with a text
+	if ctx.Callback != nil {
+		b.answerCallback(ctx.Callback, "", false)
+		b.answered.Delete(ctx.Callback.ID)
+	}
+}
+
+// route passes the update to the handler for its kind.
 
-		} else {
+func (b *Bot) route(ctx *Context) {
 
+	switch ctx.Kind {
+	case commandUpdate:
+		b.Commands.route(ctx)
+
+	case messageUpdate:
+		msg := ctx.Message
+		if msg.Text == "" && msg.Document == nil {
+			return
+		}
+		// Pass the input to the conversation waiting for it, otherwise translate free text in private chats
+		if !b.HandlerManager.handleInput(ctx) && msg.Text != "" && msg.Chat.IsPrivate() {
+			b.translateMessageHandle(ctx)
+		}
+
+	case callbackUpdate:
+		if ctx.Callback.Message == nil {
 			log.Println("CallbackQuery has no associated message")
+			return
+		}
+		// Handle the callback interaction based on the callback data
+		if !b.HandlerManager.handlInteraction(ctx) {
+			b.answerCallback(ctx.Callback, key.GetMenuMessage(ctx.Lang, key.OutdatedMenuMessage), true)
 		}
 
-	} else if update.InlineQuery != nil {
-		userID := update.InlineQuery.From.ID
+	case inlineQueryUpdate:
+		b.inlineQueryHandle(ctx)
 
-		go b.inlineQueryHandle(int(userID), update.InlineQuery)
+	case chosenResultUpdate:
+		// Remember the inline translation the user actually sent
+		b.chosenInlineResultHandle(ctx.Update.ChosenInlineResult)
 
-	} else {
+	default:
 		log.Println("Update has neither message, callback query nor inline query")
 	}
 }
+
+// answerCallback acknowledges a callback query, optionally showing a toast or, with alert set, an alert.
+// Telegram accepts only one answer per callback query, so later calls for the same query are ignored.
+
+func (b *Bot) answerCallback(callback *tgbotapi.CallbackQuery, text string, alert bool) {
+
+	if _, done := b.answered.LoadOrStore(callback.ID, true); done {
+		return
+	}
+
+	answer := tgbotapi.NewCallback(callback.ID, text)
+	if alert {
+		answer = tgbotapi.NewCallbackWithAlert(callback.ID, text)
+	}
+	if _, err := b.API.Request(answer); err != nil {
+		log.Printf("error answering callback query: %v", err)
+	}
+}
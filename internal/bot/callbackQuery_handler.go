@@ -1,50 +1,49 @@
 package bot
 
 import (
+	"errors"
 	"log"
 
-	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/mzfarshad/tlg_bot/internal/cbdata"
+	"github.com/mzfarshad/tlg_bot/internal/fsm"
 	"github.com/mzfarshad/tlg_bot/internal/key"
 	"github.com/mzfarshad/tlg_bot/internal/setting"
-	"github.com/mzfarshad/tlg_bot/internal/storange"
 )
 
 // CreateHandlerManager initializes and returns a new HandlerManager with registered handlers.
 
 func CreateHandlerManager(bot *Bot) *HandlerManager {
-	hm := newHandlerManager()
-
-	// Register handlers for different actions
-	hm.rigesterHandler(string(key.KeySettings), &SettingsHandler{bot: bot})
-	hm.rigesterHandler(string(key.KeyBack), &BackHandler{bot: bot})
-	hm.rigesterHandler(string(key.KeySettingsLanguage), &SettingLanguageHandler{bot: bot})
-	hm.rigesterHandler(string(key.LangEN), &SettingSelectLanguageHandler{bot: bot})
-	hm.rigesterHandler(string(key.LangFA), &SettingSelectLanguageHandler{bot: bot})
-	hm.rigesterHandler(string(key.KeyTranslaion), &TranslationHandler{bot: bot})
-	hm.rigesterHandler(string(key.KeyTranslateSentMessage), &TranslationSentMessagesHandler{bot: bot})
-	hm.rigesterHandler(string(key.KeyFinishSetup), &TranslationFinishSetup{bot: bot})
-	hm.rigesterHandler(string(key.KeyResetTranslationSetting), &TranslateResetSetting{bot: bot})
-	hm.rigesterHandler(string(key.KeyResetTranslateYes), &TranslationResetSettingYes{bot: bot})
-	hm.rigesterHandler(string(key.KeyHelp), &HelpHandler{bot: bot})
-	hm.rigesterHandler(string(key.KeyContactUs), &ContactUsHandler{bot: bot})
+	hm := newHandlerManager(bot)
+
+	// Register handlers navigating the menu
+	hm.rigesterMenuHandler(string(key.MenuHandler), &MenuNavigationHandler{bot: bot})
+	hm.rigesterMenuHandler(string(key.KeyBack), &BackHandler{bot: bot})
+	hm.rigesterMenuHandler(string(key.BotLanguageHandler), &SettingSelectLanguageHandler{bot: bot})
+	hm.rigesterMenuHandler(string(key.KeyTranslateSentMessage), &TranslationSentMessagesHandler{bot: bot})
+	hm.rigesterMenuHandler(string(key.KeyFinishSetup), &TranslationFinishSetup{bot: bot})
+	hm.rigesterMenuHandler(string(key.KeyResetTranslateYes), &TranslationResetSettingYes{bot: bot})
+	hm.rigesterMenuHandler(string(key.LanguagePairsHandler), &LanguagePairsPickerHandler{bot: bot})
+	hm.rigesterMenuHandler(string(key.SettingSourcesHandler), &SettingSourcesHandler{bot: bot})
+
+	// Register handlers for buttons attached to other messages
+	hm.rigesterHandler(string(key.NoopHandler), &NoopHandler{})
+	hm.rigesterHandler(string(key.RecentPairsHandler), &RecentPairsHandler{bot: bot})
+	hm.rigesterHandler(string(key.HistoryHandler), &HistoryHandler{bot: bot})
+	hm.rigesterHandler(string(key.PhrasebookHandler), &PhrasebookHandler{bot: bot})
+	hm.rigesterHandler(string(key.FeedbackHandler), &FeedbackHandler{bot: bot})
+	hm.rigesterHandler(string(key.GlossaryHandler), &GlossaryHandler{bot: bot})
+
+	// Register handlers for input sent while a conversation waits for it
+	hm.rigesterInput(pairSetupFlow, searchState, &LanguageSearchInput{bot: bot})
+	hm.rigesterInput(historySearchFlow, keywordState, &HistorySearchInput{bot: bot})
+	hm.rigesterInput(correctionFlow, correctionState, &CorrectionInput{bot: bot})
+	hm.rigesterInput(glossaryFlow, termState, &GlossaryTermInput{bot: bot})
+	hm.rigesterInput(glossaryFlow, translationState, &GlossaryTranslationInput{bot: bot})
+	hm.rigesterInput(glossaryFlow, importState, &GlossaryImportInput{bot: bot})
 
 	return hm
 }
 
-// SettingsHandler handles interactions related to the settings menu.
-
-type SettingsHandler struct {
-	bot *Bot
-}
-
-// Handle processes interactions to show the settings menu.
-
-func (b *SettingsHandler) Handle(chatID int64, callback *tgbotapi.CallbackQuery, lang key.Language) {
-
-	userID := callback.From.ID
-	b.bot.MenuManager.menuInteraction(int(userID), chatID, string(key.MenuSetting), lang)
-}
-
 // BackHandler handles interactions related to going back in the menu.
 
 type BackHandler struct {
@@ -52,26 +51,24 @@ type BackHandler struct {
 }
 
 // Handle processes interactions to go back to the previous menu.
+// Inside a conversation it leaves the conversation and shows the menu it was started from.
 
-func (b *BackHandler) Handle(chatID int64, callback *tgbotapi.CallbackQuery, lang key.Language) {
+func (b *BackHandler) Handle(ctx *Context, args cbdata.Args) {
 
-	userID := callback.From.ID
-	previousMenu := popState(int(userID), chatID)
-	b.bot.MenuManager.menuInteraction(int(userID), chatID, previousMenu, lang)
-}
-
-// SettingLanguageHandler handles interactions related to changing the bot's language settings.
-
-type SettingLanguageHandler struct {
-	bot *Bot
-}
-
-// Handle processes interactions to show the language settings menu.
+	userID := ctx.UserID
 
-func (b *SettingLanguageHandler) Handle(chatID int64, callback *tgbotapi.CallbackQuery, lang key.Language) {
-
-	userID := callback.From.ID
-	b.bot.MenuManager.menuInteraction(int(userID), chatID, string(key.MenuSettingLanguage), lang)
+	var previousMenu string
+	session, err := b.bot.Conversations.Current(userID, ctx.ChatID)
+	if session != nil || errors.Is(err, fsm.ErrExpired) {
+		b.bot.cancelConversation(userID, ctx.ChatID)
+		previousMenu = peekState(userID, ctx.ChatID)
+	} else {
+		previousMenu = popState(userID, ctx.ChatID)
+	}
+	if previousMenu == "" {
+		previousMenu = b.bot.MenuManager.root
+	}
+	b.bot.MenuManager.menuInteraction(ctx.settings(), previousMenu, ctx.Lang, ctx.Callback)
 }
 
 // SettingSelectLanguageHandler handles interactions related to selecting a new language for the bot.
@@ -81,89 +78,44 @@ type SettingSelectLanguageHandler struct {
 }
 
 // Handle processes interactions to save the selected language and show the language settings menu.
+// The selected language is the first argument of the callback data.
 
-func (b *SettingSelectLanguageHandler) Handle(chatID int64, callback *tgbotapi.CallbackQuery, lang key.Language) {
-
-	var msg string
-	var err error
-	var botLang key.Language
-	selectLang := callback.Data
-	userID := callback.From.ID
-
-	// Save the selected language to storage
-	if selectLang == string(key.LangEN) {
-		err = storange.SaveBotLanguage(chatID, int(userID), selectLang)
-		if err != nil {
-			log.Printf("error saving bot language: %v", err)
-		}
-		msg = sendChangLangMessage(chatID, int(userID), err)
-		message := tgbotapi.NewMessage(chatID, msg)
-		b.bot.API.Send(message)
-	}
+func (b *SettingSelectLanguageHandler) Handle(ctx *Context, args cbdata.Args) {
 
-	if selectLang == string(key.LangFA) {
-		err = storange.SaveBotLanguage(chatID, int(userID), selectLang)
-		if err != nil {
-			log.Printf("error saving bot language: %v", err)
-		}
-		msg = sendChangLangMessage(chatID, int(userID), err)
-		message := tgbotapi.NewMessage(chatID, msg)
-		b.bot.API.Send(message)
-	}
+	selectLang := args.String(0)
 
-	// Retrieve the updated language setting and show the language settings menu
-	botLang, err = setting.BotLanguage(int(userID), chatID)
-	if err != nil {
-		log.Println(err)
+	if !key.Supported(key.Language(selectLang)) {
+		log.Printf("unknown bot language: %s", selectLang)
+		return
 	}
-	b.bot.MenuManager.menuInteraction(int(userID), chatID, string(key.MenuSettingLanguage), botLang)
-}
-
-// sendChangeLangMessage generates a message indicating whether the language change was successful or failed.
 
-func sendChangLangMessage(chatID int64, userID int, err error) string {
-
-	var msg string
-	lang, er := setting.BotLanguage(userID, chatID)
-	if er != nil {
-		log.Println(er)
-	}
-	if err == nil {
-		msg = key.GetMenuMessage(lang, key.ChangeLanguageMessage)
+	// Save the selected language to storage and continue in it
+	if err := setting.SaveBotLanguage(setting.User, ctx.settings(), key.Language(selectLang)); err != nil {
+		log.Printf("error saving bot language: %v", err)
+		b.bot.answerCallback(ctx.Callback, key.GetMenuMessage(ctx.Lang, key.FailedChangeLanguageMessage), true)
 	} else {
-		msg = key.GetMenuMessage(lang, key.FailedChangeLanguageMessage)
+		ctx.Lang = key.Language(selectLang)
+		b.bot.answerCallback(ctx.Callback, key.GetMenuMessage(ctx.Lang, key.ChangeLanguageMessage), false)
 	}
-	return msg
-}
 
-type TranslationHandler struct {
-	bot *Bot
-}
-
-func (h *TranslationHandler) Handle(chatID int64, callback *tgbotapi.CallbackQuery, lang key.Language) {
-
-	userID := callback.From.ID
-	h.bot.MenuManager.menuInteraction(int(userID), chatID, string(key.MenuTranslation), lang)
+	// Show the language settings menu in the current language
+	b.bot.MenuManager.menuInteraction(ctx.settings(), string(key.MenuSettingLanguage), ctx.Lang, ctx.Callback)
 }
 
 type TranslationSentMessagesHandler struct {
 	bot *Bot
 }
 
-func (h *TranslationSentMessagesHandler) Handle(chatID int64, callback *tgbotapi.CallbackQuery, lang key.Language) {
-
-	text := callback.Data
-	userID := callback.From.ID
+func (h *TranslationSentMessagesHandler) Handle(ctx *Context, args cbdata.Args) {
 
-	if text == string(key.KeyTranslateSentMessage) {
-		err := storange.SavetTranslateMessageSetting(int(userID), true)
-		if err != nil {
-			log.Println(err)
-			return
-		}
+	err := setting.StartTranslationSetup(setting.User, ctx.settings())
+	if err != nil {
+		log.Println(err)
+		return
 	}
 
-	h.bot.MenuManager.menuInteraction(int(userID), chatID, string(key.MenuTranslationLanguagePairs), lang)
+	// The language picker is a conversation on top of the translation menu
+	h.bot.startPairSetup(ctx, ctx.Callback)
 }
 
 // type TranslationSentLanguagePairs struct {
@@ -175,90 +127,54 @@ func (h *TranslationSentMessagesHandler) Handle(chatID int64, callback *tgbotapi
 // 	userID := callback.From.ID
 // 	langPairs := callback.Data
 
-// 	err := storange.SaveLanguagePairs(int(userID), langPairs)
+// 	err := storange.SaveLanguagePairs(userID, langPairs)
 // 	if err != nil {
 // 		log.Printf("error handler sent language pairs: %v", err)
 // 	}
 
-// 	h.bot.MenuManager.menuInteraction(int(userID), chatID, string(key.MenuFinishTranslateSetup), lang)
+// 	h.bot.MenuManager.menuInteraction(userID, chatID, string(key.MenuFinishTranslateSetup), lang)
 // }
 
 type TranslationFinishSetup struct {
 	bot *Bot
 }
 
-func (h *TranslationFinishSetup) Handle(chatID int64, callback *tgbotapi.CallbackQuery, lang key.Language) {
+func (h *TranslationFinishSetup) Handle(ctx *Context, args cbdata.Args) {
 
-	userID := callback.From.ID
+	userID := ctx.UserID
 
-	if err := storange.ActiveTranslation(int(userID), true); err != nil {
+	if err := setting.ActivateTranslation(setting.User, ctx.settings(), true); err != nil {
 		log.Printf("finish translate setup: %v", err)
 	}
 
-	message := tgbotapi.NewMessage(chatID, key.GetMenuMessage(lang, key.TranslateFinishSetupMessage))
-	h.bot.API.Send(message)
+	h.bot.answerCallback(ctx.Callback, key.GetMenuMessage(ctx.Lang, key.TranslateFinishSetupMessage), false)
 
-	for i := 0; i < 2; i++ {
-		popState(int(userID), chatID)
+	// The pair setup ends here; the translation menu it was started from is shown again
+	if session, _ := h.bot.conversation(userID, ctx.ChatID, pairSetupFlow); session != nil {
+		if err := h.bot.Conversations.Fire(session, doneEvent); err != nil {
+			log.Println(err)
+		}
 	}
 
-	h.bot.MenuManager.menuInteraction(int(userID), chatID, string(key.MenuTranslation), lang)
-}
-
-type TranslateResetSetting struct {
-	bot *Bot
-}
-
-func (h *TranslateResetSetting) Handle(chatID int64, callback *tgbotapi.CallbackQuery, lang key.Language) {
-
-	userID := callback.From.ID
-
-	h.bot.MenuManager.menuInteraction(int(userID), chatID, string(key.MenuResetTranslate), lang)
+	h.bot.MenuManager.menuInteraction(ctx.settings(), string(key.MenuTranslation), ctx.Lang, ctx.Callback)
 }
 
 type TranslationResetSettingYes struct {
 	bot *Bot
 }
 
-func (h *TranslationResetSettingYes) Handle(chatID int64, callback *tgbotapi.CallbackQuery, lang key.Language) {
+func (h *TranslationResetSettingYes) Handle(ctx *Context, args cbdata.Args) {
 
-	userID := callback.From.ID
-	var msg string
+	userID := ctx.UserID
 
-	if err := storange.ResetTranslationSettings(int(userID)); err != nil {
+	if _, err := setting.ResetTranslationSettings(setting.User, ctx.settings()); err != nil {
 		log.Printf("error translate setting reset: %v", err)
-		msg = "Something is wrong, Please try again"
+		h.bot.answerCallback(ctx.Callback, key.GetMenuMessage(ctx.Lang, key.FailedResetTranslateSettingMessage), true)
 	} else {
-		msg = key.GetMenuMessage(lang, key.FinishResetTranslateSettingMessage)
+		h.bot.answerCallback(ctx.Callback, key.GetMenuMessage(ctx.Lang, key.FinishResetTranslateSettingMessage), false)
 	}
 
-	message := tgbotapi.NewMessage(chatID, msg)
-	h.bot.API.Send(message)
-
-	popState(int(userID), chatID)
-
-	h.bot.MenuManager.menuInteraction(int(userID), chatID, string(key.MenuTranslation), lang)
-}
-
-type HelpHandler struct {
-	bot *Bot
-}
-
-func (h *HelpHandler) Handle(chatID int64, callback *tgbotapi.CallbackQuery, lang key.Language) {
-
-	userID := callback.From.ID
-	h.bot.MenuManager.menuInteraction(int(userID), chatID, string(key.MenuHelp), lang)
-	h.bot.MenuManager.menuInteraction(int(userID), chatID, string(key.MenuMain), lang)
-}
-
-type ContactUsHandler struct {
-	bot *Bot
-}
-
-func (h *ContactUsHandler) Handle(chatID int64, callback *tgbotapi.CallbackQuery, lang key.Language) {
-
-	userID := callback.From.ID
+	popState(userID, ctx.ChatID)
 
-	h.bot.MenuManager.menuInteraction(int(userID), chatID, string(key.MenuContactUs), lang)
-	h.bot.MenuManager.menuInteraction(int(userID), chatID, string(key.MenuMain), lang)
+	h.bot.MenuManager.menuInteraction(ctx.settings(), string(key.MenuTranslation), ctx.Lang, ctx.Callback)
 }
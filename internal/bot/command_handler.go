@@ -2,13 +2,14 @@ package bot
 
 import (
 	"errors"
-	"fmt"
 	"log"
 	"strings"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/mzfarshad/tlg_bot/internal/key"
+	"github.com/mzfarshad/tlg_bot/internal/setting"
 	"github.com/mzfarshad/tlg_bot/internal/storange"
+	"github.com/mzfarshad/tlg_bot/internal/translation"
 )
 
 // StartHandler handles the /start command.
@@ -18,78 +19,129 @@ type StartHandler struct {
 }
 
 // Handle processes the /start command by clearing the menu state and showing the main menu.
+// The payload of a deep link, such as t.me/<bot>?start=pair_fa-en, is applied first.
 
-func (b *StartHandler) Handle(chatID int64, msg *tgbotapi.Message, lang key.Language) {
+func (b *StartHandler) Handle(ctx *Context) {
 
 	// Clear previous menu state, if user
-	userID := msg.From.ID
-	if err := storange.ClearMenuState(chatID, int(userID)); err != nil {
+	userID := ctx.UserID
+	if err := storange.ClearMenuState(ctx.ChatID, userID); err != nil {
 		log.Println(err)
 		return
 	}
+	if payload := strings.TrimSpace(ctx.Message.CommandArguments()); payload != "" {
+		if b.bot.applyStartPayload(ctx, payload) {
+			return
+		}
+	}
 	// Show the main menu
-	b.bot.MenuManager.menuInteraction(int(userID), chatID, string(key.MenuMain), lang)
+	b.bot.MenuManager.menuInteraction(ctx.settings(), b.bot.MenuManager.root, ctx.Lang, nil)
 }
 
-type SelectLanguagePairs struct {
-	bot *Bot
+// MenuCommandHandler handles the commands opening a menu, such as /help and /settings.
+
+type MenuCommandHandler struct {
+	bot  *Bot
+	menu key.MenuState
 }
 
-var languagePirs = [6]string{"fa", "en", "fr", "ar", "de", "es"}
+// Handle shows the menu on top of the main menu, so the Back button leads to the main menu.
 
-func (b *SelectLanguagePairs) Handle(chatID int64, msg *tgbotapi.Message, lang key.Language) {
+func (h *MenuCommandHandler) Handle(ctx *Context) {
 
-	var mssg string
-	userID := msg.From.ID
-	langPairs := msg.Text
+	if err := storange.ClearMenuState(ctx.ChatID, ctx.UserID); err != nil {
+		log.Println(err)
+		return
+	}
+	if err := pushState(ctx.UserID, ctx.ChatID, h.bot.MenuManager.root); err != nil {
+		log.Println(err)
+	}
+	h.bot.MenuManager.menuInteraction(ctx.settings(), string(h.menu), ctx.Lang, nil)
+}
+
+// SelectLanguagePairs handles the commands naming a language pair, such as /en-fa.
+
+type SelectLanguagePairs struct {
+	bot *Bot
+}
 
-	langPairs = strings.TrimPrefix(langPairs, "/")
-	langPairs = strings.ToLower(langPairs)
+func (b *SelectLanguagePairs) Handle(ctx *Context) {
 
-	log.Println("<<<<<<<<<<", langPairs, ">>>>>>>>>>>")
+	var mssg string
+	langPairs, _, _ := pairCommand(ctx.Message.Text)
 
 	pairs, err := split(langPairs)
 	if err != nil {
-		mssg = key.GetMenuMessage(lang, key.SelectLanguagePairsMessage)
-		message := tgbotapi.NewMessage(chatID, mssg)
+		mssg = key.GetMenuMessage(ctx.Lang, key.SelectLanguagePairsMessage)
+		message := tgbotapi.NewMessage(ctx.ChatID, mssg)
 		b.bot.API.Send(message)
-		b.bot.MenuManager.menuInteraction(int(userID), chatID, string(key.MenuTranslationLanguagePairs), lang)
+		b.bot.startPairSetup(ctx, nil)
 		return
 	}
 
 	suorceLang, targetLang := pairs[0], pairs[1]
 
-	if !contain(suorceLang, languagePirs) {
-		mssg = wrongSelectLnagMessage(lang, suorceLang)
-		message := tgbotapi.NewMessage(chatID, mssg)
+	if suorceLang != translation.AutoDetect && !translation.IsSupportedLanguage(suorceLang) {
+		mssg = key.Format(ctx.Lang, key.UnsupportedLanguageMessage, key.Params{"language": suorceLang})
+		message := tgbotapi.NewMessage(ctx.ChatID, mssg)
 		b.bot.API.Send(message)
-		b.bot.MenuManager.menuInteraction(int(userID), chatID, string(key.MenuTranslationLanguagePairs), lang)
+		b.bot.startPairSetup(ctx, nil)
 		return
 	}
 
-	if !contain(targetLang, languagePirs) {
-		mssg = wrongSelectLnagMessage(lang, targetLang)
-		message := tgbotapi.NewMessage(chatID, mssg)
+	if !translation.IsSupportedLanguage(targetLang) {
+		mssg = key.Format(ctx.Lang, key.UnsupportedLanguageMessage, key.Params{"language": targetLang})
+		message := tgbotapi.NewMessage(ctx.ChatID, mssg)
 		b.bot.API.Send(message)
-		b.bot.MenuManager.menuInteraction(int(userID), chatID, string(key.MenuTranslationLanguagePairs), lang)
+		b.bot.startPairSetup(ctx, nil)
 		return
 	}
 
-	if err := storange.SaveLanguagePairs(int(userID), suorceLang, targetLang); err != nil {
-		log.Println(err)
+	if !validPair(suorceLang, targetLang) {
+		mssg = key.GetMenuMessage(ctx.Lang, key.SamePairLanguagesMessage)
+		message := tgbotapi.NewMessage(ctx.ChatID, mssg)
+		b.bot.API.Send(message)
+		b.bot.startPairSetup(ctx, nil)
+		return
 	}
 
-	b.bot.MenuManager.menuInteraction(int(userID), chatID, string(key.MenuFinishTranslateSetup), lang)
-
+	b.bot.activatePair(ctx, suorceLang, targetLang)
 }
 
-func contain(langSymbol string, arr [6]string) bool {
-	for _, v := range arr {
-		if v == langSymbol {
-			return true
+// activatePair saves a language pair chosen outside the language picker and asks the user to finish the setup.
+// The user is told when the pair of the chat or forum topic overrides their own pair.
+// A pair that can't be saved, such as one translating a language to itself, is logged and ignored.
+
+func (b *Bot) activatePair(ctx *Context, source, target string) {
+
+	if !validPair(source, target) {
+		log.Printf("ignored invalid language pair %s-%s of user %d", source, target, ctx.UserID)
+		return
+	}
+
+	if err := setting.SaveLanguagePairs(setting.User, ctx.settings(), source, target); err != nil {
+		log.Println(err)
+	} else {
+		rememberPair(ctx.UserID, source, target)
+		if text := pairOverriddenText(ctx); text != "" {
+			b.API.Send(tgbotapi.NewMessage(ctx.ChatID, text))
 		}
 	}
-	return false
+
+	// The pair is chosen already, so the pair setup starts at its last step
+	data := map[string]string{"source": source, "target": target}
+	if b.startConversation(ctx.UserID, ctx.ChatID, pairSetupFlow, finishState, data) {
+		b.MenuManager.showFlowMenu(ctx.settings(), key.MenuFinishTranslateSetup, ctx.Lang, nil)
+	}
+}
+
+// startPairSetup starts the pair setup conversation and shows the language picker,
+// in the message of the callback if there is one.
+
+func (b *Bot) startPairSetup(ctx *Context, callback *tgbotapi.CallbackQuery) {
+	if b.startConversation(ctx.UserID, ctx.ChatID, pairSetupFlow, "", nil) {
+		b.MenuManager.showFlowMenu(ctx.settings(), key.MenuTranslationLanguagePairs, ctx.Lang, callback)
+	}
 }
 
 func split(pairs string) ([]string, error) {
@@ -103,11 +155,3 @@ func split(pairs string) ([]string, error) {
 
 	return parts, nil
 }
-
-func wrongSelectLnagMessage(lang key.Language, s string) string {
-	if lang == key.LangEN {
-		return fmt.Sprintf("The selected language %s is not available in the list. Get help from the message below", s)
-	} else {
-		return fmt.Sprintf("زبان انتخابی  %s در لیست موجود نیست. از پیام پایین کمک بگیرید", s)
-	}
-}
@@ -0,0 +1,237 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/mzfarshad/tlg_bot/internal/key"
+)
+
+// Scopes of the commands, deciding where a command can be used and in which command menus it's listed
+const (
+	privateScope = "private" // Private chats with the bot
+	groupScope   = "group"   // Private chats and groups
+	adminScope   = "admin"   // Private chats of the bot admins
+)
+
+// MessageHandler interface defines the contract for handling a command message.
+
+type MessageHandler interface {
+	Handle(ctx *Context)
+}
+
+// Command describes a command of the bot.
+
+type Command struct {
+	Name        key.HandlerName // Command without the slash, in lower case
+	Description key.TextMessage // Description shown in the Telegram command menu
+	Scope       string          // Where the command can be used
+	Handler     MessageHandler
+}
+
+// CommandRegistry routes command messages to the handlers of the registered commands.
+
+type CommandRegistry struct {
+	bot      *Bot
+	commands []Command                   // Commands in the order of the command menu
+	byName   map[key.HandlerName]Command // Commands by their names
+	pairs    MessageHandler              // Handler of the commands naming a language pair, such as /en-fa
+}
+
+// CreateCommandRegistry initializes and returns a new CommandRegistry with the commands of the bot.
+
+func CreateCommandRegistry(bot *Bot) *CommandRegistry {
+	cr := &CommandRegistry{
+		bot:    bot,
+		byName: make(map[key.HandlerName]Command),
+		pairs:  &SelectLanguagePairs{bot: bot},
+	}
+
+	cr.rigesterCommand(Command{key.StartHandler, key.StartCommandDescription, groupScope, &StartHandler{bot: bot}})
+	cr.rigesterCommand(Command{key.HelpHandler, key.HelpCommandDescription, groupScope,
+		&MenuCommandHandler{bot: bot, menu: key.MenuHelp}})
+	cr.rigesterCommand(Command{key.SettingsHandler, key.SettingsCommandDescription, privateScope,
+		&MenuCommandHandler{bot: bot, menu: key.MenuSetting}})
+	cr.rigesterCommand(Command{key.PairsHandler, key.PairsCommandDescription, privateScope,
+		&PairsCommandHandler{bot: bot}})
+	cr.rigesterCommand(Command{key.HistoryHandler, key.HistoryCommandDescription, privateScope,
+		&HistoryCommandHandler{bot: bot}})
+	cr.rigesterCommand(Command{key.PhrasebookHandler, key.PhrasebookCommandDescription, privateScope,
+		&PhrasebookCommandHandler{bot: bot}})
+	cr.rigesterCommand(Command{key.GlossaryHandler, key.GlossaryCommandDescription, privateScope,
+		&GlossaryCommandHandler{bot: bot}})
+	cr.rigesterCommand(Command{key.DefaultHandler, key.DefaultCommandDescription, groupScope,
+		&DefaultCommandHandler{bot: bot}})
+	cr.rigesterCommand(Command{key.InviteHandler, key.InviteCommandDescription, privateScope,
+		&InviteCommandHandler{bot: bot}})
+	cr.rigesterCommand(Command{key.FeedbackHandler, key.FeedbackCommandDescription, adminScope,
+		&FeedbackCommandHandler{bot: bot}})
+	cr.rigesterCommand(Command{key.BanHandler, key.BanCommandDescription, adminScope, &BanCommandHandler{bot: bot}})
+	cr.rigesterCommand(Command{key.UnbanHandler, key.UnbanCommandDescription, adminScope, &BanCommandHandler{bot: bot}})
+	cr.rigesterCommand(Command{key.LinkHandler, key.LinkCommandDescription, adminScope, &LinkCommandHandler{bot: bot}})
+
+	return cr
+}
+
+// registerCommand adds a command to the registry. A command registered twice replaces the first one.
+
+func (cr *CommandRegistry) rigesterCommand(command Command) {
+	if _, exist := cr.byName[command.Name]; exist {
+		for i := range cr.commands {
+			if cr.commands[i].Name == command.Name {
+				cr.commands[i] = command
+			}
+		}
+	} else {
+		cr.commands = append(cr.commands, command)
+	}
+	cr.byName[command.Name] = command
+}
+
+// route passes a command message to the handler of the command.
+// Commands naming a language pair, such as /en-fa, set the pair; other unknown commands get the list of commands.
+// In groups, commands addressed to another bot are ignored.
+
+func (cr *CommandRegistry) route(ctx *Context) {
+
+	name, username, _ := strings.Cut(ctx.Message.CommandWithAt(), "@")
+	// Telegram ends the command entity at the "-" of a pair command, so the pair is read from the text
+	if pair, pairUsername, ok := pairCommand(ctx.Message.Text); ok {
+		name, username = pair, pairUsername
+	}
+	if username != "" && !strings.EqualFold(username, cr.bot.API.Self.UserName) {
+		return
+	}
+	name = strings.ToLower(name)
+	private := ctx.Message.Chat.IsPrivate()
+
+	command, exist := cr.byName[key.HandlerName(name)]
+	switch {
+	case !exist && isPairCommand(name):
+		cr.pairs.Handle(ctx)
+
+	case !exist:
+		// Unknown commands without the bot's name in a group are probably meant for another bot
+		if !private && username == "" {
+			return
+		}
+		text := key.Format(ctx.Lang, key.UnknownCommandMessage, key.Params{
+			"command":  name,
+			"commands": cr.commandList(ctx.Lang, private, ctx.Admin),
+		})
+		cr.bot.API.Send(tgbotapi.NewMessage(ctx.ChatID, text))
+
+	case command.Scope == adminScope && !ctx.Admin:
+		cr.bot.API.Send(tgbotapi.NewMessage(ctx.ChatID, key.GetMenuMessage(ctx.Lang, key.AdminOnlyMessage)))
+
+	case command.Scope != groupScope && !private:
+		text := key.Format(ctx.Lang, key.PrivateCommandMessage, key.Params{"command": name})
+		cr.bot.API.Send(tgbotapi.NewMessage(ctx.ChatID, text))
+
+	default:
+		command.Handler.Handle(ctx)
+	}
+}
+
+// isPairCommand reports whether the command names a language pair, such as en-fa.
+
+func isPairCommand(name string) bool {
+	return strings.Contains(name, "-")
+}
+
+// pairCommand returns the language pair named by a command message, such as "en-fa" for "/en-fa@bot",
+// in lower case, and the bot the command is addressed to.
+
+func pairCommand(text string) (pair, username string, ok bool) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "/") {
+		return "", "", false
+	}
+	pair, username, _ = strings.Cut(strings.TrimPrefix(fields[0], "/"), "@")
+	pair = strings.ToLower(pair)
+	return pair, username, isPairCommand(pair)
+}
+
+// available returns the commands that can be used in a private chat or a group, by an admin or another user.
+
+func (cr *CommandRegistry) available(private, admin bool) []Command {
+	var commands []Command
+	for _, command := range cr.commands {
+		switch command.Scope {
+		case groupScope:
+			commands = append(commands, command)
+		case privateScope:
+			if private {
+				commands = append(commands, command)
+			}
+		case adminScope:
+			if private && admin {
+				commands = append(commands, command)
+			}
+		}
+	}
+	return commands
+}
+
+// commandList lists the available commands with their descriptions, one per line.
+
+func (cr *CommandRegistry) commandList(lang key.Language, private, admin bool) string {
+	var lines []string
+	for _, command := range cr.available(private, admin) {
+		lines = append(lines, fmt.Sprintf("/%s - %s", command.Name, key.GetMenuMessage(lang, command.Description)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// botCommands converts the commands to the command menu entries in the given language.
+
+func botCommands(commands []Command, lang key.Language) []tgbotapi.BotCommand {
+	entries := make([]tgbotapi.BotCommand, 0, len(commands))
+	for _, command := range commands {
+		entries = append(entries, tgbotapi.BotCommand{
+			Command:     string(command.Name),
+			Description: key.GetMenuMessage(lang, command.Description),
+		})
+	}
+	return entries
+}
+
+// PublishCommands sets the Telegram command menus of the bot, for every interface language:
+// one for private chats, one for groups and one for the private chat of each admin.
+// The English menus are also set as the default for users whose language the bot doesn't speak.
+
+func (b *Bot) PublishCommands() error {
+
+	var languages []string
+	for _, lang := range key.Languages() {
+		languages = append(languages, string(lang))
+	}
+
+	var errs []error
+	publish := func(scope tgbotapi.BotCommandScope, languageCode string, commands []Command) {
+		lang := key.Language(languageCode)
+		if languageCode == "" {
+			lang = key.LangEN
+		}
+		config := tgbotapi.NewSetMyCommandsWithScopeAndLanguage(scope, languageCode, botCommands(commands, lang)...)
+		if _, err := b.API.Request(config); err != nil {
+			errs = append(errs, fmt.Errorf("error setting %s commands for language %q: %w", scope.Type, languageCode, err))
+		}
+	}
+
+	for _, languageCode := range append([]string{""}, languages...) {
+		publish(tgbotapi.NewBotCommandScopeAllPrivateChats(), languageCode, b.Commands.available(true, false))
+		publish(tgbotapi.NewBotCommandScopeAllGroupChats(), languageCode, b.Commands.available(false, false))
+		for _, admin := range b.Admins {
+			publish(tgbotapi.NewBotCommandScopeChat(admin), languageCode, b.Commands.available(true, true))
+		}
+	}
+
+	if len(errs) == 0 {
+		log.Printf("command menus set for %d languages", len(languages))
+	}
+	return errors.Join(errs...)
+}
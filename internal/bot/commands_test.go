@@ -0,0 +1,76 @@
+package bot
+
+import (
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/mzfarshad/tlg_bot/internal/key"
+)
+
+// recordingHandler remembers the texts of the commands it handled.
+
+type recordingHandler struct {
+	texts []string
+}
+
+func (h *recordingHandler) Handle(ctx *Context) {
+	h.texts = append(h.texts, ctx.Message.Text)
+}
+
+// commandMessage returns a private message whose command entity ends where Telegram ends it.
+
+func commandMessage(text string, entityLength int) *tgbotapi.Message {
+	return &tgbotapi.Message{
+		Text:     text,
+		Chat:     &tgbotapi.Chat{ID: 1, Type: "private"},
+		Entities: []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: entityLength}},
+	}
+}
+
+func TestRoutePairCommands(t *testing.T) {
+	pairs := &recordingHandler{}
+	cr := &CommandRegistry{
+		bot:    &Bot{API: &tgbotapi.BotAPI{Self: tgbotapi.User{UserName: "botname"}}},
+		byName: make(map[key.HandlerName]Command),
+		pairs:  pairs,
+	}
+
+	// Telegram ends the bot_command entity at the "-", so the entity is only "/en"
+	for _, text := range []string{"/en-fa", "/en-fa@botname", "/EN-FA@BotName hello"} {
+		pairs.texts = nil
+		cr.route(&Context{Message: commandMessage(text, len("/en")), ChatID: 1})
+		if len(pairs.texts) != 1 {
+			t.Errorf("route(%q) didn't reach the pair handler", text)
+		}
+	}
+
+	// A pair command addressed to another bot is ignored
+	pairs.texts = nil
+	cr.route(&Context{Message: commandMessage("/en-fa@otherbot", len("/en")), ChatID: 1})
+	if len(pairs.texts) != 0 {
+		t.Errorf("pair command for another bot was handled")
+	}
+}
+
+func TestPairCommand(t *testing.T) {
+	tests := []struct {
+		text     string
+		pair     string
+		username string
+		ok       bool
+	}{
+		{"/en-fa", "en-fa", "", true},
+		{"/En-Fa@botname", "en-fa", "botname", true},
+		{"/auto-fa extra words", "auto-fa", "", true},
+		{"/help", "help", "", false},
+		{"en-fa", "", "", false},
+		{"", "", "", false},
+	}
+	for _, test := range tests {
+		pair, username, ok := pairCommand(test.text)
+		if pair != test.pair || username != test.username || ok != test.ok {
+			t.Errorf("pairCommand(%q) = %q, %q, %t, want %q, %q, %t",
+				test.text, pair, username, ok, test.pair, test.username, test.ok)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package bot
+
+import (
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/mzfarshad/tlg_bot/internal/key"
+	"github.com/mzfarshad/tlg_bot/internal/setting"
+)
+
+// Kinds of updates, used in logs and metrics
+const (
+	commandUpdate      = "command"
+	messageUpdate      = "message"
+	callbackUpdate     = "callback_query"
+	inlineQueryUpdate  = "inline_query"
+	chosenResultUpdate = "chosen_inline_result"
+	otherUpdate        = "other"
+)
+
+// Context carries one update through the middleware chain to its handler,
+// together with what the middlewares found out about it.
+
+type Context struct {
+	Update   tgbotapi.Update
+	Kind     string                  // Kind of the update
+	User     *tgbotapi.User          // User who sent the update, nil for updates without a user
+	UserID   int                     // ID of the user, zero for updates without a user
+	ChatID   int64                   // ID of the chat, zero for updates outside a chat
+	ThreadID int                     // Forum topic of the message, zero outside a topic
+	Message  *tgbotapi.Message       // Message of the update, if any
+	Callback *tgbotapi.CallbackQuery // Callback query of the update, if any
+	Lang     key.Language            // Interface language of the user, resolved by the language middleware
+	Admin    bool                    // Whether the user is a bot admin, set by the access middleware
+	Started  time.Time               // When handling of the update started
+}
+
+// newContext creates the context of an update.
+
+func newContext(update tgbotapi.Update) *Context {
+	ctx := &Context{Update: update, Kind: otherUpdate, Lang: key.LangEN, Started: time.Now()}
+
+	switch {
+	case update.Message != nil:
+		ctx.Kind = messageUpdate
+		if update.Message.IsCommand() {
+			ctx.Kind = commandUpdate
+		}
+		ctx.Message = update.Message
+		ctx.User = update.Message.From
+		ctx.ChatID = update.Message.Chat.ID
+
+	case update.CallbackQuery != nil:
+		ctx.Kind = callbackUpdate
+		ctx.Callback = update.CallbackQuery
+		ctx.User = update.CallbackQuery.From
+		if update.CallbackQuery.Message != nil {
+			ctx.ChatID = update.CallbackQuery.Message.Chat.ID
+		}
+
+	case update.InlineQuery != nil:
+		ctx.Kind = inlineQueryUpdate
+		ctx.User = update.InlineQuery.From
+
+	case update.ChosenInlineResult != nil:
+		ctx.Kind = chosenResultUpdate
+		ctx.User = update.ChosenInlineResult.From
+	}
+
+	if ctx.User != nil {
+		ctx.UserID = int(ctx.User.ID)
+	}
+	return ctx
+}
+
+// topicMessage holds the forum topic fields of a message, which the Telegram library doesn't decode.
+
+type topicMessage struct {
+	ThreadID     int  `json:"message_thread_id"`
+	TopicMessage bool `json:"is_topic_message"`
+}
+
+// topicUpdate holds the messages of an update that can belong to a forum topic.
+
+type topicUpdate struct {
+	Message       *topicMessage `json:"message"`
+	CallbackQuery *struct {
+		Message *topicMessage `json:"message"`
+	} `json:"callback_query"`
+}
+
+// threadID returns the forum topic of the update's message, or zero if the message isn't in a topic.
+
+func (u topicUpdate) threadID() int {
+	message := u.Message
+	if message == nil && u.CallbackQuery != nil {
+		message = u.CallbackQuery.Message
+	}
+	if message == nil || !message.TopicMessage {
+		return 0
+	}
+	return message.ThreadID
+}
+
+// settings returns the target the settings of the update are looked up for.
+
+func (ctx *Context) settings() setting.Target {
+	return setting.Target{UserID: ctx.UserID, ChatID: ctx.ChatID, ThreadID: ctx.ThreadID}
+}
@@ -0,0 +1,231 @@
+package bot
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/mzfarshad/tlg_bot/internal/fsm"
+	"github.com/mzfarshad/tlg_bot/internal/key"
+	"github.com/mzfarshad/tlg_bot/internal/setting"
+	"github.com/mzfarshad/tlg_bot/internal/storange"
+)
+
+// Names of the conversation flows
+const (
+	pairSetupFlow     = "pairSetup"
+	historySearchFlow = "historySearch"
+	correctionFlow    = "correction"
+	glossaryFlow      = "glossary"
+)
+
+// States of the conversation flows
+const (
+	sourceState      = "source"      // Choosing the source language
+	targetState      = "target"      // Choosing the target language
+	searchState      = "search"      // Typing the name of a language
+	confirmState     = "confirm"     // Confirming the chosen pair
+	finishState      = "finish"      // Activating the saved pair
+	keywordState     = "keyword"     // Typing a history search keyword
+	correctionState  = "correction"  // Typing a corrected translation
+	termState        = "term"        // Typing a glossary term
+	translationState = "translation" // Typing the translation of a glossary term
+	importState      = "import"      // Sending a glossary CSV file
+)
+
+// Events moving the conversation flows along
+const (
+	sourceEvent  = "source"
+	targetEvent  = "target"
+	searchEvent  = "search"
+	confirmEvent = "confirm"
+	saveEvent    = "save"
+	doneEvent    = "done"
+	termEvent    = "term"
+	importEvent  = "import"
+)
+
+// conversationTimeout is the time a user has to answer one step of a conversation.
+const conversationTimeout = 15 * time.Minute
+
+// flows are the multi-step conversations of the bot.
+var flows = []fsm.Flow{
+	{
+		// Choosing and activating a language pair with the language picker
+		Name:  pairSetupFlow,
+		Start: sourceState,
+		States: []fsm.State{
+			{Name: sourceState, Expects: []fsm.Input{fsm.Callback}, Timeout: conversationTimeout,
+				On: map[string]string{sourceEvent: sourceState, targetEvent: targetState, searchEvent: searchState}},
+			{Name: targetState, Expects: []fsm.Input{fsm.Callback}, Timeout: conversationTimeout,
+				On: map[string]string{sourceEvent: sourceState, targetEvent: targetState, confirmEvent: confirmState,
+					searchEvent: searchState}},
+			{Name: searchState, Expects: []fsm.Input{fsm.Text, fsm.Callback}, Timeout: conversationTimeout,
+				On: map[string]string{sourceEvent: sourceState, targetEvent: targetState, confirmEvent: confirmState,
+					searchEvent: searchState}},
+			{Name: confirmState, Expects: []fsm.Input{fsm.Callback}, Timeout: conversationTimeout,
+				On: map[string]string{sourceEvent: sourceState, confirmEvent: confirmState, saveEvent: finishState}},
+			{Name: finishState, Expects: []fsm.Input{fsm.Callback}, Timeout: conversationTimeout,
+				On: map[string]string{doneEvent: fsm.End}},
+		},
+	},
+	{
+		// Searching the translation history
+		Name:  historySearchFlow,
+		Start: keywordState,
+		States: []fsm.State{
+			{Name: keywordState, Expects: []fsm.Input{fsm.Text}, Timeout: conversationTimeout,
+				On: map[string]string{searchEvent: fsm.End}},
+		},
+	},
+	{
+		// Suggesting a better translation for a translation reply
+		Name:  correctionFlow,
+		Start: correctionState,
+		States: []fsm.State{
+			{Name: correctionState, Expects: []fsm.Input{fsm.Text}, Timeout: conversationTimeout,
+				On: map[string]string{saveEvent: fsm.End}},
+		},
+	},
+	{
+		// Adding terms to the glossary, one by one or from a CSV file
+		Name:  glossaryFlow,
+		Start: termState,
+		States: []fsm.State{
+			{Name: termState, Expects: []fsm.Input{fsm.Text}, Timeout: conversationTimeout,
+				On: map[string]string{termEvent: translationState}},
+			{Name: translationState, Expects: []fsm.Input{fsm.Text}, Timeout: conversationTimeout,
+				On: map[string]string{saveEvent: fsm.End}},
+			{Name: importState, Expects: []fsm.Input{fsm.Document}, Timeout: conversationTimeout,
+				On: map[string]string{importEvent: fsm.End}},
+		},
+	},
+}
+
+// CreateConversations initializes the conversation state machine with the flows of the bot.
+// An invalid flow is returned as an error.
+
+func CreateConversations() (*fsm.Machine, error) {
+	machine := fsm.NewMachine(conversationStore{})
+
+	var errs []error
+	for _, flow := range flows {
+		errs = append(errs, machine.Register(flow))
+	}
+	return machine, errors.Join(errs...)
+}
+
+// conversationStore keeps the conversations in the database.
+
+type conversationStore struct{}
+
+// Load retrieves the conversation of a user in a chat.
+
+func (conversationStore) Load(userID int, chatID int64) (*fsm.Session, error) {
+	conversation, err := storange.GetConversation(userID, chatID)
+	if err != nil || conversation == nil {
+		return nil, err
+	}
+	return &fsm.Session{
+		UserID:  conversation.UserID,
+		ChatID:  conversation.ChatID,
+		Flow:    conversation.Flow,
+		State:   conversation.State,
+		Data:    conversation.Data,
+		Expires: conversation.ExpiresAt,
+	}, nil
+}
+
+// Save stores the conversation of a user in a chat.
+
+func (conversationStore) Save(session *fsm.Session) error {
+	return storange.SaveConversation(storange.Conversation{
+		UserID:    session.UserID,
+		ChatID:    session.ChatID,
+		Flow:      session.Flow,
+		State:     session.State,
+		Data:      session.Data,
+		ExpiresAt: session.Expires,
+	})
+}
+
+// Delete removes the conversation of a user in a chat.
+
+func (conversationStore) Delete(userID int, chatID int64) error {
+	return storange.ClearConversation(userID, chatID)
+}
+
+// conversation returns the user's running conversation of the flow, or nil if there is none.
+// It reports whether the conversation timed out, so the user can be told to start again.
+
+func (b *Bot) conversation(userID int, chatID int64, flow string) (*fsm.Session, bool) {
+
+	session, err := b.Conversations.Current(userID, chatID)
+	if errors.Is(err, fsm.ErrExpired) {
+		return nil, true
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, false
+	}
+	if session == nil || session.Flow != flow {
+		return nil, false
+	}
+	return session, false
+}
+
+// startConversation starts the flow in the given state, or its start state if state is empty.
+// It reports whether the conversation could be saved.
+
+func (b *Bot) startConversation(userID int, chatID int64, flow, state string, data map[string]string) bool {
+
+	var err error
+	if state == "" {
+		_, err = b.Conversations.Start(userID, chatID, flow, data)
+	} else {
+		_, err = b.Conversations.StartAt(userID, chatID, flow, state, data)
+	}
+	if err != nil {
+		log.Printf("error starting %s conversation: %v", flow, err)
+		return false
+	}
+	return true
+}
+
+// cancelConversation ends the user's conversation in the chat, if any.
+
+func (b *Bot) cancelConversation(userID int, chatID int64) {
+	if err := b.Conversations.Cancel(userID, chatID); err != nil {
+		log.Println(err)
+	}
+}
+
+// abandonTextInput ends a conversation waiting for typed input or a file when the user presses a button instead.
+// Conversations driven by buttons are left to their handlers.
+
+func (b *Bot) abandonTextInput(userID int, chatID int64) {
+
+	session, err := b.Conversations.Current(userID, chatID)
+	if err != nil || session == nil {
+		return
+	}
+	if !b.Conversations.Expects(session, fsm.Callback) {
+		b.cancelConversation(userID, chatID)
+	}
+}
+
+// showFlowMenu shows a menu of the menu tree as a step of a conversation.
+// Unlike menuInteraction it doesn't push the menu state, so leaving the conversation
+// returns to the menu the conversation was started from.
+
+func (mm *MenuManager) showFlowMenu(target setting.Target, menu key.MenuState, lang key.Language,
+	callback *tgbotapi.CallbackQuery) {
+
+	view, ok := mm.renderMenu(target, string(menu), lang)
+	if !ok {
+		log.Printf("menu %s of a conversation is not available to user %d", menu, target.UserID)
+		return
+	}
+	mm.showView(target.UserID, target.ChatID, view, callback)
+}
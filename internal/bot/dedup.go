@@ -0,0 +1,139 @@
+package bot
+
+import (
+	"log"
+	"sync"
+
+	"github.com/mzfarshad/tlg_bot/internal/storange"
+)
+
+// defaultDedupWindow is the number of processed update IDs remembered by default.
+const defaultDedupWindow = 10000
+
+// forgottenUpdate fills the slot of a forgotten update ID; Telegram's update IDs are positive.
+const forgottenUpdate = -1
+
+// dedup remembers the IDs of the last processed updates, so an update Telegram delivers again is dropped.
+// Telegram redelivers a webhook update when it doesn't get a quick answer, which would otherwise
+// translate a message twice or push a menu twice.
+
+type dedup struct {
+	mu      sync.Mutex
+	window  int              // Number of update IDs remembered
+	seen    map[int]struct{} // IDs in the window
+	order   []int            // IDs in the window, a ring buffer in the order they arrived
+	next    int              // Position in order of the next ID
+	persist bool             // Whether the IDs are saved in the database too, so the window survives restarts
+}
+
+// newDedup creates a window of the last window update IDs, in memory only.
+
+func newDedup(window int) *dedup {
+	if window <= 0 {
+		window = defaultDedupWindow
+	}
+	return &dedup{window: window, seen: make(map[int]struct{}, window), order: make([]int, 0, window)}
+}
+
+// load fills the window with the update IDs saved in the database and saves the later ones too.
+
+func (d *dedup) load() error {
+	ids, err := storange.GetProcessedUpdates(d.window)
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, id := range ids {
+		d.add(id)
+	}
+	d.persist = true
+	return nil
+}
+
+// first records an update ID and reports whether it's the first delivery of the update.
+
+func (d *dedup) first(updateID int) bool {
+
+	d.mu.Lock()
+	if _, exist := d.seen[updateID]; exist {
+		d.mu.Unlock()
+		return false
+	}
+	d.add(updateID)
+	persist := d.persist
+	d.mu.Unlock()
+
+	if persist {
+		if err := storange.SaveProcessedUpdate(updateID, d.window); err != nil {
+			log.Println(err)
+		}
+	}
+	return true
+}
+
+// add puts an ID in the window, pushing the oldest one out of a full window. The lock must be held.
+
+func (d *dedup) add(updateID int) {
+	if _, exist := d.seen[updateID]; exist {
+		return
+	}
+	if len(d.order) < d.window {
+		d.order = append(d.order, updateID)
+	} else {
+		delete(d.seen, d.order[d.next])
+		d.order[d.next] = updateID
+	}
+	d.next = (d.next + 1) % d.window
+	d.seen[updateID] = struct{}{}
+}
+
+// forget removes an update ID, so the update is processed when Telegram delivers it again,
+// such as an update refused because the queue was full.
+
+func (d *dedup) forget(updateID int) {
+
+	d.mu.Lock()
+	delete(d.seen, updateID)
+	// Empty its slot, so pushing the slot out doesn't forget the ID once it's added again
+	for i, id := range d.order {
+		if id == updateID {
+			d.order[i] = forgottenUpdate
+		}
+	}
+	persist := d.persist
+	d.mu.Unlock()
+
+	if persist {
+		if err := storange.DeleteProcessedUpdate(updateID); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// DeduplicateUpdates sets the number of processed update IDs remembered to drop updates delivered again.
+// With persist set the IDs are saved in the database too, and the saved ones are loaded,
+// so updates delivered again after a restart are dropped as well.
+
+func (b *Bot) DeduplicateUpdates(window int, persist bool) error {
+	updates := newDedup(window)
+	if persist {
+		if err := updates.load(); err != nil {
+			return err
+		}
+	}
+	b.updates = updates
+	return nil
+}
+
+// firstDelivery reports whether an update is delivered for the first time. Updates delivered again
+// are logged and counted as duplicates.
+
+func (b *Bot) firstDelivery(ctx *Context) bool {
+	if b.updates.first(ctx.Update.UpdateID) {
+		return true
+	}
+	log.Printf("dropping duplicate %s update %d", ctx.Kind, ctx.Update.UpdateID)
+	b.Metrics.duplicated(ctx.Kind)
+	return false
+}
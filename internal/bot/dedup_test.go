@@ -0,0 +1,102 @@
+package bot
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDedupDropsRepeatedUpdates(t *testing.T) {
+	d := newDedup(10)
+	for _, id := range []int{1, 2, 3} {
+		if !d.first(id) {
+			t.Errorf("first delivery of update %d taken for a duplicate", id)
+		}
+	}
+	for _, id := range []int{2, 1, 3} {
+		if d.first(id) {
+			t.Errorf("repeated update %d wasn't dropped", id)
+		}
+	}
+}
+
+func TestDedupEvictsOldest(t *testing.T) {
+	d := newDedup(3)
+	for _, id := range []int{1, 2, 3, 4} {
+		d.first(id)
+	}
+	// 1 was pushed out of the window by 4, which in turn pushes 2 out
+	if !d.first(1) {
+		t.Error("update 1 is still remembered after the window is full")
+	}
+	for _, id := range []int{3, 4, 1} {
+		if d.first(id) {
+			t.Errorf("update %d in the window wasn't dropped", id)
+		}
+	}
+	if !d.first(2) {
+		t.Error("update 2 is still remembered after it was pushed out")
+	}
+	if len(d.seen) != 3 {
+		t.Errorf("window holds %d IDs, want 3", len(d.seen))
+	}
+}
+
+func TestDedupForget(t *testing.T) {
+	d := newDedup(3)
+	d.first(1)
+	d.first(2)
+	d.forget(1)
+	if !d.first(1) {
+		t.Fatal("forgotten update wasn't handled again")
+	}
+	if d.first(1) {
+		t.Fatal("update added again after forget wasn't dropped")
+	}
+
+	// The window now holds 2 and 1; the emptied slot of the first 1 must not take 1 with it
+	d.first(3)
+	if d.first(1) {
+		t.Error("pushing out the slot of a forgotten update forgot it again")
+	}
+}
+
+func TestDuplicatesCounted(t *testing.T) {
+	b := &Bot{Metrics: newMetrics(), updates: newDedup(10)}
+	handled := 0
+	b.dispatch = func(ctx *Context) { handled++ }
+
+	body := []byte(`{"update_id":7,"message":{"message_id":1,"text":"hi","chat":{"id":1}}}`)
+	for i := 0; i < 3; i++ {
+		if err := b.HandleUpdateJSON(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if handled != 1 {
+		t.Errorf("update handled %d times, want 1", handled)
+	}
+	if stats := b.Metrics.Snapshot().Updates[messageUpdate]; stats.Duplicates != 2 {
+		t.Errorf("duplicates = %d, want 2", stats.Duplicates)
+	}
+}
+
+func TestPanicForgetsUpdate(t *testing.T) {
+	b := &Bot{Metrics: newMetrics(), updates: newDedup(10)}
+	calls := 0
+	b.dispatch = chain(func(ctx *Context) {
+		calls++
+		if calls == 1 {
+			panic(fmt.Sprintf("handler failed on update %d", ctx.Update.UpdateID))
+		}
+	}, b.recoverPanics)
+
+	body := []byte(`{"update_id":8,"message":{"message_id":1,"text":"hi","chat":{"id":1}}}`)
+	for i := 0; i < 3; i++ {
+		if err := b.HandleUpdateJSON(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The redelivery after the panic is handled, the one after it is a duplicate
+	if calls != 2 {
+		t.Errorf("handler called %d times, want 2", calls)
+	}
+}
@@ -0,0 +1,155 @@
+package bot
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/mzfarshad/tlg_bot/internal/deeplink"
+	"github.com/mzfarshad/tlg_bot/internal/key"
+	"github.com/mzfarshad/tlg_bot/internal/setting"
+	"github.com/mzfarshad/tlg_bot/internal/storange"
+)
+
+// checkStartPayload reports an error if the value of a start payload can't be applied,
+// so neither crafted links nor links created by mistake change the settings of a user.
+
+func checkStartPayload(payload deeplink.Payload) error {
+
+	switch payload.Kind {
+	case deeplink.Language:
+		if !key.Supported(key.Language(payload.Value)) {
+			return fmt.Errorf("unknown bot language %q", payload.Value)
+		}
+
+	case deeplink.Pair:
+		source, target, found := strings.Cut(payload.Value, "-")
+		if !found {
+			return fmt.Errorf("language pair %q has no target language", payload.Value)
+		}
+		if !validPair(source, target) {
+			return fmt.Errorf("invalid language pair %q", payload.Value)
+		}
+
+	case deeplink.Referral:
+		if id, err := strconv.Atoi(payload.Value); err != nil || id <= 0 {
+			return fmt.Errorf("invalid referrer id %q", payload.Value)
+		}
+	}
+	return nil
+}
+
+// applyStartPayload applies the payload of a deep link the user started the bot with.
+// Invalid payloads are logged and ignored. It reports whether a menu was shown for the payload;
+// otherwise the caller shows the main menu.
+
+func (b *Bot) applyStartPayload(ctx *Context, encoded string) bool {
+
+	payload, err := deeplink.Decode(encoded)
+	if err == nil {
+		payload.Value = strings.ToLower(payload.Value)
+		err = checkStartPayload(payload)
+	}
+	if err != nil {
+		log.Printf("ignored start payload %q of user %d: %v", encoded, ctx.UserID, err)
+		return false
+	}
+
+	switch payload.Kind {
+	case deeplink.Language:
+		if err := setting.SaveBotLanguage(setting.User, ctx.settings(), key.Language(payload.Value)); err != nil {
+			log.Printf("error saving bot language: %v", err)
+			return false
+		}
+		ctx.Lang = key.Language(payload.Value)
+
+	case deeplink.Pair:
+		// The pair setup is shown on top of the main menu, so leaving it leads to the main menu
+		if err := pushState(ctx.UserID, ctx.ChatID, b.MenuManager.root); err != nil {
+			log.Println(err)
+		}
+		source, target, _ := strings.Cut(payload.Value, "-")
+		b.activatePair(ctx, source, target)
+		return true
+
+	case deeplink.Referral:
+		referrerID, _ := strconv.Atoi(payload.Value)
+		if referrerID == ctx.UserID {
+			return false
+		}
+		// Only users new to the bot are credited to the referrer
+		known, err := setting.HasOwnSettings(ctx.UserID)
+		if err != nil {
+			log.Println(err)
+			return false
+		}
+		if known {
+			log.Printf("ignored referral of user %d by user %d: not a new user", ctx.UserID, referrerID)
+			return false
+		}
+		recorded, err := storange.SaveReferral(ctx.UserID, referrerID)
+		if err != nil {
+			log.Println(err)
+		} else if recorded {
+			log.Printf("user %d was referred by user %d", ctx.UserID, referrerID)
+		}
+	}
+	return false
+}
+
+// InviteCommandHandler handles the /invite command.
+
+type InviteCommandHandler struct {
+	bot *Bot
+}
+
+// Handle sends the user's referral link and the number of users who joined with it.
+
+func (h *InviteCommandHandler) Handle(ctx *Context) {
+
+	payload, err := deeplink.Encode(deeplink.Referral, strconv.Itoa(ctx.UserID))
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	count, err := storange.CountReferrals(ctx.UserID)
+	if err != nil {
+		log.Println(err)
+	}
+
+	link := deeplink.Link(h.bot.API.Self.UserName, payload)
+	text := key.Format(ctx.Lang, key.InviteMessage, key.Params{"link": link, "count": count})
+	h.bot.API.Send(tgbotapi.NewMessage(ctx.ChatID, text))
+}
+
+// LinkCommandHandler handles the /link command, available to admins only.
+
+type LinkCommandHandler struct {
+	bot *Bot
+}
+
+// Handle creates a deep link setting a language pair or the bot language, such as /link pair fa-en or /link lang fa.
+
+func (h *LinkCommandHandler) Handle(ctx *Context) {
+
+	kind, value, _ := strings.Cut(strings.TrimSpace(ctx.Message.CommandArguments()), " ")
+	payload := deeplink.Payload{Kind: strings.ToLower(kind), Value: strings.ToLower(strings.TrimSpace(value))}
+
+	var encoded string
+	err := checkStartPayload(payload)
+	if err == nil && payload.Kind != deeplink.Pair && payload.Kind != deeplink.Language {
+		err = fmt.Errorf("links of kind %q can't be created with /link", payload.Kind)
+	}
+	if err == nil {
+		encoded, err = deeplink.Encode(payload.Kind, payload.Value)
+	}
+	if err != nil {
+		log.Println(err)
+		h.bot.API.Send(tgbotapi.NewMessage(ctx.ChatID, key.GetMenuMessage(ctx.Lang, key.LinkUsageMessage)))
+		return
+	}
+
+	h.bot.API.Send(tgbotapi.NewMessage(ctx.ChatID, deeplink.Link(h.bot.API.Self.UserName, encoded)))
+}
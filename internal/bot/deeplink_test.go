@@ -0,0 +1,65 @@
+package bot
+
+import (
+	"testing"
+
+	"github.com/mzfarshad/tlg_bot/internal/deeplink"
+	"github.com/mzfarshad/tlg_bot/internal/key"
+	"github.com/mzfarshad/tlg_bot/internal/setting"
+	"github.com/mzfarshad/tlg_bot/internal/storange"
+)
+
+func TestCheckStartPayload(t *testing.T) {
+	tests := []struct {
+		payload deeplink.Payload
+		valid   bool
+	}{
+		{deeplink.Payload{Kind: deeplink.Pair, Value: "fa-en"}, true},
+		{deeplink.Payload{Kind: deeplink.Pair, Value: "auto-en"}, true},
+		{deeplink.Payload{Kind: deeplink.Pair, Value: "fa-fa"}, false},
+		{deeplink.Payload{Kind: deeplink.Pair, Value: "en-auto"}, false},
+		{deeplink.Payload{Kind: deeplink.Pair, Value: "fa"}, false},
+		{deeplink.Payload{Kind: deeplink.Language, Value: "fa"}, true},
+		{deeplink.Payload{Kind: deeplink.Language, Value: "xx"}, false},
+		{deeplink.Payload{Kind: deeplink.Referral, Value: "42"}, true},
+		{deeplink.Payload{Kind: deeplink.Referral, Value: "-1"}, false},
+	}
+	for _, test := range tests {
+		if err := checkStartPayload(test.payload); (err == nil) != test.valid {
+			t.Errorf("checkStartPayload(%s %q) = %v, want valid %t", test.payload.Kind, test.payload.Value, err, test.valid)
+		}
+	}
+}
+
+func TestReferralOnlyForNewUsers(t *testing.T) {
+	useTestDB(t)
+	b := &Bot{}
+
+	referral, err := deeplink.Encode(deeplink.Referral, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := func(userID int) {
+		b.applyStartPayload(&Context{UserID: userID, ChatID: int64(userID), Lang: key.LangEN}, referral)
+	}
+
+	// A new user whose language was only inferred from their Telegram client
+	if _, err := setting.InferBotLanguage(2, "fa"); err != nil {
+		t.Fatal(err)
+	}
+	start(2)
+
+	// A user who chose a language pair before opening the link
+	if err := setting.SaveLanguagePairs(setting.User, setting.ForUser(3), "en", "fa"); err != nil {
+		t.Fatal(err)
+	}
+	start(3)
+
+	count, err := storange.CountReferrals(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("referrals of user 1 = %d, want 1 for the new user only", count)
+	}
+}
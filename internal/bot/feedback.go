@@ -0,0 +1,361 @@
+package bot
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/mzfarshad/tlg_bot/internal/bidi"
+	"github.com/mzfarshad/tlg_bot/internal/cbdata"
+	"github.com/mzfarshad/tlg_bot/internal/fsm"
+	"github.com/mzfarshad/tlg_bot/internal/key"
+	"github.com/mzfarshad/tlg_bot/internal/richtext"
+	"github.com/mzfarshad/tlg_bot/internal/storange"
+	"github.com/mzfarshad/tlg_bot/internal/translation"
+)
+
+// Actions of the feedback buttons carried in the callback data ("feedback|<action>|<args>...")
+const (
+	feedbackUpAction      = "up"  // feedback|up
+	feedbackDownAction    = "dn"  // feedback|dn
+	feedbackFixAction     = "fix" // feedback|fix
+	feedbackStatsAction   = "st"  // feedback|st
+	feedbackReviewAction  = "rev" // feedback|rev
+	feedbackApproveAction = "ok"  // feedback|ok|<id>
+	feedbackRejectAction  = "no"  // feedback|no|<id>
+)
+
+// Retention of the translation replies the feedback buttons refer to
+const (
+	translationReplyTTL             = 30 * 24 * time.Hour // Time after which a reply can't be rated anymore
+	translationReplyCleanupInterval = 6 * time.Hour       // Time between removals of the expired replies
+)
+
+// feedbackCallback builds the callback data for a feedback button.
+
+func feedbackCallback(args ...any) string {
+	return callbackData(string(key.FeedbackHandler), args...)
+}
+
+// feedbackRow creates the rating and correction buttons attached to a translation reply.
+// The buttons carry only their action; the provider and scores are stored with the reply by saveTranslationReply,
+// so they can't be made up by a client sending its own callback data.
+
+func feedbackRow(lang key.Language) []tgbotapi.InlineKeyboardButton {
+	return tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(key.GetKey(lang, key.KeyThumbsUp), feedbackCallback(feedbackUpAction)),
+		tgbotapi.NewInlineKeyboardButtonData(key.GetKey(lang, key.KeyThumbsDown), feedbackCallback(feedbackDownAction)),
+		tgbotapi.NewInlineKeyboardButtonData(key.GetKey(lang, key.KeySuggestCorrection), feedbackCallback(feedbackFixAction)),
+	)
+}
+
+// saveTranslationReply stores the translation sent in a reply, for the feedback given with its buttons.
+
+func saveTranslationReply(reply tgbotapi.Message, sourceText, source, target string, result *translation.Result) {
+	err := storange.SaveTranslationReply(storange.TranslationReply{
+		ChatID:         reply.Chat.ID,
+		MessageID:      reply.MessageID,
+		SourceText:     strings.TrimSpace(sourceText),
+		TranslatedText: result.Text,
+		SourceLanguage: source,
+		TargetLanguage: target,
+		Provider:       result.Provider,
+		Score:          math.Round(result.Score),
+		Quality:        math.Round(result.Quality),
+		CreatedAt:      time.Now(),
+	})
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// expireTranslationReplies removes the translation replies too old to be rated, periodically.
+
+func expireTranslationReplies() {
+	for range time.Tick(translationReplyCleanupInterval) {
+		n, err := storange.DeleteExpiredTranslationReplies(translationReplyTTL)
+		if err != nil {
+			log.Println(err)
+		} else if n > 0 {
+			log.Printf("removed %d expired translation replies", n)
+		}
+	}
+}
+
+// translate translates the text for the user. A term of the user's glossary is translated as defined there;
+// otherwise an approved correction for the same text and pair is reused if there is one.
+
+func translate(userID int, text, source, target string) (*translation.Result, error) {
+
+	// Text copied from the bot's messages can carry the isolates the bot added
+	text = bidi.Strip(text)
+
+	term, ok, err := storange.FindGlossaryTerm(userID, source, target, text)
+	if err != nil {
+		log.Println(err)
+	}
+	if ok {
+		return &translation.Result{Text: term, Provider: translation.ProviderGlossary, Score: 100, Quality: 100}, nil
+	}
+
+	correction, ok, err := storange.FindApprovedCorrection(text, source, target)
+	if err != nil {
+		log.Println(err)
+	}
+	if ok {
+		return &translation.Result{Text: correction, Provider: translation.ProviderCorrection, Score: 100, Quality: 100}, nil
+	}
+
+	return translation.TranslateText(text, source, target)
+}
+
+// isAdmin reports whether the user is one of the bot admins.
+
+func (b *Bot) isAdmin(userID int64) bool {
+	for _, id := range b.Admins {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// feedbackStatsView renders the feedback summary per provider and language pair for admins.
+
+func feedbackStatsView(lang key.Language) (richtext.Message, tgbotapi.InlineKeyboardMarkup) {
+
+	stats, err := storange.GetFeedbackStats()
+	if err != nil {
+		log.Println(err)
+	}
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(key.GetKey(lang, key.KeyReviewCorrections), feedbackCallback(feedbackReviewAction)),
+		),
+	)
+	if len(stats) == 0 {
+		return richtext.PlainText(key.GetMenuMessage(lang, key.FeedbackStatsEmptyMessage)), keyboard
+	}
+
+	text := newText().Bold(key.GetMenuMessage(lang, key.FeedbackStatsMessage))
+	for _, stat := range stats {
+		text.Line().Line().Bold(stat.Provider + " · " + pairLabel(stat.Source, stat.Target)).
+			Text(fmt.Sprintf("\n👍 %d  👎 %d  ✏️ %d/%d  ⌀ %.1f", stat.ThumbsUp, stat.ThumbsDown,
+				stat.ApprovedCorrections, stat.Corrections, stat.AverageScore))
+	}
+	return text.Message(), keyboard
+}
+
+// correctionReviewView renders the oldest correction waiting for review.
+
+func correctionReviewView(lang key.Language) (richtext.Message, tgbotapi.InlineKeyboardMarkup) {
+
+	backRow := tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(key.GetKey(lang, key.KeyBack), feedbackCallback(feedbackStatsAction)),
+	)
+
+	feedback, err := storange.GetPendingCorrection()
+	if err != nil {
+		log.Println(err)
+	}
+	if feedback == nil {
+		return richtext.PlainText(key.GetMenuMessage(lang, key.NoPendingCorrectionsMessage)), tgbotapi.NewInlineKeyboardMarkup(backRow)
+	}
+
+	text := newText().
+		Bold(fmt.Sprintf("%s · %s · ⌀ %.0f", feedback.Provider, pairLabel(feedback.SourceLanguage, feedback.TargetLanguage), feedback.Score)).
+		Line().Line().Quote(bidi.Isolate(feedback.SourceText)).
+		Line().Text("➜ " + bidi.Isolate(feedback.TranslatedText)).
+		Line().Line().Text("✏️ ").Bold(bidi.Isolate(feedback.Correction))
+
+	id := feedback.ID
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(key.GetKey(lang, key.KeyApprove), feedbackCallback(feedbackApproveAction, id)),
+			tgbotapi.NewInlineKeyboardButtonData(key.GetKey(lang, key.KeyReject), feedbackCallback(feedbackRejectAction, id)),
+		),
+		backRow,
+	)
+	return text.Message(), keyboard
+}
+
+// FeedbackCommandHandler handles the /feedback command, available to admins only.
+
+type FeedbackCommandHandler struct {
+	bot *Bot
+}
+
+// Handle sends the feedback summary to an admin.
+
+func (h *FeedbackCommandHandler) Handle(ctx *Context) {
+
+	if !ctx.Admin {
+		h.bot.API.Send(tgbotapi.NewMessage(ctx.ChatID, key.GetMenuMessage(ctx.Lang, key.AdminOnlyMessage)))
+		return
+	}
+
+	text, keyboard := feedbackStatsView(ctx.Lang)
+	message := tgbotapi.NewMessage(ctx.ChatID, "")
+	message.ReplyMarkup = keyboard
+	h.bot.sendText(message, text)
+}
+
+// FeedbackHandler handles the rating buttons of translation replies and the admin review buttons.
+
+type FeedbackHandler struct {
+	bot *Bot
+}
+
+// Handle performs the feedback action carried in the callback data.
+
+func (h *FeedbackHandler) Handle(ctx *Context, args cbdata.Args) {
+
+	if len(args) == 0 {
+		log.Printf("feedback callback without action: %s", ctx.Callback.Data)
+		return
+	}
+
+	switch args[0] {
+	case feedbackUpAction, feedbackDownAction, feedbackFixAction:
+		h.rate(ctx.ChatID, ctx.Callback, args, ctx.Lang)
+
+	case feedbackStatsAction, feedbackReviewAction, feedbackApproveAction, feedbackRejectAction:
+		if !ctx.Admin {
+			log.Printf("feedback review by non-admin user %d: %s", ctx.UserID, ctx.Callback.Data)
+			h.bot.answerCallback(ctx.Callback, key.GetMenuMessage(ctx.Lang, key.AdminOnlyMessage), true)
+			return
+		}
+		h.review(ctx.Callback, args, ctx.Lang)
+
+	default:
+		log.Printf("unknown feedback action: %s", ctx.Callback.Data)
+	}
+}
+
+// rate stores the rating of a translation reply, or starts the correction flow.
+// The rated translation is the one stored when the reply was sent.
+
+func (h *FeedbackHandler) rate(chatID int64, callback *tgbotapi.CallbackQuery, args cbdata.Args, lang key.Language) {
+
+	reply := callback.Message
+	sent, err := storange.GetTranslationReply(chatID, reply.MessageID)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if sent == nil {
+		log.Printf("feedback on unknown translation reply %d in chat %d", reply.MessageID, chatID)
+		h.bot.answerCallback(callback, key.GetMenuMessage(lang, key.OutdatedMenuMessage), true)
+		return
+	}
+
+	var rating int
+	switch args[0] {
+	case feedbackUpAction:
+		rating = 1
+	case feedbackDownAction:
+		rating = -1
+	}
+
+	userID := int(callback.From.ID)
+	feedbackID, err := storange.SaveFeedback(storange.Feedback{
+		UserID:         userID,
+		ChatID:         chatID,
+		MessageID:      reply.MessageID,
+		SourceText:     sent.SourceText,
+		TranslatedText: sent.TranslatedText,
+		SourceLanguage: sent.SourceLanguage,
+		TargetLanguage: sent.TargetLanguage,
+		Provider:       sent.Provider,
+		Score:          sent.Score,
+		Quality:        sent.Quality,
+		Rating:         rating,
+		CreatedAt:      time.Now(),
+	})
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	if rating != 0 {
+		// Show which rating was given; the button stays inactive afterwards
+		label := "✅ " + key.GetKey(lang, key.KeyThumbsDown)
+		if rating > 0 {
+			label = "✅ " + key.GetKey(lang, key.KeyThumbsUp)
+		}
+		keyboard := markButton(reply.ReplyMarkup, callback.Data, label)
+		h.bot.editKeyboard(chatID, reply.MessageID, keyboard)
+		h.bot.answerCallback(callback, key.GetMenuMessage(lang, key.FeedbackThanksMessage), false)
+		return
+	}
+
+	// Wait for the corrected translation as the next text message
+	data := map[string]string{"feedback": strconv.FormatInt(feedbackID, 10)}
+	if !h.bot.startConversation(userID, chatID, correctionFlow, "", data) {
+		return
+	}
+	text := key.Format(lang, key.SuggestCorrectionMessage, key.Params{"text": sent.TranslatedText})
+	h.bot.API.Send(tgbotapi.NewMessage(chatID, text))
+}
+
+// review shows the feedback summary or the correction review, applying an approval or rejection first.
+
+func (h *FeedbackHandler) review(callback *tgbotapi.CallbackQuery, args cbdata.Args, lang key.Language) {
+
+	switch args[0] {
+	case feedbackStatsAction:
+		text, keyboard := feedbackStatsView(lang)
+		h.bot.editCallbackMessage(callback, text, keyboard)
+		return
+
+	case feedbackApproveAction, feedbackRejectAction:
+		if len(args) < 2 {
+			log.Printf("feedback review without id: %s", callback.Data)
+			return
+		}
+		id, err := args.Int64(1)
+		if err != nil {
+			log.Printf("invalid feedback id: %s", callback.Data)
+			return
+		}
+		if err := storange.ReviewCorrection(id, args[0] == feedbackApproveAction); err != nil {
+			log.Println(err)
+		}
+	}
+
+	text, keyboard := correctionReviewView(lang)
+	h.bot.editCallbackMessage(callback, text, keyboard)
+}
+
+// CorrectionInput handles the corrected translation typed after pressing the suggest correction button.
+
+type CorrectionInput struct {
+	bot *Bot
+}
+
+// HandleInput attaches the correction to the feedback whose ID is kept in the conversation.
+
+func (h *CorrectionInput) HandleInput(ctx *Context, session *fsm.Session) {
+
+	userID := ctx.UserID
+	if err := h.bot.Conversations.Fire(session, saveEvent); err != nil {
+		log.Println(err)
+	}
+
+	feedbackID, err := strconv.ParseInt(session.Get("feedback"), 10, 64)
+	if err != nil {
+		log.Printf("invalid feedback correction conversation: %v", session.Data)
+		return
+	}
+	if err := storange.SaveCorrection(userID, feedbackID, strings.TrimSpace(ctx.Message.Text)); err != nil {
+		log.Println(err)
+		return
+	}
+
+	h.bot.API.Send(tgbotapi.NewMessage(ctx.ChatID, key.GetMenuMessage(ctx.Lang, key.CorrectionThanksMessage)))
+}
@@ -0,0 +1,61 @@
+package bot
+
+import (
+	"log"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/mzfarshad/tlg_bot/internal/richtext"
+	"github.com/mzfarshad/tlg_bot/internal/translation"
+)
+
+// messageMode is the parse mode of the formatted messages of the bot.
+const messageMode = richtext.HTML
+
+// newText returns a builder for a formatted message of the bot.
+
+func newText() *richtext.Builder {
+	return richtext.New(messageMode)
+}
+
+// isParseError reports whether Telegram rejected the formatting of a message.
+
+func isParseError(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "can't parse entities")
+}
+
+// sendText sends the message with the formatted text. If Telegram can't parse the formatting,
+// the message is sent again as plain text.
+
+func (b *Bot) sendText(message tgbotapi.MessageConfig, text richtext.Message) (tgbotapi.Message, error) {
+	message.Text, message.ParseMode = text.Text, string(text.Mode)
+	sent, err := b.API.Send(message)
+	if isParseError(err) && text.Mode != richtext.Plain {
+		log.Printf("error formatting message, sending it as plain text: %v", err)
+		message.Text, message.ParseMode = text.Plain, ""
+		sent, err = b.API.Send(message)
+	}
+	return sent, err
+}
+
+// targetLabel returns the label of a translation, the name of its language written in the language,
+// such as "English:".
+
+func targetLabel(target string) string {
+	if l, ok := translation.LanguageByCode(target); ok {
+		return l.Native + ":"
+	}
+	return target + ":"
+}
+
+// replyTranslation returns the translation of a translation reply without its label.
+
+func replyTranslation(reply *tgbotapi.Message, target string) string {
+	return strings.TrimPrefix(reply.Text, targetLabel(target)+"\n")
+}
+
+// title returns a message made of a bold title, the way menus show their message.
+
+func title(text string) richtext.Message {
+	return newText().Bold(text).Message()
+}
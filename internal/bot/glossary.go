@@ -0,0 +1,373 @@
+package bot
+
+import (
+	"encoding/csv"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/mzfarshad/tlg_bot/internal/bidi"
+	"github.com/mzfarshad/tlg_bot/internal/cbdata"
+	"github.com/mzfarshad/tlg_bot/internal/fsm"
+	"github.com/mzfarshad/tlg_bot/internal/key"
+	"github.com/mzfarshad/tlg_bot/internal/richtext"
+	"github.com/mzfarshad/tlg_bot/internal/setting"
+	"github.com/mzfarshad/tlg_bot/internal/storange"
+)
+
+// glossaryPageSize is the number of terms listed on one page of the glossary.
+const glossaryPageSize = 10
+
+// glossaryMaxFileSize is the largest CSV file accepted for a glossary import, in bytes.
+const glossaryMaxFileSize = 1 << 20
+
+// Actions of the glossary carried in the callback data ("glossary|<action>|<args>...")
+const (
+	glossaryListAction   = "l" // glossary|l|<source>|<target>|<page>
+	glossaryDeleteAction = "d" // glossary|d|<id>|<source>|<target>|<page>
+	glossaryAddAction    = "a" // glossary|a|<source>|<target>
+	glossaryImportAction = "i" // glossary|i|<source>|<target>
+)
+
+// glossaryCallback builds the callback data for a glossary button.
+
+func glossaryCallback(args ...any) string {
+	return callbackData(string(key.GlossaryHandler), args...)
+}
+
+// glossaryView renders one page of the user's glossary for a language pair.
+
+func glossaryView(userID int, lang key.Language, source, target string, page int) (richtext.Message, tgbotapi.InlineKeyboardMarkup) {
+
+	if page < 0 {
+		page = 0
+	}
+	terms, total, err := storange.GetGlossary(userID, source, target, page*glossaryPageSize, glossaryPageSize)
+	if err != nil {
+		log.Println(err)
+	}
+
+	pages := (total + glossaryPageSize - 1) / glossaryPageSize
+	if len(terms) == 0 && page > 0 && total > 0 {
+		// The last term of the page was deleted
+		page = pages - 1
+		terms, _, err = storange.GetGlossary(userID, source, target, page*glossaryPageSize, glossaryPageSize)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	text := newText()
+
+	if total == 0 {
+		text.Text(key.Format(lang, key.GlossaryEmptyMessage, key.Params{"pair": pairLabel(source, target)}))
+	} else {
+		text.Bold(key.Format(lang, key.GlossaryMessage, key.Params{"pair": pairLabel(source, target), "page": page + 1, "pages": pages}))
+
+		var deleteRow []tgbotapi.InlineKeyboardButton
+		for i, term := range terms {
+			number := page*glossaryPageSize + i + 1
+			text.Line().Line().Text(formatNumber(lang, number) + ". ").Bold(bidi.Isolate(term.Term)).
+				Text(" ➜ " + bidi.Isolate(term.Translation))
+
+			deleteRow = append(deleteRow, tgbotapi.NewInlineKeyboardButtonData("🗑 "+formatNumber(lang, number),
+				glossaryCallback(glossaryDeleteAction, term.ID, source, target, page)))
+			if len(deleteRow) == 5 {
+				rows = append(rows, deleteRow)
+				deleteRow = nil
+			}
+		}
+		if len(deleteRow) > 0 {
+			rows = append(rows, deleteRow)
+		}
+	}
+
+	if pages > 1 {
+		var nav []tgbotapi.InlineKeyboardButton
+		if page > 0 {
+			nav = append(nav, tgbotapi.NewInlineKeyboardButtonData(key.GetKey(lang, key.KeyPreviousPage),
+				glossaryCallback(glossaryListAction, source, target, page-1)))
+		}
+		nav = append(nav, tgbotapi.NewInlineKeyboardButtonData(
+			pageLabel(lang, page, pages), callbackData(string(key.NoopHandler))))
+		if page < pages-1 {
+			nav = append(nav, tgbotapi.NewInlineKeyboardButtonData(key.GetKey(lang, key.KeyNextPage),
+				glossaryCallback(glossaryListAction, source, target, page+1)))
+		}
+		rows = append(rows, nav)
+	}
+
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(key.GetKey(lang, key.KeyAddGlossaryTerm),
+			glossaryCallback(glossaryAddAction, source, target)),
+		tgbotapi.NewInlineKeyboardButtonData(key.GetKey(lang, key.KeyImportGlossary),
+			glossaryCallback(glossaryImportAction, source, target)),
+	))
+
+	return text.Message(), tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// sendGlossary sends the first page of the user's glossary for a language pair.
+
+func (b *Bot) sendGlossary(chatID int64, userID int, lang key.Language, source, target string) {
+	text, keyboard := glossaryView(userID, lang, source, target, 0)
+	message := tgbotapi.NewMessage(chatID, "")
+	message.ReplyMarkup = keyboard
+	b.sendText(message, text)
+}
+
+// parseGlossaryCSV reads glossary terms from a CSV file with the term in the first column
+// and its translation in the second. Rows with an empty column and a "term,translation" header are skipped.
+
+func parseGlossaryCSV(r io.Reader) ([][2]string, error) {
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	var terms [][2]string
+	for line := 0; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) < 2 {
+			continue
+		}
+
+		term := strings.TrimSpace(strings.TrimPrefix(record[0], "\ufeff"))
+		translated := strings.TrimSpace(record[1])
+		if line == 0 && strings.EqualFold(term, "term") && strings.EqualFold(translated, "translation") {
+			continue
+		}
+		if term != "" && translated != "" {
+			terms = append(terms, [2]string{term, translated})
+		}
+	}
+	return terms, nil
+}
+
+// GlossaryCommandHandler handles the /glossary command.
+
+type GlossaryCommandHandler struct {
+	bot *Bot
+}
+
+// Handle sends the user's glossary for the active language pair.
+
+func (h *GlossaryCommandHandler) Handle(ctx *Context) {
+
+	userID := ctx.UserID
+	current, err := setting.GetTranslationSetting(ctx.settings())
+	if err != nil || !validPair(current.SourceLanguage, current.TargetLanguage) {
+		if err != nil {
+			log.Println(err)
+		}
+		h.bot.API.Send(tgbotapi.NewMessage(ctx.ChatID, key.GetMenuMessage(ctx.Lang, key.TranslationNotActiveMessage)))
+		return
+	}
+	h.bot.sendGlossary(ctx.ChatID, userID, ctx.Lang, current.SourceLanguage, current.TargetLanguage)
+}
+
+// GlossaryHandler handles the buttons of the glossary.
+
+type GlossaryHandler struct {
+	bot *Bot
+}
+
+// Handle performs the glossary action carried in the callback data.
+
+func (h *GlossaryHandler) Handle(ctx *Context, args cbdata.Args) {
+
+	if len(args) == 0 {
+		log.Printf("glossary callback without action: %s", ctx.Callback.Data)
+		return
+	}
+	userID := ctx.UserID
+
+	switch args[0] {
+	case glossaryListAction:
+		if len(args) < 3 || !validPair(args[1], args[2]) {
+			log.Printf("invalid glossary pair: %s", ctx.Callback.Data)
+			return
+		}
+		text, keyboard := glossaryView(userID, ctx.Lang, args[1], args[2], pageArg(args, 3))
+		h.bot.editCallbackMessage(ctx.Callback, text, keyboard)
+
+	case glossaryDeleteAction:
+		if len(args) < 4 || !validPair(args[2], args[3]) {
+			log.Printf("invalid glossary delete callback: %s", ctx.Callback.Data)
+			return
+		}
+		id, err := args.Int64(1)
+		if err != nil {
+			log.Printf("invalid glossary term id: %s", ctx.Callback.Data)
+			return
+		}
+		if err := storange.DeleteGlossaryTerm(userID, id); err != nil {
+			log.Println(err)
+		}
+		text, keyboard := glossaryView(userID, ctx.Lang, args[2], args[3], pageArg(args, 4))
+		h.bot.editCallbackMessage(ctx.Callback, text, keyboard)
+
+	case glossaryAddAction, glossaryImportAction:
+		if len(args) < 3 || !validPair(args[1], args[2]) {
+			log.Printf("invalid glossary pair: %s", ctx.Callback.Data)
+			return
+		}
+
+		state, prompt := termState, key.GlossaryTermMessage
+		if args[0] == glossaryImportAction {
+			state, prompt = importState, key.GlossaryImportMessage
+		}
+		data := map[string]string{"source": args[1], "target": args[2]}
+		if !h.bot.startConversation(userID, ctx.ChatID, glossaryFlow, state, data) {
+			return
+		}
+		h.bot.API.Send(tgbotapi.NewMessage(ctx.ChatID, key.GetMenuMessage(ctx.Lang, prompt)))
+
+	default:
+		log.Printf("unknown glossary action: %s", ctx.Callback.Data)
+	}
+}
+
+// GlossaryTermInput handles the term typed after pressing the add term button.
+
+type GlossaryTermInput struct {
+	bot *Bot
+}
+
+// HandleInput keeps the term in the conversation and asks for its translation.
+
+func (h *GlossaryTermInput) HandleInput(ctx *Context, session *fsm.Session) {
+
+	term := strings.TrimSpace(ctx.Message.Text)
+	if term == "" {
+		return
+	}
+
+	session.Set("term", term)
+	if err := h.bot.Conversations.Fire(session, termEvent); err != nil {
+		log.Println(err)
+		return
+	}
+
+	text := key.Format(ctx.Lang, key.GlossaryTranslationMessage, key.Params{"term": term})
+	h.bot.API.Send(tgbotapi.NewMessage(ctx.ChatID, text))
+}
+
+// GlossaryTranslationInput handles the translation typed for the glossary term.
+
+type GlossaryTranslationInput struct {
+	bot *Bot
+}
+
+// HandleInput saves the term with its translation and sends the updated glossary.
+
+func (h *GlossaryTranslationInput) HandleInput(ctx *Context, session *fsm.Session) {
+
+	userID := ctx.UserID
+	translated := strings.TrimSpace(ctx.Message.Text)
+	if translated == "" {
+		return
+	}
+	if err := h.bot.Conversations.Fire(session, saveEvent); err != nil {
+		log.Println(err)
+		return
+	}
+
+	term, source, target := session.Get("term"), session.Get("source"), session.Get("target")
+	err := storange.SaveGlossaryTerm(storange.GlossaryTerm{
+		UserID:         userID,
+		SourceLanguage: source,
+		TargetLanguage: target,
+		Term:           term,
+		Translation:    translated,
+		CreatedAt:      time.Now(),
+	})
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	text := key.Format(ctx.Lang, key.GlossarySavedMessage, key.Params{"term": term, "translation": translated})
+	h.bot.API.Send(tgbotapi.NewMessage(ctx.ChatID, text))
+	h.bot.sendGlossary(ctx.ChatID, userID, ctx.Lang, source, target)
+}
+
+// GlossaryImportInput handles the CSV file sent after pressing the import button.
+
+type GlossaryImportInput struct {
+	bot *Bot
+}
+
+// HandleInput downloads the file and adds its terms to the glossary.
+
+func (h *GlossaryImportInput) HandleInput(ctx *Context, session *fsm.Session) {
+
+	userID := ctx.UserID
+	failed := func() {
+		h.bot.API.Send(tgbotapi.NewMessage(ctx.ChatID, key.GetMenuMessage(ctx.Lang, key.GlossaryImportFailedMessage)))
+	}
+
+	if ctx.Message.Document.FileSize > glossaryMaxFileSize {
+		log.Printf("glossary file of user %d is too large: %d bytes", userID, ctx.Message.Document.FileSize)
+		failed()
+		return
+	}
+
+	url, err := h.bot.API.GetFileDirectURL(ctx.Message.Document.FileID)
+	if err != nil {
+		log.Printf("error getting glossary file: %v", err)
+		failed()
+		return
+	}
+	resp, err := http.Get(url)
+	if err != nil {
+		log.Printf("error downloading glossary file: %v", err)
+		failed()
+		return
+	}
+	defer resp.Body.Close()
+
+	terms, err := parseGlossaryCSV(io.LimitReader(resp.Body, glossaryMaxFileSize))
+	if err != nil || len(terms) == 0 {
+		log.Printf("invalid glossary file of user %d: %v", userID, err)
+		failed()
+		return
+	}
+
+	if err := h.bot.Conversations.Fire(session, importEvent); err != nil {
+		log.Println(err)
+		return
+	}
+
+	source, target := session.Get("source"), session.Get("target")
+	imported := 0
+	for _, term := range terms {
+		err := storange.SaveGlossaryTerm(storange.GlossaryTerm{
+			UserID:         userID,
+			SourceLanguage: source,
+			TargetLanguage: target,
+			Term:           term[0],
+			Translation:    term[1],
+			CreatedAt:      time.Now(),
+		})
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		imported++
+	}
+
+	text := key.Format(ctx.Lang, key.GlossaryImportedMessage, key.Params{"count": imported})
+	h.bot.API.Send(tgbotapi.NewMessage(ctx.ChatID, text))
+	h.bot.sendGlossary(ctx.ChatID, userID, ctx.Lang, source, target)
+}
@@ -1,47 +1,167 @@
 package bot
 
 import (
+	"errors"
 	"log"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/mzfarshad/tlg_bot/internal/cbdata"
+	"github.com/mzfarshad/tlg_bot/internal/fsm"
 	"github.com/mzfarshad/tlg_bot/internal/key"
 )
 
 // CommandHandler interface defines the contract for handling commands and callback queries.
 // Any type that implements this interface can be used as a handler in the HandlerManager.
+// The context holds the callback query; the arguments are the ones decoded from the callback data
+// of the pressed button.
 
 type CommandHadler interface {
-	Handle(chaID int64, callback *tgbotapi.CallbackQuery, lang key.Language)
+	Handle(ctx *Context, args cbdata.Args)
+}
+
+// InputHandler interface defines the contract for handling a message sent while a conversation waits for input.
+// The session holds the state and the context data of the conversation.
+
+type InputHandler interface {
+	HandleInput(ctx *Context, session *fsm.Session)
 }
 
 // HandlerManager manages a collection of CommandHandlers, each associated with a unique handler name.
 
 type HandlerManager struct {
+	bot     *Bot                     // Bot used to check and disable outdated menus
 	handler map[string]CommandHadler // Map to store handler instances by their names
+	input   map[string]InputHandler  // Map to store input handlers by the conversation state waiting for input
+	menu    map[string]bool          // Handlers navigating the menu, only accepted from the active menu message
 }
 
 // newHandlerManager creates and returns a new instance of HandlerManager with an initialized handler map.
 
-func newHandlerManager() *HandlerManager {
+func newHandlerManager(bot *Bot) *HandlerManager {
 	return &HandlerManager{
+		bot:     bot,
 		handler: make(map[string]CommandHadler),
+		input:   make(map[string]InputHandler),
+		menu:    make(map[string]bool),
 	}
 }
 
+// registerInput adds a new InputHandler to the HandlerManager for the specified state of a conversation flow.
+
+func (hm *HandlerManager) rigesterInput(flow, state string, handler InputHandler) {
+	hm.input[flow+"."+state] = handler
+}
+
+// registerMenuHandler adds a CommandHandler that navigates the menu.
+// Its buttons are ignored when they come from a menu message that is no longer the active one.
+
+func (hm *HandlerManager) rigesterMenuHandler(handlerName string, handler CommandHadler) {
+	hm.handler[handlerName] = handler
+	hm.menu[handlerName] = true
+}
+
 // registerHandler adds a new CommandHandler to the HandlerManager under the specified handler name.
 
 func (hm *HandlerManager) rigesterHandler(handlerName string, handler CommandHadler) {
 	hm.handler[handlerName] = handler
 }
 
-// handleInteraction processes a callback query using the appropriate CommandHandler based on the handler name.
-// If the handler does not exist, it logs an error message.
+// callbackData encodes the callback data of a button calling the handler with the given arguments.
+
+func callbackData(handlerName string, args ...any) string {
+	return encodeCallback(cbdata.New(handlerName, args...))
+}
+
+// encodeCallback encodes callback data for a button.
+// Data that doesn't fit Telegram's limit is logged and replaced by a button doing nothing.
+
+func encodeCallback(data cbdata.Data) string {
+	encoded, err := data.Encode()
+	if err != nil {
+		log.Println(err)
+		encoded, _ = cbdata.Encode(string(key.NoopHandler))
+	}
+	return encoded
+}
+
+// handleInteraction decodes the callback data and processes the callback query
+// using the CommandHandler registered for its action, passing it the decoded arguments.
+// It reports whether the callback data is valid and a handler exists for it.
+
+func (hm *HandlerManager) handlInteraction(ctx *Context) bool {
+
+	callback := ctx.Callback
+
+	data, err := cbdata.Decode(callback.Data)
+	if errors.Is(err, cbdata.ErrSignature) {
+		log.Printf("forged callback data from user %d: %s", callback.From.ID, callback.Data)
+		return false
+	}
+	if err != nil {
+		log.Printf("invalid callback data %q: %v", callback.Data, err)
+		return false
+	}
+
+	handler, exist := hm.handler[data.Action]
+	if !exist {
+		log.Printf("handler is not exist in command manager: %s", data.Action)
+		return false
+	}
+
+	// A menu button pressed on an older menu message would change the state of the active menu,
+	// and one pressed on the menu of another user would change theirs
+	if hm.menu[data.Action] {
+		switch hm.bot.MenuManager.checkMenu(ctx.UserID, ctx.ChatID, callback) {
+		case menuStale:
+			hm.bot.disableKeyboard(ctx.ChatID, callback.Message.MessageID)
+			hm.bot.answerCallback(callback, key.GetMenuMessage(ctx.Lang, key.OutdatedMenuMessage), false)
+			return true
+		case menuOtherUser:
+			hm.bot.answerCallback(callback, key.GetMenuMessage(ctx.Lang, key.OtherUserMenuMessage), true)
+			return true
+		}
+	}
+
+	// Pressing a button means the user no longer answers a conversation waiting for typed input
+	if data.Action != string(key.NoopHandler) {
+		hm.bot.abandonTextInput(ctx.UserID, ctx.ChatID)
+	}
+
+	handler.Handle(ctx, data.Args)
+	return true
+}
+
+// handleInput processes a text message or a document using the InputHandler registered for the state of
+// the user's conversation. It reports whether a conversation was waiting for the input.
+
+func (hm *HandlerManager) handleInput(ctx *Context) bool {
+
+	session, err := hm.bot.Conversations.Current(ctx.UserID, ctx.ChatID)
+	if errors.Is(err, fsm.ErrExpired) {
+		hm.bot.API.Send(tgbotapi.NewMessage(ctx.ChatID, key.GetMenuMessage(ctx.Lang, key.ConversationExpiredMessage)))
+		return true
+	}
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	if session == nil {
+		return false
+	}
+
+	input := fsm.Text
+	if ctx.Message.Document != nil {
+		input = fsm.Document
+	}
+	if !hm.bot.Conversations.Expects(session, input) {
+		return false
+	}
 
-func (hm *HandlerManager) handlInteraction(
-	handlerName string, callback *tgbotapi.CallbackQuery, chatID int64, lang key.Language) {
-	if handler, exist := hm.handler[handlerName]; exist {
-		handler.Handle(chatID, callback, lang)
-	} else {
-		log.Printf("handler is not exist in command manager: %s", handlerName)
+	handler, exist := hm.input[session.Flow+"."+session.State]
+	if !exist {
+		log.Printf("no input handler for state %s of flow %s", session.State, session.Flow)
+		return false
 	}
+	handler.HandleInput(ctx, session)
+	return true
 }
@@ -0,0 +1,350 @@
+package bot
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/mzfarshad/tlg_bot/internal/bidi"
+	"github.com/mzfarshad/tlg_bot/internal/cbdata"
+	"github.com/mzfarshad/tlg_bot/internal/fsm"
+	"github.com/mzfarshad/tlg_bot/internal/key"
+	"github.com/mzfarshad/tlg_bot/internal/richtext"
+	"github.com/mzfarshad/tlg_bot/internal/storange"
+)
+
+// Layout of the history listing
+const (
+	historyPageSize     = 5
+	historyPreviewRunes = 120
+	historyKeywordBytes = 24 // Escaped size of the keyword, small enough for the 64 byte callback data limit
+)
+
+// Actions of the history listing carried in the callback data ("history|<action>|<args>...")
+const (
+	historyPageAction         = "p"  // history|p|<page>|<keyword>
+	historyDeleteAction       = "d"  // history|d|<id>|<page>|<keyword>
+	historyClearAction        = "c"  // history|c
+	historyClearConfirmAction = "cy" // history|cy
+	historySearchAction       = "s"  // history|s
+	historyToggleAction       = "o"  // history|o|<true to save history, false to pause it>
+)
+
+// pendingInlineTTL is how long an inline result waits to be chosen before it is forgotten.
+const pendingInlineTTL = 10 * time.Minute
+
+// pendingTranslations keeps the translations offered as inline results until the user picks one.
+// Inline queries are sent on every keystroke, so only the chosen result is written to the history.
+
+type pendingTranslations struct {
+	mu      sync.Mutex
+	entries map[string]pendingTranslation
+}
+
+type pendingTranslation struct {
+	entry   storange.HistoryEntry
+	expires time.Time
+}
+
+var pendingInline = &pendingTranslations{entries: make(map[string]pendingTranslation)}
+
+// add remembers the translation offered under the inline result ID and forgets expired ones.
+
+func (p *pendingTranslations) add(resultID string, entry storange.HistoryEntry) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	for id, pending := range p.entries {
+		if now.After(pending.expires) {
+			delete(p.entries, id)
+		}
+	}
+	p.entries[resultID] = pendingTranslation{entry: entry, expires: now.Add(pendingInlineTTL)}
+}
+
+// take returns and forgets the translation offered under the inline result ID.
+
+func (p *pendingTranslations) take(resultID string) (storange.HistoryEntry, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pending, ok := p.entries[resultID]
+	delete(p.entries, resultID)
+	if !ok || time.Now().After(pending.expires) {
+		return storange.HistoryEntry{}, false
+	}
+	return pending.entry, true
+}
+
+// recordTranslation saves a translation in the user's history unless the user opted out.
+
+func recordTranslation(entry storange.HistoryEntry) {
+	enabled, err := storange.HistoryEnabled(entry.UserID)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if !enabled {
+		return
+	}
+
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+	if err := storange.SaveHistory(entry); err != nil {
+		log.Println(err)
+	}
+}
+
+// chosenInlineResultHandle writes the inline translation the user sent to the history.
+
+func (b *Bot) chosenInlineResultHandle(chosen *tgbotapi.ChosenInlineResult) {
+	if entry, ok := pendingInline.take(chosen.ResultID); ok {
+		recordTranslation(entry)
+	}
+}
+
+// historyCallback builds the callback data for a history listing button.
+
+func historyCallback(args ...any) string {
+	return callbackData(string(key.HistoryHandler), args...)
+}
+
+// truncateRunes shortens the text to at most n runes, marking the cut with an ellipsis.
+
+func truncateRunes(text string, n int) string {
+	if utf8.RuneCountInString(text) <= n {
+		return text
+	}
+	return string([]rune(text)[:n]) + "…"
+}
+
+// historyKeyword normalizes a search keyword so it can travel in callback data.
+// It's cut by its escaped size, since "%" and "#" take 3 bytes each in callback data.
+
+func historyKeyword(text string) string {
+	text = strings.ReplaceAll(strings.TrimSpace(text), "|", " ")
+	return cbdata.Truncate(text, historyKeywordBytes)
+}
+
+// historyView renders one page of the user's history, optionally filtered by a keyword.
+
+func historyView(userID int, lang key.Language, keyword string, page int) (richtext.Message, tgbotapi.InlineKeyboardMarkup) {
+
+	if page < 0 {
+		page = 0
+	}
+	entries, total, err := storange.GetHistory(userID, keyword, page*historyPageSize, historyPageSize)
+	if err != nil {
+		log.Println(err)
+	}
+
+	pages := (total + historyPageSize - 1) / historyPageSize
+	if len(entries) == 0 && page > 0 && pages > 0 {
+		// The page disappeared, for example after deleting its last entry
+		page = pages - 1
+		entries, total, err = storange.GetHistory(userID, keyword, page*historyPageSize, historyPageSize)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+
+	enabled, err := storange.HistoryEnabled(userID)
+	if err != nil {
+		log.Println(err)
+		enabled = true
+	}
+
+	text := newText()
+	if !enabled {
+		text.Italic(key.GetMenuMessage(lang, key.HistoryPausedMessage)).Line().Line()
+	}
+
+	switch {
+	case total == 0 && keyword != "":
+		text.Text(key.Format(lang, key.HistoryNoMatchMessage, key.Params{"keyword": keyword}))
+	case total == 0:
+		text.Text(key.GetMenuMessage(lang, key.HistoryEmptyMessage))
+	case keyword != "":
+		text.Bold(key.Format(lang, key.HistorySearchResultMessage, key.Params{"keyword": keyword, "page": page + 1, "pages": pages}))
+	default:
+		text.Bold(key.Format(lang, key.HistoryMessage, key.Params{"page": page + 1, "pages": pages}))
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	var deleteRow []tgbotapi.InlineKeyboardButton
+
+	for i, entry := range entries {
+		number := page*historyPageSize + i + 1
+		text.Line().Line().Bold(formatNumber(lang, number) + ".").
+			Text(" " + pairLabel(entry.SourceLanguage, entry.TargetLanguage) + " · " + entry.CreatedAt.Format("2006-01-02 15:04")).Line().
+			Quote(bidi.Isolate(truncateRunes(entry.SourceText, historyPreviewRunes))).
+			Text("➜ " + bidi.Isolate(truncateRunes(entry.TranslatedText, historyPreviewRunes)))
+
+		deleteRow = append(deleteRow, tgbotapi.NewInlineKeyboardButtonData("🗑 "+formatNumber(lang, number),
+			historyCallback(historyDeleteAction, entry.ID, page, keyword)))
+	}
+	if len(deleteRow) > 0 {
+		rows = append(rows, deleteRow)
+	}
+
+	if pages > 1 {
+		var nav []tgbotapi.InlineKeyboardButton
+		if page > 0 {
+			nav = append(nav, tgbotapi.NewInlineKeyboardButtonData(key.GetKey(lang, key.KeyPreviousPage),
+				historyCallback(historyPageAction, page-1, keyword)))
+		}
+		nav = append(nav, tgbotapi.NewInlineKeyboardButtonData(
+			pageLabel(lang, page, pages), callbackData(string(key.NoopHandler))))
+		if page < pages-1 {
+			nav = append(nav, tgbotapi.NewInlineKeyboardButtonData(key.GetKey(lang, key.KeyNextPage),
+				historyCallback(historyPageAction, page+1, keyword)))
+		}
+		rows = append(rows, nav)
+	}
+
+	searchRow := tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(key.GetKey(lang, key.KeyHistorySearch), historyCallback(historySearchAction)),
+	)
+	if keyword != "" {
+		searchRow = append(searchRow, tgbotapi.NewInlineKeyboardButtonData(key.GetKey(lang, key.KeyClearSearch),
+			historyCallback(historyPageAction, 0, "")))
+	}
+	if total > 0 && keyword == "" {
+		searchRow = append(searchRow, tgbotapi.NewInlineKeyboardButtonData(key.GetKey(lang, key.KeyClearHistory),
+			historyCallback(historyClearAction)))
+	}
+	rows = append(rows, searchRow)
+
+	if enabled {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(key.GetKey(lang, key.KeyPauseHistory), historyCallback(historyToggleAction, false)),
+		))
+	} else {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(key.GetKey(lang, key.KeyResumeHistory), historyCallback(historyToggleAction, true)),
+		))
+	}
+
+	return text.Message(), tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// HistoryCommandHandler handles the /history command.
+
+type HistoryCommandHandler struct {
+	bot *Bot
+}
+
+// Handle sends the first page of the user's translation history.
+
+func (h *HistoryCommandHandler) Handle(ctx *Context) {
+
+	text, keyboard := historyView(ctx.UserID, ctx.Lang, "", 0)
+	message := tgbotapi.NewMessage(ctx.ChatID, "")
+	message.ReplyMarkup = keyboard
+	h.bot.sendText(message, text)
+}
+
+// HistoryHandler handles the buttons of the history listing.
+
+type HistoryHandler struct {
+	bot *Bot
+}
+
+// Handle performs the history action carried in the callback data and updates the listing in place.
+
+func (h *HistoryHandler) Handle(ctx *Context, args cbdata.Args) {
+
+	if len(args) == 0 {
+		log.Printf("history callback without action: %s", ctx.Callback.Data)
+		return
+	}
+	userID := ctx.UserID
+
+	switch args[0] {
+	case historyPageAction:
+		text, keyboard := historyView(userID, ctx.Lang, args.String(2), pageArg(args, 1))
+		h.bot.editCallbackMessage(ctx.Callback, text, keyboard)
+
+	case historyDeleteAction:
+		if len(args) < 2 {
+			log.Printf("history delete callback without id: %s", ctx.Callback.Data)
+			return
+		}
+		id, err := args.Int64(1)
+		if err != nil {
+			log.Printf("invalid history entry id: %s", ctx.Callback.Data)
+			return
+		}
+		if err := storange.DeleteHistory(userID, id); err != nil {
+			log.Println(err)
+		}
+
+		text, keyboard := historyView(userID, ctx.Lang, args.String(3), pageArg(args, 2))
+		h.bot.editCallbackMessage(ctx.Callback, text, keyboard)
+
+	case historyClearAction:
+		keyboard := tgbotapi.NewInlineKeyboardMarkup(
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData(key.GetKey(ctx.Lang, key.KeyResetTranslateYes),
+					historyCallback(historyClearConfirmAction)),
+				tgbotapi.NewInlineKeyboardButtonData(key.GetKey(ctx.Lang, key.KeyBack),
+					historyCallback(historyPageAction, 0, "")),
+			),
+		)
+		h.bot.editCallbackMessage(ctx.Callback, richtext.PlainText(key.GetMenuMessage(ctx.Lang, key.ClearHistoryConfirmMessage)), keyboard)
+
+	case historyClearConfirmAction:
+		if err := storange.ClearHistory(userID); err != nil {
+			log.Println(err)
+		}
+		text, keyboard := historyView(userID, ctx.Lang, "", 0)
+		h.bot.editCallbackMessage(ctx.Callback, text, keyboard)
+
+	case historySearchAction:
+		if !h.bot.startConversation(userID, ctx.ChatID, historySearchFlow, "", nil) {
+			return
+		}
+		h.bot.API.Send(tgbotapi.NewMessage(ctx.ChatID, key.GetMenuMessage(ctx.Lang, key.HistorySearchMessage)))
+
+	case historyToggleAction:
+		enabled, err := args.Bool(1)
+		if err != nil {
+			log.Printf("invalid history toggle callback: %s", ctx.Callback.Data)
+			return
+		}
+		if err := storange.SetHistoryEnabled(userID, enabled); err != nil {
+			log.Println(err)
+		}
+		text, keyboard := historyView(userID, ctx.Lang, "", 0)
+		h.bot.editCallbackMessage(ctx.Callback, text, keyboard)
+
+	default:
+		log.Printf("unknown history action: %s", ctx.Callback.Data)
+	}
+}
+
+// HistorySearchInput handles the keyword typed after pressing the history search button.
+
+type HistorySearchInput struct {
+	bot *Bot
+}
+
+// HandleInput sends the first page of history entries containing the keyword.
+
+func (h *HistorySearchInput) HandleInput(ctx *Context, session *fsm.Session) {
+
+	userID := ctx.UserID
+	if err := h.bot.Conversations.Fire(session, searchEvent); err != nil {
+		log.Println(err)
+	}
+
+	text, keyboard := historyView(userID, ctx.Lang, historyKeyword(ctx.Message.Text), 0)
+	message := tgbotapi.NewMessage(ctx.ChatID, "")
+	message.ReplyMarkup = keyboard
+	h.bot.sendText(message, text)
+}
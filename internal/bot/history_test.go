@@ -0,0 +1,33 @@
+package bot
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mzfarshad/tlg_bot/internal/cbdata"
+	"github.com/mzfarshad/tlg_bot/internal/key"
+)
+
+func TestHistoryCallbackFits(t *testing.T) {
+	cbdata.SetSecret([]byte("secret"))
+	defer cbdata.SetSecret(nil)
+
+	noop := callbackData(string(key.NoopHandler))
+	for _, text := range []string{
+		strings.Repeat("%", 40),
+		strings.Repeat("#", 40),
+		strings.Repeat("|", 40),
+		strings.Repeat("ß%", 20),
+		strings.Repeat("یک", 20),
+	} {
+		keyword := historyKeyword(text)
+		for _, data := range []string{
+			historyCallback(historyDeleteAction, int64(9999999999), 999, keyword),
+			historyCallback(historyPageAction, 999, keyword),
+		} {
+			if data == noop {
+				t.Errorf("history button with keyword %q falls back to noop", keyword)
+			}
+		}
+	}
+}
@@ -6,65 +6,157 @@ import (
 	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/mzfarshad/tlg_bot/internal/bidi"
+	"github.com/mzfarshad/tlg_bot/internal/key"
+	"github.com/mzfarshad/tlg_bot/internal/richtext"
+	"github.com/mzfarshad/tlg_bot/internal/setting"
 	"github.com/mzfarshad/tlg_bot/internal/storange"
-	"github.com/mzfarshad/tlg_bot/internal/translation"
 )
 
-func (b *Bot) inlineQueryHandle(userID int, inlineQuery *tgbotapi.InlineQuery) {
+// inlinePhraseLimit is the number of saved phrases offered in inline mode.
+const inlinePhraseLimit = 10
 
+// inlineResult is an inline result with the formatted text it sends.
+
+type inlineResult struct {
+	article tgbotapi.InlineQueryResultArticle
+	text    richtext.Message
+}
+
+// newInlineResult creates an inline result sending the formatted text.
+
+func newInlineResult(id, title string, text richtext.Message) inlineResult {
+	return inlineResult{article: tgbotapi.NewInlineQueryResultArticle(id, title, text.Plain), text: text}
+}
+
+// inlineArticles returns the articles of the results, sending their text formatted or plain.
+
+func inlineArticles(results []inlineResult, plain bool) []interface{} {
+	articles := make([]interface{}, 0, len(results))
+	for _, result := range results {
+		content := tgbotapi.InputTextMessageContent{Text: result.text.Text, ParseMode: string(result.text.Mode)}
+		if plain {
+			content = tgbotapi.InputTextMessageContent{Text: result.text.Plain}
+		}
+		result.article.InputMessageContent = content
+		articles = append(articles, result.article)
+	}
+	return articles
+}
+
+// translationText formats a text and its translation, the original quoted above the labeled translation.
+
+func translationText(text, translated, target string) richtext.Message {
+	return newText().Quote(bidi.Isolate(text)).Bold(bidi.Isolate(targetLabel(target))).Line().Text(bidi.Isolate(translated)).Message()
+}
+
+// failedTranslationText formats a text with the message that it couldn't be translated, isolated the same way
+// as a translation. The message is in the user's language, so it isn't labeled with the target language.
+
+func failedTranslationText(lang key.Language, text string) richtext.Message {
+	return newText().Quote(bidi.Isolate(text)).Text(bidi.Isolate(key.GetMenuMessage(lang, key.TranslationFailedMessage))).Message()
+}
+
+func (b *Bot) inlineQueryHandle(ctx *Context) {
+
+	userID, inlineQuery := ctx.UserID, ctx.Update.InlineQuery
 	queryID := inlineQuery.ID
 	queryText := inlineQuery.Query
 
-	log.Println("Query Text:", queryText)
+	var results []inlineResult
 
-	if queryText == "" {
-		log.Printf("Received empty query from userID: %d", userID)
+	if queryText != "" {
+		if result, ok := b.inlineTranslationResult(ctx, queryText); ok {
+			results = append(results, result)
+		}
+	}
+
+	// Offer the saved phrases matching the query, or the newest ones for an empty query
+	results = append(results, inlinePhraseResults(userID, queryText)...)
+
+	if len(results) == 0 {
+		log.Printf("No inline results for userID: %d", userID)
 		return
 	}
 
-	setting, err := storange.GetTranslationSetting(userID)
+	inlineConf := tgbotapi.InlineConfig{
+		InlineQueryID: queryID,
+		Results:       inlineArticles(results, false),
+		CacheTime:     10,
+		IsPersonal:    true,
+	}
+	_, err := b.API.Request(inlineConf)
+	if isParseError(err) {
+		log.Printf("error formatting inline results, sending them as plain text: %v", err)
+		inlineConf.Results = inlineArticles(results, true)
+		_, err = b.API.Request(inlineConf)
+	}
 	if err != nil {
-		log.Printf("inline query: %v, UserID: %d", err, userID)
-		return
+		log.Println("Error sending inline query response:", err, "UserID: ", userID)
+
 	}
+}
 
-	log.Println("Source Language:", setting.SourceLanguage, "Target Language:", setting.TargetLanguage, "UserID: ", userID)
+// inlineTranslationResult translates the query with the user's active language pair.
+// It reports false when translation isn't active for the user.
 
-	if setting.ActiveTranslation {
+func (b *Bot) inlineTranslationResult(ctx *Context, queryText string) (inlineResult, bool) {
 
-		translateText, err := translation.TranslateText(queryText, setting.SourceLanguage, setting.TargetLanguage)
+	userID := ctx.UserID
+	current, err := setting.GetTranslationSetting(ctx.settings())
+	if err != nil {
+		log.Printf("inline query: %v, UserID: %d", err, userID)
+		return inlineResult{}, false
+	}
+
+	if !current.ActiveTranslation {
+		return inlineResult{}, false
+	}
 
-		log.Printf("Translate Text: %s, UserID: %d", translateText, userID)
+	resultID := generateUniqueID(userID)
+	resultTitle := key.GetMenuMessage(ctx.Lang, key.InlineTranslateTitle)
 
+	result, err := translate(userID, queryText, current.SourceLanguage, current.TargetLanguage)
+	if err != nil || result.Text == "" {
 		if err != nil {
 			log.Printf("error in translate inline query from api translate: %v, UserID: %d", err, userID)
-			// translateText = "Translation error"
 		}
+		return newInlineResult(resultID, resultTitle, failedTranslationText(ctx.Lang, queryText)), true
+	}
 
-		if translateText == "" {
-			translateText = "No translation avialable"
-		}
+	pendingInline.add(resultID, storange.HistoryEntry{
+		UserID:         userID,
+		SourceText:     queryText,
+		TranslatedText: result.Text,
+		SourceLanguage: current.SourceLanguage,
+		TargetLanguage: current.TargetLanguage,
+		Provider:       result.Provider,
+	})
+	return newInlineResult(resultID, resultTitle, translationText(queryText, result.Text, current.TargetLanguage)), true
+}
 
-		result := tgbotapi.NewInlineQueryResultArticle(
-			generateUniqueID(userID),
-			"Translate",
-			queryText+"\n"+translateText,
-		)
+// inlinePhraseResults returns the user's saved phrases containing the query as inline results.
 
-		results := []interface{}{result}
+func inlinePhraseResults(userID int, queryText string) []inlineResult {
 
-		inlineConf := tgbotapi.InlineConfig{
-			InlineQueryID: queryID,
-			Results:       results,
-			CacheTime:     10,
-			IsPersonal:    true,
-		}
-		_, err = b.API.Request(inlineConf)
-		if err != nil {
-			log.Println("Error sending inline query response:", err, "UserID: ", userID)
+	phrases, err := storange.SearchPhrases(userID, queryText, inlinePhraseLimit)
+	if err != nil {
+		log.Printf("inline query phrases: %v, UserID: %d", err, userID)
+		return nil
+	}
 
-		}
+	var results []inlineResult
+	for _, phrase := range phrases {
+		result := newInlineResult(
+			"phrase-"+strconv.FormatInt(phrase.ID, 10),
+			"⭐ "+bidi.Isolate(truncateRunes(phrase.TranslatedText, historyPreviewRunes)),
+			translationText(phrase.SourceText, phrase.TranslatedText, phrase.TargetLanguage),
+		)
+		result.article.Description = pairLabel(phrase.SourceLanguage, phrase.TargetLanguage) + " · " +
+			bidi.Isolate(truncateRunes(phrase.SourceText, historyPreviewRunes))
+		results = append(results, result)
 	}
+	return results
 }
 
 func generateUniqueID(userID int) string {
@@ -0,0 +1,27 @@
+package bot
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mzfarshad/tlg_bot/internal/bidi"
+	"github.com/mzfarshad/tlg_bot/internal/key"
+)
+
+func TestInlineTextsIsolated(t *testing.T) {
+	translated := translationText("Hello 2 you", "سلام ۲ تو", "fa")
+	for _, segment := range []string{bidi.Isolate("Hello 2 you"), bidi.Isolate(targetLabel("fa")), bidi.Isolate("سلام ۲ تو")} {
+		if !strings.Contains(translated.Plain, segment) {
+			t.Errorf("translation text %q doesn't isolate %q", translated.Plain, segment)
+		}
+	}
+
+	failed := failedTranslationText(key.LangFA, "Hello 2 you")
+	fallback := key.GetMenuMessage(key.LangFA, key.TranslationFailedMessage)
+	if !strings.Contains(failed.Plain, bidi.Isolate(fallback)) {
+		t.Errorf("failed translation text %q doesn't isolate the fallback message", failed.Plain)
+	}
+	if strings.Contains(failed.Plain, targetLabel("fa")) {
+		t.Errorf("failed translation text %q is labeled as a translation", failed.Plain)
+	}
+}
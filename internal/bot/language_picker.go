@@ -0,0 +1,383 @@
+package bot
+
+import (
+	"fmt"
+	"log"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/mzfarshad/tlg_bot/internal/bidi"
+	"github.com/mzfarshad/tlg_bot/internal/cbdata"
+	"github.com/mzfarshad/tlg_bot/internal/fsm"
+	"github.com/mzfarshad/tlg_bot/internal/key"
+	"github.com/mzfarshad/tlg_bot/internal/setting"
+	"github.com/mzfarshad/tlg_bot/internal/translation"
+)
+
+// Layout of the language picker keyboard
+const (
+	pickerPageSize = 12
+	pickerColumns  = 3
+)
+
+// Steps of the language pair picker carried in the callback data ("pair|<step>|<args>...")
+const (
+	pickSourceStep  = "src"  // pair|src|<page>
+	pickTargetStep  = "tgt"  // pair|tgt|<source>|<page>
+	pickConfirmStep = "cfm"  // pair|cfm|<source>|<target>
+	pickSaveStep    = "ok"   // pair|ok|<source>|<target>
+	pickSearchStep  = "find" // pair|find|<source or ->
+)
+
+// noSource marks a language search started while the source language is still being chosen.
+const noSource = "-"
+
+// pairsCallback builds the callback data for a language picker button.
+
+func pairsCallback(args ...any) string {
+	return callbackData(string(key.LanguagePairsHandler), args...)
+}
+
+// languageName returns the label used for a language code in picker messages,
+// with the native name isolated so the code stays after it in any direction.
+
+func languageName(lang key.Language, code string) string {
+	if code == translation.AutoDetect {
+		return key.GetKey(lang, key.KeyAutoDetect)
+	}
+	if l, ok := translation.LanguageByCode(code); ok {
+		return fmt.Sprintf("%s (%s)", bidi.Isolate(l.Native), l.Code)
+	}
+	return code
+}
+
+// languageButtons lays out one button per language, pickerColumns per row.
+// While the source is being chosen a button moves on to the target step,
+// otherwise it moves on to the confirmation step.
+
+func languageButtons(languages []translation.Language, source string) [][]tgbotapi.InlineKeyboardButton {
+	var rows [][]tgbotapi.InlineKeyboardButton
+
+	for i, l := range languages {
+		var data string
+		if source == "" {
+			data = pairsCallback(pickTargetStep, l.Code, 0)
+		} else {
+			data = pairsCallback(pickConfirmStep, source, l.Code)
+		}
+		button := tgbotapi.NewInlineKeyboardButtonData(l.Native, data)
+
+		if i%pickerColumns == 0 {
+			rows = append(rows, []tgbotapi.InlineKeyboardButton{button})
+		} else {
+			rows[len(rows)-1] = append(rows[len(rows)-1], button)
+		}
+	}
+	return rows
+}
+
+// pickableLanguages returns the languages offered as a target for the given source.
+// An empty source means the source language itself is being chosen.
+
+func pickableLanguages(languages []translation.Language, source string) []translation.Language {
+	if source == "" {
+		return languages
+	}
+	var result []translation.Language
+	for _, l := range languages {
+		if l.Code != source {
+			result = append(result, l)
+		}
+	}
+	return result
+}
+
+// formatNumber writes a number of a list or page in the digits of the language.
+
+func formatNumber(lang key.Language, n int) string {
+	return key.FormatNumber(lang, int64(n))
+}
+
+// pageLabel returns the label of the button showing the current page, counted from zero, and the number of pages.
+
+func pageLabel(lang key.Language, page, pages int) string {
+	return formatNumber(lang, page+1) + "/" + formatNumber(lang, pages)
+}
+
+// languagePickerKeyboard creates one page of the language picker.
+// An empty source shows the source step, otherwise the target step for that source.
+
+func languagePickerKeyboard(lang key.Language, source string, page int) tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+
+	languages := pickableLanguages(translation.Languages, source)
+	pages := (len(languages) + pickerPageSize - 1) / pickerPageSize
+	if page < 0 || page >= pages {
+		page = 0
+	}
+
+	if source == "" {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(key.GetKey(lang, key.KeyAutoDetect),
+				pairsCallback(pickTargetStep, translation.AutoDetect, 0)),
+		))
+	}
+
+	end := page*pickerPageSize + pickerPageSize
+	if end > len(languages) {
+		end = len(languages)
+	}
+	rows = append(rows, languageButtons(languages[page*pickerPageSize:end], source)...)
+
+	// Page navigation row
+	pageData := func(p int) string {
+		if source == "" {
+			return pairsCallback(pickSourceStep, p)
+		}
+		return pairsCallback(pickTargetStep, source, p)
+	}
+	var nav []tgbotapi.InlineKeyboardButton
+	if page > 0 {
+		nav = append(nav, tgbotapi.NewInlineKeyboardButtonData(key.GetKey(lang, key.KeyPreviousPage), pageData(page-1)))
+	}
+	nav = append(nav, tgbotapi.NewInlineKeyboardButtonData(
+		pageLabel(lang, page, pages), callbackData(string(key.NoopHandler))))
+	if page < pages-1 {
+		nav = append(nav, tgbotapi.NewInlineKeyboardButtonData(key.GetKey(lang, key.KeyNextPage), pageData(page+1)))
+	}
+	rows = append(rows, nav)
+
+	searchSource := source
+	if searchSource == "" {
+		searchSource = noSource
+	}
+	searchRow := tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(key.GetKey(lang, key.KeySearchLanguage),
+			pairsCallback(pickSearchStep, searchSource)),
+	)
+	if source != "" {
+		searchRow = append(searchRow, tgbotapi.NewInlineKeyboardButtonData(key.GetKey(lang, key.KeyChangePairs),
+			pairsCallback(pickSourceStep, 0)))
+	}
+	rows = append(rows, searchRow)
+
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(key.GetKey(lang, key.KeyBack), callbackData(string(key.KeyBack))),
+	))
+
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// confirmPairsKeyboard creates the keyboard asking the user to confirm the chosen language pair.
+
+func confirmPairsKeyboard(lang key.Language, source, target string) tgbotapi.InlineKeyboardMarkup {
+	row := tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(key.GetKey(lang, key.KeyConfirmPairs),
+			pairsCallback(pickSaveStep, source, target)),
+	)
+
+	// Auto-detect can't be a target, so a pair with an auto-detected source can't be swapped
+	if source != translation.AutoDetect {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(key.GetKey(lang, key.KeySwapLanguages),
+			pairsCallback(pickConfirmStep, target, source)))
+	}
+
+	return tgbotapi.NewInlineKeyboardMarkup(
+		row,
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(key.GetKey(lang, key.KeyChangePairs),
+				pairsCallback(pickSourceStep, 0)),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(key.GetKey(lang, key.KeyBack), callbackData(string(key.KeyBack))),
+		),
+	)
+}
+
+// validSource reports whether a code carried in callback data can be used as a source language.
+
+func validSource(source string) bool {
+	return source == translation.AutoDetect || translation.IsSupportedLanguage(source)
+}
+
+// validPair reports whether the source and target codes carried in callback data can be saved.
+
+func validPair(source, target string) bool {
+	return validSource(source) && translation.IsSupportedLanguage(target) && source != target
+}
+
+// pickerEvents are the pair setup conversation events of the picker steps.
+var pickerEvents = map[string]string{
+	pickSourceStep:  sourceEvent,
+	pickTargetStep:  targetEvent,
+	pickConfirmStep: confirmEvent,
+	pickSaveStep:    saveEvent,
+	pickSearchStep:  searchEvent,
+}
+
+// LanguagePairsPickerHandler handles the buttons of the language pair picker.
+// The picker is the pair setup conversation; each step is a transition of the conversation.
+
+type LanguagePairsPickerHandler struct {
+	bot *Bot
+}
+
+// Handle moves the picker to the step carried in the callback data.
+
+func (h *LanguagePairsPickerHandler) Handle(ctx *Context, args cbdata.Args) {
+
+	if len(args) == 0 {
+		log.Printf("language picker callback without step: %s", ctx.Callback.Data)
+		return
+	}
+	event, known := pickerEvents[args[0]]
+	if !known {
+		log.Printf("unknown language picker step: %s", ctx.Callback.Data)
+		return
+	}
+	userID := ctx.UserID
+
+	session, expired := h.bot.conversation(userID, ctx.ChatID, pairSetupFlow)
+	if session == nil {
+		// The picker was left or timed out; go back to the menu it was opened from
+		message := key.OutdatedMenuMessage
+		if expired {
+			message = key.ConversationExpiredMessage
+		}
+		h.bot.answerCallback(ctx.Callback, key.GetMenuMessage(ctx.Lang, message), true)
+		h.bot.MenuManager.menuInteraction(ctx.settings(), string(key.MenuTranslation), ctx.Lang, ctx.Callback)
+		return
+	}
+
+	// Check the arguments of the step and keep the chosen languages in the conversation
+	switch args[0] {
+	case pickSourceStep:
+		session.Set("source", "")
+
+	case pickTargetStep:
+		if len(args) < 2 || !validSource(args[1]) {
+			log.Printf("invalid language picker source: %s", ctx.Callback.Data)
+			return
+		}
+		session.Set("source", args[1])
+
+	case pickConfirmStep, pickSaveStep:
+		if len(args) < 3 || !validPair(args[1], args[2]) {
+			log.Printf("invalid language picker pair: %s", ctx.Callback.Data)
+			return
+		}
+		session.Set("source", args[1])
+		session.Set("target", args[2])
+
+	case pickSearchStep:
+		source := ""
+		if len(args) > 1 && validSource(args[1]) {
+			source = args[1]
+		}
+		session.Set("source", source)
+	}
+
+	if err := h.bot.Conversations.Fire(session, event); err != nil {
+		// A button of an earlier step, for example saving the pair twice
+		log.Println(err)
+		return
+	}
+
+	source, target := session.Get("source"), session.Get("target")
+
+	switch args[0] {
+	case pickSourceStep:
+		h.bot.editCallbackMessage(ctx.Callback, title(key.GetMenuMessage(ctx.Lang, key.SelectSourceLanguageMessage)),
+			languagePickerKeyboard(ctx.Lang, "", pageArg(args, 1)))
+
+	case pickTargetStep:
+		text := key.Format(ctx.Lang, key.SelectTargetLanguageMessage, key.Params{"source": languageName(ctx.Lang, source)})
+		h.bot.editCallbackMessage(ctx.Callback, title(text), languagePickerKeyboard(ctx.Lang, source, pageArg(args, 2)))
+
+	case pickConfirmStep:
+		text := key.Format(ctx.Lang, key.ConfirmLanguagePairsMessage, key.Params{
+			"source": languageName(ctx.Lang, source),
+			"target": languageName(ctx.Lang, target),
+		})
+		h.bot.editCallbackMessage(ctx.Callback, title(text), confirmPairsKeyboard(ctx.Lang, source, target))
+
+	case pickSaveStep:
+		if err := setting.SaveLanguagePairs(setting.User, ctx.settings(), source, target); err != nil {
+			log.Println(err)
+			return
+		}
+		rememberPair(userID, source, target)
+		if text := pairOverriddenText(ctx); text != "" {
+			h.bot.answerCallback(ctx.Callback, text, true)
+		}
+
+		// The picker turns into the finish menu, so the saved pair can't be changed from it any more
+		h.bot.MenuManager.showFlowMenu(ctx.settings(), key.MenuFinishTranslateSetup, ctx.Lang, ctx.Callback)
+
+	case pickSearchStep:
+		h.bot.editCallbackMessage(ctx.Callback, title(key.GetMenuMessage(ctx.Lang, key.SearchLanguageMessage)),
+			searchLanguageKeyboard(ctx.Lang, source))
+	}
+}
+
+// searchLanguageKeyboard creates the keyboard of the language search prompt,
+// leading back to the picker step the search was started from.
+
+func searchLanguageKeyboard(lang key.Language, source string) tgbotapi.InlineKeyboardMarkup {
+	data := pairsCallback(pickSourceStep, 0)
+	if source != "" {
+		data = pairsCallback(pickTargetStep, source, 0)
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(key.GetKey(lang, key.KeyBack), data),
+	))
+}
+
+// LanguageSearchInput handles the language name typed after pressing the search button.
+
+type LanguageSearchInput struct {
+	bot *Bot
+}
+
+// HandleInput shows the languages matching the typed name as picker buttons.
+// The source language already chosen, if any, is kept in the conversation.
+
+func (h *LanguageSearchInput) HandleInput(ctx *Context, session *fsm.Session) {
+
+	source := session.Get("source")
+
+	matches := pickableLanguages(translation.SearchLanguages(ctx.Message.Text), source)
+	if len(matches) == 0 {
+		text := key.Format(ctx.Lang, key.LanguageNotFoundMessage, key.Params{"query": ctx.Message.Text})
+		h.bot.API.Send(tgbotapi.NewMessage(ctx.ChatID, text))
+		return
+	}
+	if len(matches) > pickerPageSize {
+		matches = matches[:pickerPageSize]
+	}
+
+	rows := languageButtons(matches, source)
+	rows = append(rows,
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(key.GetKey(ctx.Lang, key.KeyChangePairs),
+				pairsCallback(pickSourceStep, 0)),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(key.GetKey(ctx.Lang, key.KeyBack), callbackData(string(key.KeyBack))),
+		),
+	)
+
+	// The results replace the previous menu keyboard, like any other menu sent as a new message
+	h.bot.MenuManager.showView(ctx.UserID, ctx.ChatID, MenuView{
+		Text:     title(key.Format(ctx.Lang, key.SearchLanguageResultMessage, key.Params{"query": ctx.Message.Text})),
+		Keyboard: tgbotapi.NewInlineKeyboardMarkup(rows...),
+	}, nil)
+}
+
+// NoopHandler handles buttons that only display information, such as the page indicator.
+
+type NoopHandler struct{}
+
+// Handle ignores the interaction.
+
+func (h *NoopHandler) Handle(ctx *Context, args cbdata.Args) {
+}
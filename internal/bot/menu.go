@@ -4,257 +4,124 @@ import (
 	"log"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
-	"github.com/mzfarshad/tlg_bot/internal/contactus"
-	"github.com/mzfarshad/tlg_bot/internal/help"
+	"github.com/mzfarshad/tlg_bot/internal/cbdata"
 	"github.com/mzfarshad/tlg_bot/internal/key"
+	"github.com/mzfarshad/tlg_bot/internal/richtext"
+	"github.com/mzfarshad/tlg_bot/internal/setting"
 )
 
-// CreateMenuManager initializes and returns a new MenuManager with registered menus.
+// Names of the content providers and visibility conditions the menu tree can refer to
+const (
+	recentPairsContent    = "recentPairs"
+	languagePickerContent = "languagePicker"
+	helpContent           = "help"
+	contactUsContent      = "contactUs"
+	botLanguagesContent   = "botLanguages"
 
-func CreateMenuManager(bot *Bot) *MenuManager {
-	menus := newMenuManager()
-
-	// Register menus for different parts of the bot
-	menus.rigesterMenu(string(key.MenuMain), &MainMenu{bot: bot})
-	menus.rigesterMenu(string(key.MenuSetting), &SettingMenu{bot: bot})
-	menus.rigesterMenu(string(key.MenuSettingLanguage), &SettingLanguageMenu{bot: bot})
-	menus.rigesterMenu(string(key.MenuTranslation), &TranslationMenu{bot: bot})
-	menus.rigesterMenu(string(key.MenuTranslationLanguagePairs), &TranslateSelectLanguagePairsMenu{bot: bot})
-	menus.rigesterMenu(string(key.MenuFinishTranslateSetup), &TranslationFinishMenu{bot: bot})
-	menus.rigesterMenu(string(key.MenuResetTranslate), &TranslationResetTranslateMenu{bot: bot})
-	menus.rigesterMenu(string(key.MenuHelp), &HelpMenu{bot: bot})
-	menus.rigesterMenu(string(key.MenuContactUs), &ContactUsMenu{bot: bot})
-
-	return menus
-}
-
-// MainMenu represents the main menu of the bot.
-
-type MainMenu struct {
-	bot *Bot
-}
-
-// ShowMenu displays the main menu to the user.
-
-func (m *MainMenu) ShowMenu(userID int, chatID int64, lang key.Language) {
-
-	// Push the current menu state to stack
-	if err := pushState(userID, chatID, string(key.MenuMain)); err != nil {
-		log.Fatal(err)
-	}
-
-	// Create the inline keyboard with options
-	keyboard := tgbotapi.NewInlineKeyboardMarkup(
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData(key.GetKey(lang, key.KeyTranslaion),
-				string(key.KeyTranslaion)),
-		),
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData(key.GetKey(lang, key.KeySettings),
-				string(key.KeySettings)),
-			tgbotapi.NewInlineKeyboardButtonData(key.GetKey(lang, key.KeyContactUs),
-				string(key.KeyContactUs)),
-			tgbotapi.NewInlineKeyboardButtonData(key.GetKey(lang, key.KeyHelp),
-				string(key.KeyHelp)),
-		),
-	)
-
-	// Create and send the message with the main menu
-	message := tgbotapi.NewMessage(chatID, key.GetMenuMessage(lang, key.MainMessage))
-	message.ReplyMarkup = keyboard
-	m.bot.API.Send(message)
-}
-
-// SettingMenu represents the settings menu of the bot.
+	adminCondition = "admin"
+)
 
-type SettingMenu struct {
-	bot *Bot
+// requiredMenus are the menus opened from code rather than from menu buttons.
+var requiredMenus = []key.MenuState{
+	key.MenuSettingLanguage,
+	key.MenuTranslation,
+	key.MenuTranslationLanguagePairs,
+	key.MenuFinishTranslateSetup,
 }
 
-// ShowMenu displays the settings menu to the user.
+// CreateMenuManager initializes and returns a new MenuManager with the menus of the menu tree.
+// The tree is read from menuFile, or the built-in definition if menuFile is empty, and validated
+// against the registered handlers; an invalid tree is returned as an error.
 
-func (m *SettingMenu) ShowMenu(userID int, chatID int64, lang key.Language) {
+func CreateMenuManager(bot *Bot, menuFile string) (*MenuManager, error) {
+	menus := newMenuManager(bot)
 
-	// Push the current menu state to stack
-	if err := pushState(userID, chatID, string(key.MenuSetting)); err != nil {
-		log.Fatal(err)
-	}
+	// Register the parts of menus rendered in code
+	menus.rigesterContent(recentPairsContent, recentPairsMenuContent)
+	menus.rigesterContent(languagePickerContent, languagePickerMenuContent)
+	menus.rigesterContent(helpContent, helpMenuContent)
+	menus.rigesterContent(contactUsContent, contactUsMenuContent)
+	menus.rigesterContent(botLanguagesContent, botLanguagesMenuContent)
 
-	buttons := []key.TextButton{key.KeySettingsLanguage}
-	keyboard := createMenuKeyboard(lang, buttons)
+	// Register the visibility conditions
+	menus.rigesterCondition(adminCondition, func(userID int) bool {
+		return bot.isAdmin(int64(userID))
+	})
 
-	// Create and send the message with the settings menu
-	message := tgbotapi.NewMessage(chatID, key.GetMenuMessage(lang, key.SettingMessage))
-	message.ReplyMarkup = keyboard
-	_, err := m.bot.API.Send(message)
+	tree, err := loadMenuTree(menuFile)
 	if err != nil {
-		log.Printf("error sending message from main menu: %v", err)
+		return nil, err
 	}
-}
-
-// SettingLanguageMenu represents the language settings menu of the bot.
-
-type SettingLanguageMenu struct {
-	bot *Bot
-}
-
-// ShowMenu displays the language settings menu to the user.
-
-func (m *SettingLanguageMenu) ShowMenu(userID int, chatID int64, lang key.Language) {
-
-	// Push the current menu state to stack
-	if err := pushState(userID, chatID, string(key.MenuSettingLanguage)); err != nil {
-		log.Fatalf("error push settings/lanuage menu state: %v", err)
+	if err := menus.load(tree, bot.HandlerManager, requiredMenus); err != nil {
+		return nil, err
 	}
 
-	// Create the inline keyboard with language options
-	keyboard := tgbotapi.NewInlineKeyboardMarkup(
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("English", string(key.LangEN)),
-			tgbotapi.NewInlineKeyboardButtonData("فارسی", string(key.LangFA)),
-		),
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData(key.GetKey(lang, key.KeyBack), string(key.KeyBack)),
-		),
-	)
-
-	// Create and send the message with the language settings menu
-	message := tgbotapi.NewMessage(chatID, key.GetMenuMessage(lang, key.SettingLanguageMessage))
-	message.ReplyMarkup = keyboard
-	m.bot.API.Send(message)
-}
-
-type TranslationMenu struct {
-	bot *Bot
+	return menus, nil
 }
 
-func (m *TranslationMenu) ShowMenu(userID int, chatID int64, lang key.Language) {
+// recentPairsMenuContent shows the recent language pairs on top of the translation menu.
 
-	if err := pushState(userID, chatID, string(key.MenuTranslation)); err != nil {
-		log.Fatalf("error push translation menu state: %v", err)
-	}
-
-	buttons := []key.TextButton{
-		key.KeyTranslateSentMessage,
-		key.KeyResetTranslationSetting,
-	}
-
-	keyboard := createMenuKeyboard(lang, buttons)
-
-	msg := key.GetMenuMessage(lang, key.TranslationMenuMessage)
-	message := tgbotapi.NewMessage(chatID, msg)
-	message.ReplyMarkup = keyboard
-
-	m.bot.API.Send(message)
+func recentPairsMenuContent(target setting.Target, lang key.Language) (richtext.Message, [][]tgbotapi.InlineKeyboardButton) {
+	return richtext.Message{}, recentPairsRows(target, recentFromMenu)
 }
 
-type TranslateSelectLanguagePairsMenu struct {
-	bot *Bot
-}
-
-func (m *TranslateSelectLanguagePairsMenu) ShowMenu(userID int, chatID int64, lang key.Language) {
-
-	if err := pushState(userID, chatID, string(key.MenuTranslationLanguagePairs)); err != nil {
-		log.Fatalf("error push translatin language pairs menu state: %v", err)
-	}
-
-	buttons := []key.TextButton{}
-
-	keyboard := createMenuKeyboard(lang, buttons)
-
-	var path string
-	if lang == key.LangEN {
-		path = "internal/key/select_lang_pairs_en.txt"
-	} else {
-		path = "internal/key/select_lang_pairs_fa.txt"
-	}
-
-	msg, err := key.ReadSelectLanguagePairsMsg(path)
-	if err != nil {
-		log.Println(err)
-	}
-
-	message := tgbotapi.NewMessage(chatID, msg)
-	message.ReplyMarkup = keyboard
+// languagePickerMenuContent starts the language picker at the first page of the source language step.
 
-	m.bot.API.Send(message)
+func languagePickerMenuContent(target setting.Target, lang key.Language) (richtext.Message, [][]tgbotapi.InlineKeyboardButton) {
+	return richtext.Message{}, languagePickerKeyboard(lang, "", 0).InlineKeyboard
 }
 
-type TranslationFinishMenu struct {
-	bot *Bot
-}
-
-func (m *TranslationFinishMenu) ShowMenu(userID int, chatID int64, lang key.Language) {
-
-	if err := pushState(userID, chatID, string(key.MenuFinishTranslateSetup)); err != nil {
-		log.Printf("error push state menu in sent language pairs: %v", err)
-	}
-
-	buttons := []key.TextButton{key.KeyFinishSetup}
-	keyboard := createMenuKeyboard(lang, buttons)
+// helpMenuContent shows the help text in the user's language under its title.
 
-	msg := key.GetMenuMessage(lang, key.TranslateFinishMessage)
-	message := tgbotapi.NewMessage(chatID, msg)
-	message.ReplyMarkup = keyboard
-
-	m.bot.API.Send(message)
+func helpMenuContent(target setting.Target, lang key.Language) (richtext.Message, [][]tgbotapi.InlineKeyboardButton) {
+	text := newText().Bold(key.GetKey(lang, key.KeyHelp)).Line().Line().Text(key.GetMenuMessage(lang, key.HelpMessage))
+	return text.Message(), nil
 }
 
-type TranslationResetTranslateMenu struct {
-	bot *Bot
-}
+// contactUsMenuContent shows the contact information under its title.
 
-func (m *TranslationResetTranslateMenu) ShowMenu(userID int, chatID int64, lang key.Language) {
-
-	if err := pushState(userID, chatID, string(key.MenuResetTranslate)); err != nil {
-		log.Printf("error push state menu in translate reset: %v", err)
-	}
-
-	button := []key.TextButton{key.KeyResetTranslateYes}
-	keyboard := createMenuKeyboard(lang, button)
-
-	message := tgbotapi.NewMessage(chatID, key.GetMenuMessage(lang, key.ResetTranslateSettingMessage))
-	message.ReplyMarkup = keyboard
-
-	m.bot.API.Send(message)
+func contactUsMenuContent(target setting.Target, lang key.Language) (richtext.Message, [][]tgbotapi.InlineKeyboardButton) {
+	text := newText().Bold(key.GetKey(lang, key.KeyContactUs)).Line().Line().Text(key.GetMenuMessage(lang, key.ContactUsMessage))
+	return text.Message(), nil
 }
 
-type HelpMenu struct {
-	bot *Bot
-}
+// botLanguagesPerRow is the number of interface languages in a row of the language menu.
+const botLanguagesPerRow = 2
 
-func (m *HelpMenu) ShowMenu(userID int, chatID int64, lang key.Language) {
+// botLanguagesMenuContent shows a button for each interface language that has a catalog, named in the language itself.
 
-	var helpFilePath string
+func botLanguagesMenuContent(target setting.Target, lang key.Language) (richtext.Message, [][]tgbotapi.InlineKeyboardButton) {
 
-	if lang == "en" {
-		helpFilePath = "internal/help/help_en.txt"
-	} else {
-		helpFilePath = "internal/help/help_fa.txt"
+	var rows [][]tgbotapi.InlineKeyboardButton
+	var row []tgbotapi.InlineKeyboardButton
+	for _, language := range key.Languages() {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(key.LanguageName(language),
+			callbackData(string(key.BotLanguageHandler), string(language))))
+		if len(row) == botLanguagesPerRow {
+			rows = append(rows, row)
+			row = nil
+		}
 	}
-
-	helpText, err := help.ReadHelpFile(helpFilePath)
-	if err != nil {
-		log.Println(err)
-		helpFilePath = "Sorry, we couldn't load the help content at the moment."
+	if len(row) > 0 {
+		rows = append(rows, row)
 	}
-
-	message := tgbotapi.NewMessage(chatID, helpText)
-
-	m.bot.API.Send(message)
-
+	return richtext.Message{}, rows
 }
 
-type ContactUsMenu struct {
+// MenuNavigationHandler handles the buttons of the menu tree that open another menu.
+
+type MenuNavigationHandler struct {
 	bot *Bot
 }
 
-func (m *ContactUsMenu) ShowMenu(userID int, chatID int64, lang key.Language) {
+// Handle shows the menu whose ID is the first argument of the callback data.
 
-	contact_us, err := contactus.ReadContactUsFile("internal/contactus/contact.txt")
-	if err != nil {
-		log.Println(err)
+func (h *MenuNavigationHandler) Handle(ctx *Context, args cbdata.Args) {
+
+	menuID := args.String(0)
+	if _, exist := h.bot.MenuManager.menus[menuID]; !exist {
+		log.Printf("menu button opens unknown menu: %s", ctx.Callback.Data)
+		return
 	}
-	message := tgbotapi.NewMessage(chatID, contact_us)
-	m.bot.API.Send(message)
+	h.bot.MenuManager.menuInteraction(ctx.settings(), menuID, ctx.Lang, ctx.Callback)
 }
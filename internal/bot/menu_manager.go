@@ -1,73 +1,273 @@
 package bot
 
 import (
+	"fmt"
 	"log"
+	"strings"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/mzfarshad/tlg_bot/internal/cbdata"
 	"github.com/mzfarshad/tlg_bot/internal/key"
+	"github.com/mzfarshad/tlg_bot/internal/richtext"
+	"github.com/mzfarshad/tlg_bot/internal/setting"
 	"github.com/mzfarshad/tlg_bot/internal/storange"
 )
 
-// MenuHandler interface defines the contract for handling menu interactions.
-// Any type that implements this interface can be used to show different menus.
+// Limits of the menu state stacks
+const (
+	menuStateTTL             = 24 * time.Hour // Time after which an unused menu state is forgotten
+	menuStateCleanupInterval = time.Hour      // Time between removals of the forgotten menu states
+	maxMenuDepth             = 10             // Number of menus kept in a menu state stack
+)
+
+// MenuView holds the rendered text and keyboard of a menu.
 
-type MenuHandler interface {
-	ShowMenu(userID int, chatID int64, lang key.Language)
+type MenuView struct {
+	Text     richtext.Message
+	Keyboard tgbotapi.InlineKeyboardMarkup
 }
 
-// MenuManager manages a collection of MenuHandlers, each associated with a unique path.
+// MenuManager builds the menus from the menu tree and shows them.
 
 type MenuManager struct {
-	Menu map[string]MenuHandler // Map to store menu handlers by their path
+	bot        *Bot                       // Bot used to send and edit menu messages
+	root       string                     // ID of the menu shown by /start
+	menus      map[string]*MenuDefinition // Map to store menu definitions by their ID
+	contents   map[string]MenuContent     // Content providers by the name used in the menu tree
+	conditions map[string]MenuCondition   // Visibility conditions by the name used in the menu tree
 }
 
-// newMenuManager creates and returns a new instance of MenuManager with an initialized menu map.
+// newMenuManager creates and returns a new instance of MenuManager with initialized maps.
 
-func newMenuManager() *MenuManager {
+func newMenuManager(bot *Bot) *MenuManager {
 
 	return &MenuManager{
-		Menu: make(map[string]MenuHandler),
+		bot:        bot,
+		menus:      make(map[string]*MenuDefinition),
+		contents:   make(map[string]MenuContent),
+		conditions: make(map[string]MenuCondition),
 	}
 }
 
-// registerMenu adds a new MenuHandler to the MenuManager under the specified path.
+// registerContent adds a content provider the menu tree can refer to by name.
+
+func (mm *MenuManager) rigesterContent(name string, content MenuContent) {
+
+	mm.contents[name] = content
+}
+
+// registerCondition adds a visibility condition the menu tree can refer to by name.
 
-func (mm *MenuManager) rigesterMenu(path string, menuHandler MenuHandler) {
+func (mm *MenuManager) rigesterCondition(name string, condition MenuCondition) {
 
-	mm.Menu[path] = menuHandler
+	mm.conditions[name] = condition
 }
 
-// menuInteraction processes a menu interaction using the appropriate MenuHandler based on the path.
-// If the menu handler does not exist, it logs a fatal error message.
+// load validates the menu tree and makes its menus available.
 
-func (mm *MenuManager) menuInteraction(userID int, chatID int64, path string, lang key.Language) {
+func (mm *MenuManager) load(tree *MenuTree, hm *HandlerManager, required []key.MenuState) error {
+
+	if err := tree.validate(hm, mm.contents, mm.conditions, required); err != nil {
+		return fmt.Errorf("invalid menu definition:\n%v", err)
+	}
 
-	if menu, exist := mm.Menu[path]; exist {
-		menu.ShowMenu(userID, chatID, lang)
-	} else {
-		log.Fatalf("menu is not exist in menu manager: %s", path)
+	mm.root = tree.Root
+	for i := range tree.Menus {
+		mm.menus[tree.Menus[i].ID] = &tree.Menus[i]
 	}
+	return nil
 }
 
-// pushState saves the new state to the menu state stack for a user and chat.
-// If the new state is different from the last one, it appends the new state.
+// visible reports whether the user meets the named condition. An empty name is always met.
 
-func pushState(userID int, chatID int64, newState string) error {
+func (mm *MenuManager) visible(condition string, userID int) bool {
+	return condition == "" || mm.conditions[condition](userID)
+}
+
+// render builds the text and keyboard of a menu for the user and place of the target,
+// leaving out buttons the user can't see.
+
+func (mm *MenuManager) render(target setting.Target, menu *MenuDefinition, lang key.Language) MenuView {
+
+	userID := target.UserID
+	var text richtext.Message
+	if menu.Message != "" {
+		// The message of a menu is its title
+		text = title(key.GetMenuMessage(lang, menu.Message))
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	if menu.Content != "" {
+		content, contentRows := mm.contents[menu.Content](target, lang)
+		if !content.IsZero() {
+			text = content
+		}
+		rows = append(rows, contentRows...)
+	}
+
+	for _, buttons := range menu.Rows {
+		var row []tgbotapi.InlineKeyboardButton
+		for _, button := range buttons {
+			if !mm.visible(button.Visible, userID) {
+				continue
+			}
+			label := button.Label
+			if button.Text != "" {
+				label = key.GetKey(lang, button.Text)
+			}
+			row = append(row, tgbotapi.NewInlineKeyboardButtonData(label, button.callbackData()))
+		}
+		if len(row) > 0 {
+			rows = append(rows, row)
+		}
+	}
+
+	if menu.Back {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(key.GetKey(lang, key.KeyBack), callbackData(string(key.KeyBack))),
+		))
+	}
+
+	return MenuView{Text: text, Keyboard: tgbotapi.NewInlineKeyboardMarkup(rows...)}
+}
+
+// renderMenu builds a menu by its ID without changing the menu state, for refreshing a shown menu.
 
-	// Retrieve the current menu state from storage
-	state, err := storange.GetMenuState(chatID, userID)
+func (mm *MenuManager) renderMenu(target setting.Target, path string, lang key.Language) (MenuView, bool) {
+
+	menu, exist := mm.menus[path]
+	if !exist || !mm.visible(menu.Visible, target.UserID) {
+		return MenuView{}, false
+	}
+	return mm.render(target, menu, lang), true
+}
+
+// menuInteraction pushes the menu to the menu state stack and shows it.
+// A navigation triggered by a callback edits the message the callback came from;
+// otherwise the menu is sent as a new message.
+// An unknown menu is logged and ignored; if the menu state can't be saved the menu is still shown.
+
+func (mm *MenuManager) menuInteraction(
+	target setting.Target, path string, lang key.Language, callback *tgbotapi.CallbackQuery) {
+
+	userID, chatID := target.UserID, target.ChatID
+	menu, exist := mm.menus[path]
+	if !exist {
+		log.Printf("menu is not exist in menu manager: %s", path)
+		return
+	}
+	if !mm.visible(menu.Visible, userID) {
+		log.Printf("menu %s is not visible to user %d", path, userID)
+		return
+	}
+
+	// Push the current menu state to stack
+	if err := pushState(userID, chatID, menu.ID); err != nil {
+		log.Printf("error push %s menu state: %v", menu.ID, err)
+	}
+
+	mm.showView(userID, chatID, mm.render(target, menu, lang), callback)
+}
+
+// showView displays a rendered menu and remembers its message as the active menu of the user.
+// When the menu is sent as a new message, the keyboard of the previous menu message is removed,
+// so only one menu keyboard per user and chat stays usable.
+
+func (mm *MenuManager) showView(userID int, chatID int64, view MenuView, callback *tgbotapi.CallbackQuery) {
+
+	active, err := storange.GetMenuMessageID(userID, chatID)
 	if err != nil {
-		return err
+		log.Println(err)
 	}
 
-	// Append the new state if it is different from the last state
-	if len(state) == 0 || state[len(state)-1] != newState {
-		state = append(state, newState)
-		if err := storange.SaveMenuState(userID, chatID, state); err != nil {
-			return err
+	if callback != nil && callback.Message != nil {
+		messageID := callback.Message.MessageID
+		if err := mm.bot.editMessage(chatID, messageID, view.Text, view.Keyboard); err == nil {
+			if messageID != active {
+				if err := storange.SaveMenuMessageID(userID, chatID, messageID); err != nil {
+					log.Println(err)
+				}
+			}
+			return
 		}
+		// The message can't be edited any more, for example because it's too old
+		mm.bot.disableKeyboard(chatID, messageID)
+	}
+
+	message := tgbotapi.NewMessage(chatID, "")
+	if len(view.Keyboard.InlineKeyboard) > 0 {
+		message.ReplyMarkup = view.Keyboard
+	}
+	sent, err := mm.bot.sendText(message, view.Text)
+	if err != nil {
+		log.Printf("error sending menu: %v", err)
+		return
+	}
+
+	if active != 0 && active != sent.MessageID {
+		mm.bot.disableKeyboard(chatID, active)
+	}
+	if err := storange.SaveMenuMessageID(userID, chatID, sent.MessageID); err != nil {
+		log.Println(err)
 	}
-	return nil
+}
+
+// menuCheck is the result of checking the menu message a button was pressed on.
+type menuCheck int
+
+// Results of checking a menu message
+const (
+	menuActive    menuCheck = iota // The active menu of the user pressing the button
+	menuStale                      // An older menu message
+	menuOtherUser                  // The active menu of another user of the group
+)
+
+// checkMenu reports whether the callback came from the active menu of the user pressing the button.
+// In a group several users see the same menu messages, so a user may only use their own active menu.
+// In a private chat a menu is accepted while no active menu was recorded, for menus sent before they were.
+
+func (mm *MenuManager) checkMenu(userID int, chatID int64, callback *tgbotapi.CallbackQuery) menuCheck {
+
+	if callback.Message == nil {
+		return menuActive
+	}
+	messageID := callback.Message.MessageID
+	active, err := storange.GetMenuMessageID(userID, chatID)
+	if err != nil {
+		log.Println(err)
+		return menuActive
+	}
+	if active == messageID || active == 0 && chatID == int64(userID) {
+		return menuActive
+	}
+
+	owner, err := storange.GetMenuMessageOwner(chatID, messageID)
+	if err != nil {
+		log.Println(err)
+	} else if owner != 0 && owner != userID {
+		return menuOtherUser
+	}
+	return menuStale
+}
+
+// pushState saves the new state to the menu state stack for a user and chat.
+// If the new state is different from the last one, it appends the new state.
+// The stack keeps its root and the latest states up to maxMenuDepth.
+
+func pushState(userID int, chatID int64, newState string) error {
+
+	_, err := storange.UpdateMenuState(userID, chatID, menuStateTTL, func(state []string) []string {
+		// Append the new state if it is different from the last state
+		if len(state) == 0 || state[len(state)-1] != newState {
+			state = append(state, newState)
+		}
+		if len(state) > maxMenuDepth {
+			state = append(state[:1], state[len(state)-maxMenuDepth+1:]...)
+		}
+		return state
+	})
+	return err
 }
 
 // popState removes the last state from the menu state stack for a user and chat.
@@ -75,42 +275,113 @@ func pushState(userID int, chatID int64, newState string) error {
 
 func popState(userID int, chatID int64) string {
 
-	// Retrieve the current menu state from storag.
-	state, _ := storange.GetMenuState(chatID, userID)
-
-	// Remove the last state if there are more than one state
-	if len(state) > 1 {
-		state = state[:len(state)-1]
+	state, err := storange.UpdateMenuState(userID, chatID, menuStateTTL, func(state []string) []string {
+		// Remove the last state if there are more than one state
+		if len(state) > 1 {
+			state = state[:len(state)-1]
+		}
+		return state
+	})
+	if err != nil {
+		log.Println(err)
+		return ""
 	}
 
 	// Return the new top state or an empty string if the stack is empty
-	storange.SaveMenuState(userID, chatID, state)
 	if len(state) > 0 {
 		return state[len(state)-1]
 	}
 	return ""
 }
 
-func createMenuKeyboard(lang key.Language, buttonKeys []key.TextButton) tgbotapi.InlineKeyboardMarkup {
-	var keyboardRows [][]tgbotapi.InlineKeyboardButton
+// peekState returns the top state of the menu state stack without removing it.
+// It returns an empty string if the stack is empty or cannot be read.
+
+func peekState(userID int, chatID int64) string {
+
+	state, err := storange.GetMenuState(chatID, userID, menuStateTTL)
+	if err != nil {
+		log.Println(err)
+		return ""
+	}
+	if len(state) > 0 {
+		return state[len(state)-1]
+	}
+	return ""
+}
 
-	for i, buttonKey := range buttonKeys {
-		buttonText := key.GetKey(lang, buttonKey)
-		button := tgbotapi.NewInlineKeyboardButtonData(buttonText, string(buttonKey))
+// expireMenuStates removes the menu states of users who haven't used the menus within menuStateTTL, every hour.
 
-		if i%2 == 0 {
-			keyboardRows = append(keyboardRows, []tgbotapi.InlineKeyboardButton{button})
-		} else {
-			keyboardRows[len(keyboardRows)-1] = append(keyboardRows[len(keyboardRows)-1], button)
+func expireMenuStates() {
+	for range time.Tick(menuStateCleanupInterval) {
+		n, err := storange.DeleteExpiredMenuStates(menuStateTTL)
+		if err != nil {
+			log.Println(err)
+		} else if n > 0 {
+			log.Printf("removed %d expired menu states", n)
 		}
 	}
+}
+
+// emptyKeyboard returns a markup without buttons, used to remove the keyboard of an edited message.
+
+func emptyKeyboard() tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.InlineKeyboardMarkup{InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{}}
+}
+
+// pageArg parses the page number argument at the given index, defaulting to the first page.
+
+func pageArg(args cbdata.Args, i int) int {
+	page, err := args.Int(i)
+	if err != nil {
+		return 0
+	}
+	return page
+}
+
+// isNotModified reports whether Telegram refused an edit because nothing changed.
+
+func isNotModified(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "message is not modified")
+}
+
+// editMessage replaces the text and keyboard of a message, with the text unformatted if Telegram
+// can't parse its formatting. An edit that changes nothing is not an error.
+
+func (b *Bot) editMessage(chatID int64, messageID int, text richtext.Message, keyboard tgbotapi.InlineKeyboardMarkup) error {
+	edit := tgbotapi.NewEditMessageTextAndMarkup(chatID, messageID, text.Text, keyboard)
+	edit.ParseMode = string(text.Mode)
+	_, err := b.API.Send(edit)
+	if isParseError(err) && text.Mode != richtext.Plain {
+		log.Printf("error formatting message %d, editing it as plain text: %v", messageID, err)
+		edit.Text, edit.ParseMode = text.Plain, ""
+		_, err = b.API.Send(edit)
+	}
+	if err != nil && !isNotModified(err) {
+		log.Printf("error editing message %d: %v", messageID, err)
+		return err
+	}
+	return nil
+}
+
+// editCallbackMessage replaces the text and keyboard of the message the callback came from.
+
+func (b *Bot) editCallbackMessage(callback *tgbotapi.CallbackQuery, text richtext.Message, keyboard tgbotapi.InlineKeyboardMarkup) {
+	b.editMessage(callback.Message.Chat.ID, callback.Message.MessageID, text, keyboard)
+}
 
-	backButtonRow := tgbotapi.NewInlineKeyboardRow(
-		tgbotapi.NewInlineKeyboardButtonData(key.GetKey(lang, key.KeyBack), string(key.KeyBack)),
-	)
-	keyboardRows = append(keyboardRows, backButtonRow)
+// editKeyboard replaces the keyboard of a message, keeping its text.
+
+func (b *Bot) editKeyboard(chatID int64, messageID int, keyboard tgbotapi.InlineKeyboardMarkup) {
+	edit := tgbotapi.NewEditMessageReplyMarkup(chatID, messageID, keyboard)
+	if _, err := b.API.Send(edit); err != nil && !isNotModified(err) {
+		log.Printf("error editing keyboard of message %d: %v", messageID, err)
+	}
+}
 
-	inlineKeyboard := tgbotapi.NewInlineKeyboardMarkup(keyboardRows...)
+// disableKeyboard removes the keyboard of an outdated message.
+// Messages that were deleted or can no longer be edited are left alone.
 
-	return inlineKeyboard
+func (b *Bot) disableKeyboard(chatID int64, messageID int) {
+	b.editKeyboard(chatID, messageID, emptyKeyboard())
 }
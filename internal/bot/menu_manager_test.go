@@ -0,0 +1,46 @@
+package bot
+
+import (
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/mzfarshad/tlg_bot/internal/storange"
+)
+
+func TestCheckMenuInGroups(t *testing.T) {
+	useTestDB(t)
+	mm := &MenuManager{}
+
+	const group = -100
+	pressed := func(messageID int) *tgbotapi.CallbackQuery {
+		return &tgbotapi.CallbackQuery{Message: &tgbotapi.Message{MessageID: messageID}}
+	}
+	// User 1 opened the menu in message 10 and then 11, user 2 in message 12
+	for _, menu := range []struct{ userID, messageID int }{{1, 10}, {1, 11}, {2, 12}} {
+		if err := storange.SaveMenuMessageID(menu.userID, group, menu.messageID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		userID, messageID int
+		want              menuCheck
+	}{
+		{1, 11, menuActive},
+		{1, 10, menuStale},
+		{1, 12, menuOtherUser},
+		{2, 11, menuOtherUser},
+		{3, 11, menuOtherUser}, // A user who never opened a menu in the group
+		{3, 10, menuStale},
+	}
+	for _, test := range tests {
+		if got := mm.checkMenu(test.userID, group, pressed(test.messageID)); got != test.want {
+			t.Errorf("user %d pressing message %d = %d, want %d", test.userID, test.messageID, got, test.want)
+		}
+	}
+
+	// In a private chat a menu is accepted while no active menu was recorded
+	if got := mm.checkMenu(4, 4, pressed(5)); got != menuActive {
+		t.Errorf("menu of a private chat without an active menu = %d, want active", got)
+	}
+}
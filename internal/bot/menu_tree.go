@@ -0,0 +1,196 @@
+package bot
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/mzfarshad/tlg_bot/internal/cbdata"
+	"github.com/mzfarshad/tlg_bot/internal/key"
+	"github.com/mzfarshad/tlg_bot/internal/richtext"
+	"github.com/mzfarshad/tlg_bot/internal/setting"
+)
+
+// defaultMenuTree is the menu definition used when no menu file is configured.
+//
+//go:embed menus.json
+var defaultMenuTree []byte
+
+// MenuTree is the declarative definition of the bot menus.
+
+type MenuTree struct {
+	Root  string           `json:"root"`  // ID of the menu shown by /start
+	Menus []MenuDefinition `json:"menus"` // All menus of the bot
+}
+
+// MenuDefinition describes one menu: its message, its button rows and who can see it.
+
+type MenuDefinition struct {
+	ID      string               `json:"id"`      // Menu ID, also stored in the menu state stack
+	Message key.TextMessage      `json:"message"` // Key of the menu message
+	Content string               `json:"content"` // Name of the content provider adding text or buttons rendered in code
+	Rows    [][]ButtonDefinition `json:"rows"`    // Button rows, shown after the buttons of the content provider
+	Back    bool                 `json:"back"`    // Whether a back button row is added at the bottom
+	Visible string               `json:"visible"` // Name of the condition the user must meet to open the menu
+}
+
+// ButtonDefinition describes one menu button.
+// A button has either a translated text or a literal label, and either opens a menu or calls a handler.
+
+type ButtonDefinition struct {
+	Text    key.TextButton `json:"text"`    // Key of the button text
+	Label   string         `json:"label"`   // Literal button text, shown as is in every language
+	Menu    string         `json:"menu"`    // ID of the menu opened by the button
+	Handler string         `json:"handler"` // Name of the handler called by the button
+	Args    []string       `json:"args"`    // Arguments passed to the handler
+	Visible string         `json:"visible"` // Name of the condition the user must meet to see the button
+}
+
+// MenuContent renders the part of a menu that can't be defined in the menu file, for the user and place
+// the menu is shown to. A non-empty text replaces the menu message; the rows are placed above the defined rows.
+
+type MenuContent func(target setting.Target, lang key.Language) (richtext.Message, [][]tgbotapi.InlineKeyboardButton)
+
+// MenuCondition reports whether a menu or button is visible to the user.
+
+type MenuCondition func(userID int) bool
+
+// loadMenuTree reads the menu definition from the given file, or the built-in definition if path is empty.
+
+func loadMenuTree(path string) (*MenuTree, error) {
+
+	data := defaultMenuTree
+	if path != "" {
+		var err error
+		data, err = os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading menu file %s: %v", path, err)
+		}
+	}
+
+	var tree MenuTree
+	if err := json.Unmarshal(data, &tree); err != nil {
+		return nil, fmt.Errorf("error parsing menu definition: %v", err)
+	}
+	return &tree, nil
+}
+
+// data returns the callback data of the button, before encoding.
+
+func (b ButtonDefinition) data() cbdata.Data {
+	if b.Menu != "" {
+		return cbdata.New(string(key.MenuHandler), b.Menu)
+	}
+	args := make([]any, len(b.Args))
+	for i, arg := range b.Args {
+		args[i] = arg
+	}
+	return cbdata.New(b.Handler, args...)
+}
+
+// callbackData returns the encoded callback data of the button.
+
+func (b ButtonDefinition) callbackData() string {
+	return encodeCallback(b.data())
+}
+
+// validate checks the menu tree against the registered handlers, content providers and conditions
+// and the available translations. The required menus are the ones opened from code.
+// All problems are reported together.
+
+func (t *MenuTree) validate(hm *HandlerManager, contents map[string]MenuContent,
+	conditions map[string]MenuCondition, required []key.MenuState) error {
+
+	var errs []error
+	fail := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	ids := make(map[string]bool)
+	for _, menu := range t.Menus {
+		if menu.ID == "" {
+			fail("menu without id")
+			continue
+		}
+		if ids[menu.ID] {
+			fail("menu %s is defined twice", menu.ID)
+		}
+		ids[menu.ID] = true
+	}
+
+	if !ids[t.Root] {
+		fail("root menu %q is not defined", t.Root)
+	}
+	for _, id := range required {
+		if !ids[string(id)] {
+			fail("menu %s is opened from code but not defined", id)
+		}
+	}
+
+	for _, menu := range t.Menus {
+		if menu.Message == "" && menu.Content == "" {
+			fail("menu %s has neither a message nor a content provider", menu.ID)
+		}
+		if menu.Message != "" {
+			if !key.HasMessage(menu.Message) {
+				fail("menu %s: message %s has no text", menu.ID, menu.Message)
+			}
+		}
+		if _, ok := contents[menu.Content]; menu.Content != "" && !ok {
+			fail("menu %s: unknown content provider %s", menu.ID, menu.Content)
+		}
+		if _, ok := conditions[menu.Visible]; menu.Visible != "" && !ok {
+			fail("menu %s: unknown visibility condition %s", menu.ID, menu.Visible)
+		}
+		if len(menu.Rows) == 0 && menu.Content == "" && !menu.Back {
+			fail("menu %s has no buttons", menu.ID)
+		}
+
+		for i, row := range menu.Rows {
+			if len(row) == 0 {
+				fail("menu %s: row %d is empty", menu.ID, i+1)
+			}
+			for _, button := range row {
+				where := fmt.Sprintf("menu %s, row %d", menu.ID, i+1)
+
+				switch {
+				case button.Text != "" && button.Label != "":
+					fail("%s: button has both a text and a label", where)
+				case button.Text == "" && button.Label == "":
+					fail("%s: button has neither a text nor a label", where)
+				case button.Text != "":
+					if !key.HasButton(button.Text) {
+						fail("%s: button text %s has no text", where, button.Text)
+					}
+				}
+
+				switch {
+				case button.Menu != "" && button.Handler != "":
+					fail("%s: button has both menu %s and handler %s", where, button.Menu, button.Handler)
+				case button.Menu != "":
+					if !ids[button.Menu] {
+						fail("%s: button opens undefined menu %s", where, button.Menu)
+					}
+				case button.Handler != "":
+					if _, exist := hm.handler[button.Handler]; !exist {
+						fail("%s: button calls unknown handler %s", where, button.Handler)
+					}
+				default:
+					fail("%s: button has neither a menu nor a handler", where)
+				}
+
+				if _, err := button.data().Encode(); err != nil {
+					fail("%s: %v", where, err)
+				}
+				if _, ok := conditions[button.Visible]; button.Visible != "" && !ok {
+					fail("%s: unknown visibility condition %s", where, button.Visible)
+				}
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
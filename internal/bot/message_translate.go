@@ -0,0 +1,69 @@
+package bot
+
+import (
+	"log"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/mzfarshad/tlg_bot/internal/key"
+	"github.com/mzfarshad/tlg_bot/internal/setting"
+	"github.com/mzfarshad/tlg_bot/internal/storange"
+)
+
+// translationReplyKeyboard creates the buttons attached to a translation reply.
+
+func translationReplyKeyboard(lang key.Language, source, target string) tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(
+		feedbackRow(lang),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(key.GetKey(lang, key.KeySavePhrase),
+				phrasebookCallback(phraseSaveAction, source, target)),
+		),
+	)
+}
+
+// translateMessageHandle replies to a text message sent in a private chat with its translation,
+// using the user's active language pair.
+
+func (b *Bot) translateMessageHandle(ctx *Context) {
+
+	userID := ctx.UserID
+
+	current, err := setting.GetTranslationSetting(ctx.settings())
+	if err != nil || !current.ActiveTranslation {
+		if err != nil {
+			log.Printf("message translation: %v, UserID: %d", err, userID)
+		}
+		b.API.Send(tgbotapi.NewMessage(ctx.ChatID, key.GetMenuMessage(ctx.Lang, key.TranslationNotActiveMessage)))
+		return
+	}
+
+	result, err := translate(userID, ctx.Message.Text, current.SourceLanguage, current.TargetLanguage)
+	if err != nil || result.Text == "" {
+		if err != nil {
+			log.Printf("error in translate message from api translate: %v, UserID: %d", err, userID)
+		}
+		b.API.Send(tgbotapi.NewMessage(ctx.ChatID, key.GetMenuMessage(ctx.Lang, key.TranslationFailedMessage)))
+		return
+	}
+
+	recordTranslation(storange.HistoryEntry{
+		UserID:         userID,
+		SourceText:     ctx.Message.Text,
+		TranslatedText: result.Text,
+		SourceLanguage: current.SourceLanguage,
+		TargetLanguage: current.TargetLanguage,
+		Provider:       result.Provider,
+	})
+
+	// Reply to the original message so the buttons can find the source text later
+	reply := tgbotapi.NewMessage(ctx.ChatID, "")
+	reply.ReplyToMessageID = ctx.Message.MessageID
+	reply.ReplyMarkup = translationReplyKeyboard(ctx.Lang, current.SourceLanguage, current.TargetLanguage)
+	text := newText().Bold(targetLabel(current.TargetLanguage)).Line().Text(result.Text)
+	sent, err := b.sendText(reply, text.Message())
+	if err != nil {
+		log.Printf("error sending translation reply: %v, UserID: %d", err, userID)
+		return
+	}
+	saveTranslationReply(sent, ctx.Message.Text, current.SourceLanguage, current.TargetLanguage, result)
+}
@@ -0,0 +1,232 @@
+package bot
+
+import (
+	"sync"
+	"time"
+)
+
+// Metrics counts the handled updates and their handling time per kind of update.
+
+type Metrics struct {
+	mu      sync.Mutex
+	started time.Time
+	updates map[string]*updateMetrics
+	queue   queueMetrics
+}
+
+// updateMetrics are the counters of one kind of update.
+
+type updateMetrics struct {
+	count      int64
+	panics     int64
+	throttled  int64
+	rejected   int64
+	duplicates int64
+	total      time.Duration
+	max        time.Duration
+}
+
+// queueMetrics are the counters of the update queue of the workers.
+
+type queueMetrics struct {
+	workers   int
+	capacity  int
+	depth     func() (total, fullest int) // Current number of queued updates, nil without workers
+	enqueued  int64
+	blocked   int64
+	dropped   int64
+	rejected  int64
+	waitTotal time.Duration
+	waitMax   time.Duration
+	handled   int64
+}
+
+// UpdateStats is a snapshot of the counters of one kind of update.
+
+type UpdateStats struct {
+	Count      int64   `json:"count"`      // Updates handled, including throttled and rejected ones
+	Panics     int64   `json:"panics"`     // Handlers that panicked
+	Throttled  int64   `json:"throttled"`  // Updates dropped because the user sent too many
+	Rejected   int64   `json:"rejected"`   // Updates dropped because the user is banned or a bot
+	Duplicates int64   `json:"duplicates"` // Updates dropped because Telegram delivered them again, not included in Count
+	AverageMS  float64 `json:"average_ms"` // Average handling time
+	MaxMS      float64 `json:"max_ms"`     // Longest handling time
+}
+
+// QueueStats is a snapshot of the counters of the update queue, showing how far the workers lag behind.
+
+type QueueStats struct {
+	Workers       int     `json:"workers"`         // Number of workers
+	Capacity      int     `json:"capacity"`        // Updates all queues can hold
+	Depth         int     `json:"depth"`           // Updates waiting in all queues
+	MaxDepth      int     `json:"max_depth"`       // Updates waiting in the fullest queue
+	Enqueued      int64   `json:"enqueued"`        // Updates queued
+	Blocked       int64   `json:"blocked"`         // Updates that waited for room in a full queue
+	Dropped       int64   `json:"dropped"`         // Updates dropped because their queue was full
+	Rejected      int64   `json:"rejected"`        // Updates refused because their queue was full
+	AverageWaitMS float64 `json:"average_wait_ms"` // Average time an update waited in the queue
+	MaxWaitMS     float64 `json:"max_wait_ms"`     // Longest time an update waited in the queue
+}
+
+// MetricsSnapshot is a copy of all counters, ready to be encoded as JSON.
+
+type MetricsSnapshot struct {
+	Uptime  string                 `json:"uptime"`
+	Updates map[string]UpdateStats `json:"updates"`
+	Queue   *QueueStats            `json:"queue,omitempty"` // Nil when updates aren't queued
+}
+
+// newMetrics creates empty metrics.
+
+func newMetrics() *Metrics {
+	return &Metrics{started: time.Now(), updates: make(map[string]*updateMetrics)}
+}
+
+// get returns the counters of a kind of update. The lock must be held.
+
+func (m *Metrics) get(kind string) *updateMetrics {
+	counters, exist := m.updates[kind]
+	if !exist {
+		counters = &updateMetrics{}
+		m.updates[kind] = counters
+	}
+	return counters
+}
+
+// observe records a handled update and its handling time.
+
+func (m *Metrics) observe(kind string, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	counters := m.get(kind)
+	counters.count++
+	counters.total += duration
+	if duration > counters.max {
+		counters.max = duration
+	}
+}
+
+// panicked records an update whose handler panicked.
+
+func (m *Metrics) panicked(kind string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.get(kind).panics++
+}
+
+// throttled records an update dropped by the throttle.
+
+func (m *Metrics) throttled(kind string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.get(kind).throttled++
+}
+
+// rejected records an update dropped by the access check.
+
+func (m *Metrics) rejected(kind string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.get(kind).rejected++
+}
+
+// watchQueue starts counting the update queue of the workers, whose current depth is returned by depth.
+
+func (m *Metrics) watchQueue(workers, capacity int, depth func() (total, fullest int)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queue.workers, m.queue.capacity, m.queue.depth = workers, capacity, depth
+}
+
+// enqueued records an update added to the queue.
+
+func (m *Metrics) enqueued() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queue.enqueued++
+}
+
+// blocked records an update waiting for room in a full queue.
+
+func (m *Metrics) blocked() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queue.blocked++
+}
+
+// overflowed records an update that didn't fit in its queue, rejected or dropped.
+
+func (m *Metrics) overflowed(rejected bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if rejected {
+		m.queue.rejected++
+	} else {
+		m.queue.dropped++
+	}
+}
+
+// dequeued records an update taken from the queue by a worker and the time it waited.
+
+func (m *Metrics) dequeued(wait time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queue.handled++
+	m.queue.waitTotal += wait
+	if wait > m.queue.waitMax {
+		m.queue.waitMax = wait
+	}
+}
+
+// duplicated records an update dropped because it was processed already.
+
+func (m *Metrics) duplicated(kind string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.get(kind).duplicates++
+}
+
+// Snapshot returns a copy of the counters.
+
+func (m *Metrics) Snapshot() MetricsSnapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snapshot := MetricsSnapshot{
+		Uptime:  time.Since(m.started).Round(time.Second).String(),
+		Updates: make(map[string]UpdateStats, len(m.updates)),
+	}
+	for kind, counters := range m.updates {
+		stats := UpdateStats{
+			Count:      counters.count,
+			Panics:     counters.panics,
+			Throttled:  counters.throttled,
+			Rejected:   counters.rejected,
+			Duplicates: counters.duplicates,
+			MaxMS:      float64(counters.max) / float64(time.Millisecond),
+		}
+		if counters.count > 0 {
+			stats.AverageMS = float64(counters.total) / float64(counters.count) / float64(time.Millisecond)
+		}
+		snapshot.Updates[kind] = stats
+	}
+
+	if queue := m.queue; queue.depth != nil {
+		stats := &QueueStats{
+			Workers:   queue.workers,
+			Capacity:  queue.capacity,
+			Enqueued:  queue.enqueued,
+			Blocked:   queue.blocked,
+			Dropped:   queue.dropped,
+			Rejected:  queue.rejected,
+			MaxWaitMS: float64(queue.waitMax) / float64(time.Millisecond),
+		}
+		stats.Depth, stats.MaxDepth = queue.depth()
+		if queue.handled > 0 {
+			stats.AverageWaitMS = float64(queue.waitTotal) / float64(queue.handled) / float64(time.Millisecond)
+		}
+		snapshot.Queue = stats
+	}
+	return snapshot
+}
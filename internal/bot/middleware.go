@@ -0,0 +1,154 @@
+package bot
+
+import (
+	"log"
+	"log/slog"
+	"runtime/debug"
+	"time"
+
+	"github.com/mzfarshad/tlg_bot/internal/key"
+	"github.com/mzfarshad/tlg_bot/internal/setting"
+	"github.com/mzfarshad/tlg_bot/internal/storange"
+)
+
+// UpdateHandler handles an update described by its context.
+
+type UpdateHandler func(ctx *Context)
+
+// Middleware wraps an UpdateHandler with behaviour shared by all updates.
+// A middleware can stop an update by not calling next.
+
+type Middleware func(next UpdateHandler) UpdateHandler
+
+// chain wraps the handler with the middlewares; the first middleware is the outermost one.
+
+func chain(handler UpdateHandler, middlewares ...Middleware) UpdateHandler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// defaultMiddlewares are the middlewares every update goes through, outermost first.
+
+func (b *Bot) defaultMiddlewares() []Middleware {
+	return []Middleware{
+		b.recoverPanics,
+		b.measure,
+		b.logUpdates,
+		b.throttleUsers,
+		b.checkAccess,
+		b.resolveLanguage,
+	}
+}
+
+// recoverPanics keeps a panicking handler from taking the whole bot down.
+// The update is forgotten by the de-duplication, so it's handled again if Telegram delivers it again.
+
+func (b *Bot) recoverPanics(next UpdateHandler) UpdateHandler {
+	return func(ctx *Context) {
+		defer func() {
+			if r := recover(); r != nil {
+				b.Metrics.panicked(ctx.Kind)
+				b.updates.forget(ctx.Update.UpdateID)
+				slog.Error("panic while handling update", "update_id", ctx.Update.UpdateID,
+					"kind", ctx.Kind, "user_id", ctx.UserID, "panic", r, "stack", string(debug.Stack()))
+			}
+		}()
+		next(ctx)
+	}
+}
+
+// measure records the handling time of the update.
+
+func (b *Bot) measure(next UpdateHandler) UpdateHandler {
+	return func(ctx *Context) {
+		defer func() {
+			b.Metrics.observe(ctx.Kind, time.Since(ctx.Started))
+		}()
+		next(ctx)
+	}
+}
+
+// logUpdates writes one structured log line per handled update.
+
+func (b *Bot) logUpdates(next UpdateHandler) UpdateHandler {
+	return func(ctx *Context) {
+		next(ctx)
+		slog.Info("update handled", "update_id", ctx.Update.UpdateID, "kind", ctx.Kind,
+			"user_id", ctx.UserID, "chat_id", ctx.ChatID, "lang", ctx.Lang,
+			"duration", time.Since(ctx.Started))
+	}
+}
+
+// checkAccess drops updates sent by other bots and by banned users, and marks updates sent by admins.
+// Admins can't be banned.
+
+func (b *Bot) checkAccess(next UpdateHandler) UpdateHandler {
+	return func(ctx *Context) {
+		if ctx.User != nil {
+			ctx.Admin = b.isAdmin(ctx.User.ID)
+
+			if ctx.User.IsBot {
+				b.Metrics.rejected(ctx.Kind)
+				return
+			}
+
+			if !ctx.Admin {
+				banned, err := storange.IsBanned(ctx.UserID)
+				if err != nil {
+					log.Println(err)
+				}
+				if banned {
+					b.Metrics.rejected(ctx.Kind)
+					slog.Info("update from banned user dropped", "update_id", ctx.Update.UpdateID, "user_id", ctx.UserID)
+					return
+				}
+			}
+		}
+		next(ctx)
+	}
+}
+
+// throttleUsers drops messages and button presses of users sending more than the throttle allows.
+// Inline queries are left out, as Telegram sends one per typed character. It runs before the middlewares
+// reading the database, so a flood doesn't reach it; the toast is in the language of the user's client.
+
+func (b *Bot) throttleUsers(next UpdateHandler) UpdateHandler {
+	return func(ctx *Context) {
+		limited := ctx.Kind == commandUpdate || ctx.Kind == messageUpdate || ctx.Kind == callbackUpdate
+		if limited && !b.isAdmin(int64(ctx.UserID)) && !b.throttle.allow(ctx.UserID) {
+			b.Metrics.throttled(ctx.Kind)
+			slog.Warn("update throttled", "update_id", ctx.Update.UpdateID, "kind", ctx.Kind, "user_id", ctx.UserID)
+			if ctx.Callback != nil {
+				lang, _ := key.Closest(ctx.User.LanguageCode)
+				b.answerCallback(ctx.Callback, key.GetMenuMessage(lang, key.ThrottledMessage), false)
+			}
+			return
+		}
+		next(ctx)
+	}
+}
+
+// resolveLanguage sets the interface language of the user in the context.
+// When the bot sees a user who hasn't chosen a language for the first time in a while, it infers one
+// from their Telegram client.
+// The language falls back to English if the setting can't be read.
+
+func (b *Bot) resolveLanguage(next UpdateHandler) UpdateHandler {
+	return func(ctx *Context) {
+		if ctx.User != nil {
+			if b.seenUsers.check(ctx.UserID, ctx.User.LanguageCode) {
+				if _, err := setting.InferBotLanguage(ctx.UserID, ctx.User.LanguageCode); err != nil {
+					log.Println(err)
+				}
+			}
+			lang, _, err := setting.BotLanguage(ctx.settings())
+			if err != nil {
+				log.Println(err)
+			}
+			ctx.Lang = lang
+		}
+		next(ctx)
+	}
+}
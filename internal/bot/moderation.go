@@ -0,0 +1,57 @@
+package bot
+
+import (
+	"log"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/mzfarshad/tlg_bot/internal/key"
+	"github.com/mzfarshad/tlg_bot/internal/storange"
+)
+
+// BanCommandHandler handles the /ban and /unban commands, available to admins only.
+
+type BanCommandHandler struct {
+	bot *Bot
+}
+
+// Handle bans or unbans the user whose ID is the first argument of the command.
+// The rest of a /ban command is kept as the reason.
+
+func (h *BanCommandHandler) Handle(ctx *Context) {
+
+	if !ctx.Admin {
+		h.bot.API.Send(tgbotapi.NewMessage(ctx.ChatID, key.GetMenuMessage(ctx.Lang, key.AdminOnlyMessage)))
+		return
+	}
+
+	idArg, reason, _ := strings.Cut(strings.TrimSpace(ctx.Message.CommandArguments()), " ")
+	userID, err := strconv.Atoi(idArg)
+	if err != nil || userID <= 0 {
+		h.bot.API.Send(tgbotapi.NewMessage(ctx.ChatID, key.GetMenuMessage(ctx.Lang, key.BanUsageMessage)))
+		return
+	}
+
+	var text string
+	if key.HandlerName(strings.ToLower(ctx.Message.Command())) == key.BanHandler {
+		if err := storange.BanUser(userID, strings.TrimSpace(reason), ctx.UserID); err != nil {
+			log.Println(err)
+			return
+		}
+		text = key.Format(ctx.Lang, key.UserBannedMessage, key.Params{"user": strconv.Itoa(userID)})
+	} else {
+		banned, err := storange.UnbanUser(userID)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		message := key.UserUnbannedMessage
+		if !banned {
+			message = key.UserNotBannedMessage
+		}
+		text = key.Format(ctx.Lang, message, key.Params{"user": strconv.Itoa(userID)})
+	}
+
+	h.bot.API.Send(tgbotapi.NewMessage(ctx.ChatID, text))
+}
@@ -0,0 +1,304 @@
+package bot
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/mzfarshad/tlg_bot/internal/bidi"
+	"github.com/mzfarshad/tlg_bot/internal/cbdata"
+	"github.com/mzfarshad/tlg_bot/internal/key"
+	"github.com/mzfarshad/tlg_bot/internal/richtext"
+	"github.com/mzfarshad/tlg_bot/internal/storange"
+)
+
+// phrasebookPageSize is the number of phrases listed on one page of a language pair.
+const phrasebookPageSize = 5
+
+// Actions of the phrasebook carried in the callback data ("phrasebook|<action>|<args>...")
+const (
+	phraseSaveAction     = "a" // phrasebook|a|<source>|<target>
+	phraseOverviewAction = "o" // phrasebook|o
+	phraseListAction     = "l" // phrasebook|l|<source>|<target>|<page>
+	phraseDeleteAction   = "d" // phrasebook|d|<id>|<source>|<target>|<page>
+	phraseExportAction   = "x" // phrasebook|x|<csv or txt>
+)
+
+// Formats the phrasebook can be exported in
+const (
+	exportCSV  = "csv"
+	exportText = "txt"
+)
+
+// phrasebookCallback builds the callback data for a phrasebook button.
+
+func phrasebookCallback(args ...any) string {
+	return callbackData(string(key.PhrasebookHandler), args...)
+}
+
+// markButton replaces the text and callback data of the button carrying the given callback data.
+// It's used to turn an action button into an inactive label once the action is done.
+
+func markButton(markup *tgbotapi.InlineKeyboardMarkup, data, text string) tgbotapi.InlineKeyboardMarkup {
+	if markup == nil {
+		return emptyKeyboard()
+	}
+
+	noop := callbackData(string(key.NoopHandler))
+	for i, row := range markup.InlineKeyboard {
+		for j, button := range row {
+			if button.CallbackData != nil && *button.CallbackData == data {
+				markup.InlineKeyboard[i][j].Text = text
+				markup.InlineKeyboard[i][j].CallbackData = &noop
+			}
+		}
+	}
+	return *markup
+}
+
+// phrasebookOverview renders the list of language pairs in the user's phrasebook.
+
+func phrasebookOverview(userID int, lang key.Language) (richtext.Message, tgbotapi.InlineKeyboardMarkup) {
+
+	pairs, err := storange.GetPhrasePairs(userID)
+	if err != nil {
+		log.Println(err)
+	}
+	if len(pairs) == 0 {
+		return richtext.PlainText(key.GetMenuMessage(lang, key.PhrasebookEmptyMessage)), emptyKeyboard()
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, pair := range pairs {
+		label := fmt.Sprintf("%s (%s)", pairLabel(pair.Source, pair.Target), formatNumber(lang, pair.Count))
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(label, phrasebookCallback(phraseListAction, pair.Source, pair.Target, 0)),
+		))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(key.GetKey(lang, key.KeyExportCSV), phrasebookCallback(phraseExportAction, exportCSV)),
+		tgbotapi.NewInlineKeyboardButtonData(key.GetKey(lang, key.KeyExportText), phrasebookCallback(phraseExportAction, exportText)),
+	))
+
+	return title(key.GetMenuMessage(lang, key.PhrasebookMessage)), tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// phrasebookList renders one page of the user's phrases for a language pair.
+
+func phrasebookList(userID int, lang key.Language, source, target string, page int) (richtext.Message, tgbotapi.InlineKeyboardMarkup) {
+
+	if page < 0 {
+		page = 0
+	}
+	phrases, total, err := storange.GetPhrases(userID, source, target, page*phrasebookPageSize, phrasebookPageSize)
+	if err != nil {
+		log.Println(err)
+	}
+
+	pages := (total + phrasebookPageSize - 1) / phrasebookPageSize
+	if total == 0 {
+		// The last phrase of the pair was deleted
+		return phrasebookOverview(userID, lang)
+	}
+	if len(phrases) == 0 && page > 0 {
+		page = pages - 1
+		phrases, _, err = storange.GetPhrases(userID, source, target, page*phrasebookPageSize, phrasebookPageSize)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+
+	text := newText().Bold(key.Format(lang, key.PhrasebookPairMessage, key.Params{"pair": pairLabel(source, target), "page": page + 1, "pages": pages}))
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	var deleteRow []tgbotapi.InlineKeyboardButton
+
+	for i, phrase := range phrases {
+		number := page*phrasebookPageSize + i + 1
+		text.Line().Line().Bold(formatNumber(lang, number) + ".").Line().
+			Quote(bidi.Isolate(truncateRunes(phrase.SourceText, historyPreviewRunes))).
+			Text("➜ " + bidi.Isolate(truncateRunes(phrase.TranslatedText, historyPreviewRunes)))
+
+		deleteRow = append(deleteRow, tgbotapi.NewInlineKeyboardButtonData("🗑 "+formatNumber(lang, number),
+			phrasebookCallback(phraseDeleteAction, phrase.ID, source, target, page)))
+	}
+	rows = append(rows, deleteRow)
+
+	if pages > 1 {
+		var nav []tgbotapi.InlineKeyboardButton
+		if page > 0 {
+			nav = append(nav, tgbotapi.NewInlineKeyboardButtonData(key.GetKey(lang, key.KeyPreviousPage),
+				phrasebookCallback(phraseListAction, source, target, page-1)))
+		}
+		nav = append(nav, tgbotapi.NewInlineKeyboardButtonData(
+			pageLabel(lang, page, pages), callbackData(string(key.NoopHandler))))
+		if page < pages-1 {
+			nav = append(nav, tgbotapi.NewInlineKeyboardButtonData(key.GetKey(lang, key.KeyNextPage),
+				phrasebookCallback(phraseListAction, source, target, page+1)))
+		}
+		rows = append(rows, nav)
+	}
+
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(key.GetKey(lang, key.KeyBack), phrasebookCallback(phraseOverviewAction)),
+	))
+
+	return text.Message(), tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// exportPhrasebook renders the whole phrasebook in the given format and returns the file name and content.
+
+func exportPhrasebook(phrases []storange.Phrase, format string) (string, []byte, error) {
+
+	var buf bytes.Buffer
+
+	if format == exportCSV {
+		// The byte order mark lets spreadsheet applications detect UTF-8 and show Persian text correctly
+		buf.WriteString("\ufeff")
+		w := csv.NewWriter(&buf)
+		if err := w.Write([]string{"source_language", "target_language", "source_text", "translated_text", "saved_at"}); err != nil {
+			return "", nil, err
+		}
+		for _, phrase := range phrases {
+			record := []string{phrase.SourceLanguage, phrase.TargetLanguage, phrase.SourceText,
+				phrase.TranslatedText, phrase.CreatedAt.UTC().Format(time.RFC3339)}
+			if err := w.Write(record); err != nil {
+				return "", nil, err
+			}
+		}
+		w.Flush()
+		return "phrasebook.csv", buf.Bytes(), w.Error()
+	}
+
+	var current storange.LanguagePair
+	for _, phrase := range phrases {
+		pair := storange.LanguagePair{Source: phrase.SourceLanguage, Target: phrase.TargetLanguage}
+		if pair != current {
+			if buf.Len() > 0 {
+				buf.WriteString("\n")
+			}
+			fmt.Fprintf(&buf, "%s\n%s\n\n", pairLabel(pair.Source, pair.Target), strings.Repeat("=", 20))
+			current = pair
+		}
+		fmt.Fprintf(&buf, "%s\n➜ %s\n\n", phrase.SourceText, phrase.TranslatedText)
+	}
+	return "phrasebook.txt", buf.Bytes(), nil
+}
+
+// PhrasebookCommandHandler handles the /phrasebook command.
+
+type PhrasebookCommandHandler struct {
+	bot *Bot
+}
+
+// Handle sends the language pairs of the user's phrasebook.
+
+func (h *PhrasebookCommandHandler) Handle(ctx *Context) {
+
+	text, keyboard := phrasebookOverview(ctx.UserID, ctx.Lang)
+	message := tgbotapi.NewMessage(ctx.ChatID, "")
+	if len(keyboard.InlineKeyboard) > 0 {
+		message.ReplyMarkup = keyboard
+	}
+	h.bot.sendText(message, text)
+}
+
+// PhrasebookHandler handles the save buttons of translation replies and the phrasebook buttons.
+
+type PhrasebookHandler struct {
+	bot *Bot
+}
+
+// Handle performs the phrasebook action carried in the callback data.
+
+func (h *PhrasebookHandler) Handle(ctx *Context, args cbdata.Args) {
+
+	if len(args) == 0 {
+		log.Printf("phrasebook callback without action: %s", ctx.Callback.Data)
+		return
+	}
+	userID := ctx.UserID
+
+	switch args[0] {
+	case phraseSaveAction:
+		reply := ctx.Callback.Message
+		if len(args) < 3 || !validPair(args[1], args[2]) || reply.ReplyToMessage == nil || reply.ReplyToMessage.Text == "" {
+			log.Printf("phrase can't be saved from message %d: %s", reply.MessageID, ctx.Callback.Data)
+			return
+		}
+
+		err := storange.SavePhrase(storange.Phrase{
+			UserID:         userID,
+			SourceText:     reply.ReplyToMessage.Text,
+			TranslatedText: replyTranslation(reply, args[2]),
+			SourceLanguage: args[1],
+			TargetLanguage: args[2],
+			CreatedAt:      time.Now(),
+		})
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
+		keyboard := markButton(reply.ReplyMarkup, ctx.Callback.Data, key.GetKey(ctx.Lang, key.KeyPhraseSaved))
+		h.bot.editKeyboard(ctx.ChatID, reply.MessageID, keyboard)
+		h.bot.answerCallback(ctx.Callback, key.GetKey(ctx.Lang, key.KeyPhraseSaved), false)
+
+	case phraseOverviewAction:
+		text, keyboard := phrasebookOverview(userID, ctx.Lang)
+		h.bot.editCallbackMessage(ctx.Callback, text, keyboard)
+
+	case phraseListAction:
+		if len(args) < 3 || !validPair(args[1], args[2]) {
+			log.Printf("invalid phrasebook pair: %s", ctx.Callback.Data)
+			return
+		}
+		text, keyboard := phrasebookList(userID, ctx.Lang, args[1], args[2], pageArg(args, 3))
+		h.bot.editCallbackMessage(ctx.Callback, text, keyboard)
+
+	case phraseDeleteAction:
+		if len(args) < 4 || !validPair(args[2], args[3]) {
+			log.Printf("invalid phrasebook delete callback: %s", ctx.Callback.Data)
+			return
+		}
+		id, err := args.Int64(1)
+		if err != nil {
+			log.Printf("invalid phrase id: %s", ctx.Callback.Data)
+			return
+		}
+		if err := storange.DeletePhrase(userID, id); err != nil {
+			log.Println(err)
+		}
+		text, keyboard := phrasebookList(userID, ctx.Lang, args[2], args[3], pageArg(args, 4))
+		h.bot.editCallbackMessage(ctx.Callback, text, keyboard)
+
+	case phraseExportAction:
+		format := exportText
+		if args.String(1) == exportCSV {
+			format = exportCSV
+		}
+
+		phrases, err := storange.GetAllPhrases(userID)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		name, content, err := exportPhrasebook(phrases, format)
+		if err != nil {
+			log.Printf("error exporting phrasebook: %v", err)
+			return
+		}
+
+		document := tgbotapi.NewDocument(ctx.ChatID, tgbotapi.FileBytes{Name: name, Bytes: content})
+		if _, err := h.bot.API.Send(document); err != nil {
+			log.Printf("error sending phrasebook export: %v", err)
+		}
+
+	default:
+		log.Printf("unknown phrasebook action: %s", ctx.Callback.Data)
+	}
+}
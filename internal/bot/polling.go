@@ -0,0 +1,155 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/mzfarshad/tlg_bot/internal/storange"
+)
+
+// pollingRetryDelay is the time waited after a failed getUpdates request before trying again.
+const pollingRetryDelay = 3 * time.Second
+
+// PollingConfig configures how the bot gets its updates by long polling.
+
+type PollingConfig struct {
+	Offset         int      // Identifier of the first update to get; 0 resumes after the last update handled, -1 starts at the newest pending update
+	Timeout        int      // Seconds a getUpdates request waits for an update before returning empty
+	AllowedUpdates []string // Kinds of updates to get, such as "message"; empty for the kinds Telegram sends by default
+}
+
+// polledUpdate is an update returned by getUpdates, kept raw to be handled like a webhook update.
+
+type polledUpdate struct {
+	ID  int
+	Raw json.RawMessage
+}
+
+// Poll gets the updates with getUpdates and queues them in the workers, like webhook updates, until ctx is done.
+// A full queue holds polling back whatever the overflow policy, and the offset isn't moved past an update
+// the workers refuse, so no update is lost. The webhook is removed first,
+// because Telegram refuses getUpdates while one is set, and the offset is saved after every batch,
+// so a restart resumes after the last queued update.
+
+func (b *Bot) Poll(ctx context.Context, config PollingConfig) error {
+
+	if _, err := b.API.Request(tgbotapi.DeleteWebhookConfig{}); err != nil {
+		return fmt.Errorf("error removing webhook before polling: %v", err)
+	}
+
+	offset := config.Offset
+	if offset == 0 {
+		saved, err := storange.GetUpdateOffset()
+		if err != nil {
+			return err
+		}
+		offset = saved
+	}
+	log.Printf("polling for updates from offset %d", offset)
+
+	for {
+		updates, err := b.getUpdates(ctx, tgbotapi.UpdateConfig{
+			Offset:         offset,
+			Timeout:        config.Timeout,
+			AllowedUpdates: config.AllowedUpdates,
+		})
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			log.Printf("error getting updates: %v", err)
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(pollingRetryDelay):
+			}
+			continue
+		}
+
+		next, done := b.enqueueUpdates(updates, offset)
+		if next != offset {
+			offset = next
+			if err := storange.SaveUpdateOffset(offset); err != nil {
+				log.Println(err)
+			}
+		}
+		if !done {
+			// The workers refused an update, which is fetched again after a pause
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(pollingRetryDelay):
+			}
+		}
+	}
+}
+
+// enqueueUpdates queues the updates in order and returns the offset after the last queued one.
+// It stops at an update the workers refuse, reporting false, so the offset stays on it and it's fetched again.
+// Updates that can't be decoded are skipped, as they would fail again.
+
+func (b *Bot) enqueueUpdates(updates []polledUpdate, offset int) (int, bool) {
+	for _, update := range updates {
+		err := b.enqueueJSON(update.Raw, true)
+		if refused(err) {
+			log.Printf("update %d refused, polling it again: %v", update.ID, err)
+			return offset, false
+		}
+		if err != nil {
+			log.Println(err)
+		}
+		if update.ID >= offset {
+			offset = update.ID + 1
+		}
+	}
+	return offset, true
+}
+
+// getUpdates requests the next updates. It returns when ctx is done without waiting for the request,
+// which can be held open by Telegram for the whole polling timeout.
+
+func (b *Bot) getUpdates(ctx context.Context, config tgbotapi.UpdateConfig) ([]polledUpdate, error) {
+
+	type result struct {
+		updates []polledUpdate
+		err     error
+	}
+	done := make(chan result, 1)
+
+	go func() {
+		response, err := b.API.Request(config)
+		if err != nil {
+			done <- result{err: err}
+			return
+		}
+
+		var raw []json.RawMessage
+		if err := json.Unmarshal(response.Result, &raw); err != nil {
+			done <- result{err: fmt.Errorf("error parsing updates: %v", err)}
+			return
+		}
+		updates := make([]polledUpdate, 0, len(raw))
+		for _, body := range raw {
+			var header struct {
+				UpdateID int `json:"update_id"`
+			}
+			if err := json.Unmarshal(body, &header); err != nil {
+				done <- result{err: fmt.Errorf("error parsing update id: %v", err)}
+				return
+			}
+			updates = append(updates, polledUpdate{ID: header.UpdateID, Raw: body})
+		}
+		done <- result{updates: updates}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case r := <-done:
+		return r.updates, r.err
+	}
+}
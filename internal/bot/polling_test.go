@@ -0,0 +1,61 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// rawMessageUpdate returns a raw message update with the ID.
+
+func rawMessageUpdate(id int) polledUpdate {
+	raw := fmt.Sprintf(`{"update_id":%d,"message":{"message_id":%d,"text":"hi","chat":{"id":1}}}`, id, id)
+	return polledUpdate{ID: id, Raw: []byte(raw)}
+}
+
+// stoppedBot returns a bot whose workers were stopped, so every update is refused with ErrQueueClosed.
+
+func stoppedBot(t *testing.T) *Bot {
+	b := &Bot{Metrics: newMetrics(), updates: newDedup(10)}
+	b.StartWorkers(WorkerConfig{Workers: 1, QueueSize: 1})
+	if err := b.StopWorkers(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestRefusedUpdateIsForgotten(t *testing.T) {
+	b := stoppedBot(t)
+
+	body := rawMessageUpdate(5).Raw
+	if err := b.EnqueueUpdateJSON(body); !errors.Is(err, ErrQueueClosed) {
+		t.Fatalf("EnqueueUpdateJSON = %v, want ErrQueueClosed", err)
+	}
+	// The refused update is delivered again and must not be dropped as a duplicate
+	if !b.updates.first(5) {
+		t.Error("update refused by closed workers is still remembered")
+	}
+}
+
+func TestPollingKeepsOffsetOfRefusedUpdate(t *testing.T) {
+	b := stoppedBot(t)
+
+	offset, done := b.enqueueUpdates([]polledUpdate{rawMessageUpdate(10), rawMessageUpdate(11)}, 10)
+	if done || offset != 10 {
+		t.Errorf("enqueueUpdates with closed workers = %d, %t, want 10, false", offset, done)
+	}
+	if !b.updates.first(10) {
+		t.Error("refused update is still remembered")
+	}
+
+	// Updates that can't be decoded are skipped, queued ones move the offset
+	b = &Bot{Metrics: newMetrics(), updates: newDedup(10)}
+	handled := 0
+	b.dispatch = func(ctx *Context) { handled++ }
+	broken := polledUpdate{ID: 20, Raw: []byte(`{"update_id":"x"}`)}
+	offset, done = b.enqueueUpdates([]polledUpdate{broken, rawMessageUpdate(21)}, 20)
+	if !done || offset != 22 || handled != 1 {
+		t.Errorf("enqueueUpdates = %d, %t with %d handled, want 22, true with 1", offset, done, handled)
+	}
+}
@@ -0,0 +1,143 @@
+package bot
+
+import (
+	"fmt"
+	"log"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/mzfarshad/tlg_bot/internal/bidi"
+	"github.com/mzfarshad/tlg_bot/internal/cbdata"
+	"github.com/mzfarshad/tlg_bot/internal/key"
+	"github.com/mzfarshad/tlg_bot/internal/setting"
+	"github.com/mzfarshad/tlg_bot/internal/storange"
+	"github.com/mzfarshad/tlg_bot/internal/translation"
+)
+
+// Places recent pair buttons are shown in, carried in the callback data ("recent|<origin>|<source>|<target>")
+const (
+	recentFromMenu    = "m" // the translation menu
+	recentFromCommand = "c" // the /pairs command
+)
+
+// recentCallback builds the callback data for a recent pair button.
+
+func recentCallback(origin, source, target string) string {
+	return callbackData(string(key.RecentPairsHandler), origin, source, target)
+}
+
+// pairLabel returns the compact label of a language pair, such as "fa → en", isolated so the arrow
+// keeps pointing from the source to the target in right-to-left text.
+
+func pairLabel(source, target string) string {
+	return bidi.Isolate(fmt.Sprintf("%s → %s", source, target))
+}
+
+// rememberPair records the language pair in the user's recent pairs.
+
+func rememberPair(userID int, source, target string) {
+	if err := storange.SaveRecentPair(userID, source, target); err != nil {
+		log.Println(err)
+	}
+}
+
+// recentPairsRows creates one row per recent language pair of the target's user: a button activating the pair
+// and, when the pair can be reversed, a button activating the swapped pair.
+// The pair in effect for the target is marked with a check mark.
+
+func recentPairsRows(target setting.Target, origin string) [][]tgbotapi.InlineKeyboardButton {
+
+	pairs, err := storange.GetRecentPairs(target.UserID)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+
+	var active storange.LanguagePair
+	if current, err := setting.GetTranslationSetting(target); err == nil && current.ActiveTranslation {
+		active = storange.LanguagePair{Source: current.SourceLanguage, Target: current.TargetLanguage}
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, pair := range pairs {
+		label := pairLabel(pair.Source, pair.Target)
+		if pair == active {
+			label = "✅ " + label
+		}
+		row := tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(label, recentCallback(origin, pair.Source, pair.Target)),
+		)
+
+		if pair.Source != translation.AutoDetect {
+			swapLabel := "⇄ " + pairLabel(pair.Target, pair.Source)
+			if (storange.LanguagePair{Source: pair.Target, Target: pair.Source}) == active {
+				swapLabel = "✅ " + swapLabel
+			}
+			row = append(row, tgbotapi.NewInlineKeyboardButtonData(swapLabel,
+				recentCallback(origin, pair.Target, pair.Source)))
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// PairsCommandHandler handles the /pairs command.
+
+type PairsCommandHandler struct {
+	bot *Bot
+}
+
+// Handle sends the user's recent language pairs as one-tap buttons.
+
+func (h *PairsCommandHandler) Handle(ctx *Context) {
+
+	rows := recentPairsRows(ctx.settings(), recentFromCommand)
+	if len(rows) == 0 {
+		h.bot.API.Send(tgbotapi.NewMessage(ctx.ChatID, key.GetMenuMessage(ctx.Lang, key.NoRecentPairsMessage)))
+		return
+	}
+
+	message := tgbotapi.NewMessage(ctx.ChatID, key.GetMenuMessage(ctx.Lang, key.RecentPairsMessage))
+	message.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	h.bot.API.Send(message)
+}
+
+// RecentPairsHandler handles taps on recent language pair buttons.
+
+type RecentPairsHandler struct {
+	bot *Bot
+}
+
+// Handle activates the tapped language pair and refreshes the keyboard so the check mark moves to it,
+// or tells the user the pair of the chat or forum topic overrides it.
+
+func (h *RecentPairsHandler) Handle(ctx *Context, args cbdata.Args) {
+
+	if len(args) != 3 || !validPair(args[1], args[2]) {
+		log.Printf("invalid recent pair callback: %s", ctx.Callback.Data)
+		return
+	}
+	origin, source, target := args[0], args[1], args[2]
+	userID := ctx.UserID
+
+	if err := setting.ActivateLanguagePairs(setting.User, ctx.settings(), source, target); err != nil {
+		log.Println(err)
+		return
+	}
+	rememberPair(userID, source, target)
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(recentPairsRows(ctx.settings(), recentFromCommand)...)
+	if origin == recentFromMenu {
+		view, ok := h.bot.MenuManager.renderMenu(ctx.settings(), string(key.MenuTranslation), ctx.Lang)
+		if !ok {
+			return
+		}
+		keyboard = view.Keyboard
+	}
+
+	h.bot.editKeyboard(ctx.ChatID, ctx.Callback.Message.MessageID, keyboard)
+	if text := pairOverriddenText(ctx); text != "" {
+		h.bot.answerCallback(ctx.Callback, text, true)
+		return
+	}
+	h.bot.answerCallback(ctx.Callback, key.Format(ctx.Lang, key.PairActivatedMessage, key.Params{"pair": pairLabel(source, target)}), false)
+}
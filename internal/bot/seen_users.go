@@ -0,0 +1,67 @@
+package bot
+
+import (
+	"sync"
+	"time"
+)
+
+// Limits of the cache of users whose language was inferred
+const (
+	seenUsersTTL  = time.Hour // Time after which the language of a user is inferred again
+	seenUsersSize = 10000     // Number of users kept at most
+)
+
+// seenUsers remembers the users whose interface language was inferred recently, with the language code
+// of their Telegram client, so the language is inferred once per user instead of on every update.
+// The cache is bounded: entries expire after seenUsersTTL, and a full cache forgets everyone.
+
+type seenUsers struct {
+	mu    sync.Mutex
+	users map[int]seenUser
+	now   func() time.Time
+}
+
+// seenUser is when a user was seen and the language code their client reported.
+
+type seenUser struct {
+	languageCode string
+	seen         time.Time
+}
+
+// newSeenUsers creates an empty cache.
+
+func newSeenUsers() *seenUsers {
+	return &seenUsers{users: make(map[int]seenUser), now: time.Now}
+}
+
+// check records the user and reports whether their language must be inferred: the first time they're seen,
+// after their entry expired, or when their client reports another language code.
+
+func (s *seenUsers) check(userID int, languageCode string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	user, exist := s.users[userID]
+	if exist && user.languageCode == languageCode && now.Sub(user.seen) < seenUsersTTL {
+		return false
+	}
+	if !exist && len(s.users) >= seenUsersSize {
+		s.cleanup(now)
+	}
+	s.users[userID] = seenUser{languageCode: languageCode, seen: now}
+	return true
+}
+
+// cleanup forgets the expired users, or everyone if none expired. The lock must be held.
+
+func (s *seenUsers) cleanup(now time.Time) {
+	for userID, user := range s.users {
+		if now.Sub(user.seen) >= seenUsersTTL {
+			delete(s.users, userID)
+		}
+	}
+	if len(s.users) >= seenUsersSize {
+		s.users = make(map[int]seenUser)
+	}
+}
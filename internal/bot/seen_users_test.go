@@ -0,0 +1,49 @@
+package bot
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSeenUsers(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	s := newSeenUsers()
+	s.now = func() time.Time { return now }
+
+	if !s.check(1, "fa") {
+		t.Error("new user isn't inferred")
+	}
+	if s.check(1, "fa") {
+		t.Error("user seen again is inferred again")
+	}
+	if !s.check(1, "en") {
+		t.Error("user whose client changed language isn't inferred again")
+	}
+	now = now.Add(seenUsersTTL)
+	if !s.check(1, "en") {
+		t.Error("expired user isn't inferred again")
+	}
+}
+
+func TestSeenUsersBounded(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	s := newSeenUsers()
+	s.now = func() time.Time { return now }
+
+	for id := 0; id < 3*seenUsersSize; id++ {
+		s.check(id, "fa")
+		if id%seenUsersSize == 0 {
+			now = now.Add(time.Minute)
+		}
+	}
+	if len(s.users) > seenUsersSize {
+		t.Errorf("cache holds %d users, limit %d", len(s.users), seenUsersSize)
+	}
+
+	// Expired users are forgotten first
+	now = now.Add(seenUsersTTL)
+	s.check(-1, "fa")
+	if len(s.users) != 1 {
+		t.Errorf("cache holds %d users after the others expired", len(s.users))
+	}
+}
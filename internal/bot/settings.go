@@ -0,0 +1,204 @@
+package bot
+
+import (
+	"log"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/mzfarshad/tlg_bot/internal/cbdata"
+	"github.com/mzfarshad/tlg_bot/internal/key"
+	"github.com/mzfarshad/tlg_bot/internal/richtext"
+	"github.com/mzfarshad/tlg_bot/internal/setting"
+)
+
+// scopeNames are the message keys naming the setting scopes.
+var scopeNames = map[setting.Scope]key.TextMessage{
+	setting.Default: key.DefaultScopeName,
+	setting.Global:  key.GlobalScopeName,
+	setting.User:    key.UserScopeName,
+	setting.Chat:    key.ChatScopeName,
+	setting.Topic:   key.TopicScopeName,
+}
+
+// scopeName returns the name of a setting scope in the given language.
+
+func scopeName(lang key.Language, scope setting.Scope) string {
+	return key.GetMenuMessage(lang, scopeNames[scope])
+}
+
+// pairOverriddenText returns the text telling the user that the language pair of the chat or forum topic
+// overrides their own pair in the context, or "" when their own pair applies.
+
+func pairOverriddenText(ctx *Context) string {
+
+	current, err := setting.GetTranslationSetting(ctx.settings())
+	if err != nil {
+		log.Println(err)
+		return ""
+	}
+	scope := current.Sources[setting.SourceLanguageSetting]
+	if scope != setting.Chat && scope != setting.Topic {
+		return ""
+	}
+	return key.Format(ctx.Lang, key.PairOverriddenMessage, key.Params{
+		"scope": scopeName(ctx.Lang, scope),
+		"pair":  pairLabel(current.SourceLanguage, current.TargetLanguage),
+	})
+}
+
+// settingSourcesView renders the effective settings of the context and the scope each one comes from,
+// with a button back to the settings menu.
+
+func settingSourcesView(ctx *Context) (richtext.Message, tgbotapi.InlineKeyboardMarkup) {
+
+	target := ctx.settings()
+	label := func(message key.TextMessage) string { return key.GetMenuMessage(ctx.Lang, message) }
+
+	lang, langScope, err := setting.BotLanguage(target)
+	if err != nil {
+		log.Println(err)
+	}
+	langSource := scopeName(ctx.Lang, langScope)
+	if langScope == setting.User {
+		if inferred, err := setting.LanguageInferred(ctx.UserID); err != nil {
+			log.Println(err)
+		} else if inferred {
+			langSource = label(key.InferredScopeName)
+		}
+	}
+
+	translationSetting, err := setting.GetTranslationSetting(target)
+	if err != nil {
+		log.Println(err)
+		translationSetting = &setting.TranslationSetting{}
+	}
+	pair := label(key.SettingNotSetLabel)
+	if translationSetting.SourceLanguage != "" && translationSetting.TargetLanguage != "" {
+		pair = pairLabel(translationSetting.SourceLanguage, translationSetting.TargetLanguage)
+	}
+	active := label(key.SettingOffLabel)
+	if translationSetting.ActiveTranslation {
+		active = label(key.SettingOnLabel)
+	}
+
+	text := newText().Bold(label(key.SettingSourcesMessage)).Line()
+	text.Line().Bold(label(key.BotLanguageLabel) + ":").Text(" " + key.LanguageName(lang) + " — " + langSource)
+	text.Line().Bold(label(key.LanguagePairLabel) + ":").Text(" " + pair + " — " +
+		scopeName(ctx.Lang, translationSetting.Sources[setting.SourceLanguageSetting]))
+	text.Line().Bold(label(key.TranslationLabel) + ":").Text(" " + active + " — " +
+		scopeName(ctx.Lang, translationSetting.Sources[setting.ActiveTranslationSetting]))
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(key.GetKey(ctx.Lang, key.KeyBack),
+			callbackData(string(key.MenuHandler), string(key.MenuSetting))),
+	))
+	return text.Message(), keyboard
+}
+
+// SettingSourcesHandler handles the button of the settings menu showing where the settings come from.
+
+type SettingSourcesHandler struct {
+	bot *Bot
+}
+
+// Handle shows the effective settings in the message of the settings menu.
+
+func (h *SettingSourcesHandler) Handle(ctx *Context, args cbdata.Args) {
+	text, keyboard := settingSourcesView(ctx)
+	h.bot.editCallbackMessage(ctx.Callback, text, keyboard)
+}
+
+// DefaultCommandHandler handles the /default command setting the defaults of a chat, a forum topic or the bot.
+
+type DefaultCommandHandler struct {
+	bot *Bot
+}
+
+// Handle sets or clears a default, such as /default lang fa, /default pair fa-en or /default reset.
+// In a group it changes the defaults of the forum topic the command was sent in, or of the whole chat,
+// and is available to the chat administrators. In a private chat bot admins change the defaults of the bot.
+
+func (h *DefaultCommandHandler) Handle(ctx *Context) {
+
+	scope, allowed := h.scope(ctx)
+	if !allowed {
+		message := key.ChatAdminOnlyMessage
+		if ctx.Message.Chat.IsPrivate() {
+			message = key.AdminOnlyMessage
+		}
+		h.bot.API.Send(tgbotapi.NewMessage(ctx.ChatID, key.GetMenuMessage(ctx.Lang, message)))
+		return
+	}
+
+	name, value, _ := strings.Cut(strings.ToLower(strings.TrimSpace(ctx.Message.CommandArguments())), " ")
+	value = strings.TrimSpace(value)
+	target := ctx.settings()
+
+	var err error
+	reply := key.DefaultSavedMessage
+	switch name {
+	case "lang":
+		if !key.Supported(key.Language(value)) {
+			h.usage(ctx)
+			return
+		}
+		err = setting.SaveBotLanguage(scope, target, key.Language(value))
+
+	case "pair":
+		source, targetLang, _ := strings.Cut(value, "-")
+		if !validPair(source, targetLang) {
+			h.usage(ctx)
+			return
+		}
+		err = setting.ActivateLanguagePairs(scope, target, source, targetLang)
+
+	case "reset":
+		reply = key.DefaultClearedMessage
+		if _, err = setting.ResetTranslationSettings(scope, target); err == nil {
+			_, err = setting.Clear(scope, target, setting.BotLanguageSetting)
+		}
+
+	default:
+		h.usage(ctx)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	text := key.Format(ctx.Lang, reply, key.Params{"scope": scopeName(ctx.Lang, scope)})
+	h.bot.API.Send(tgbotapi.NewMessage(ctx.ChatID, text))
+}
+
+// scope returns the scope the command changes and reports whether the user may change it.
+
+func (h *DefaultCommandHandler) scope(ctx *Context) (setting.Scope, bool) {
+
+	if ctx.Message.Chat.IsPrivate() {
+		return setting.Global, ctx.Admin
+	}
+
+	scope := setting.Chat
+	if ctx.ThreadID != 0 {
+		scope = setting.Topic
+	}
+	if ctx.Admin {
+		return scope, true
+	}
+
+	member, err := h.bot.API.GetChatMember(tgbotapi.GetChatMemberConfig{
+		ChatConfigWithUser: tgbotapi.ChatConfigWithUser{ChatID: ctx.ChatID, UserID: int64(ctx.UserID)},
+	})
+	if err != nil {
+		log.Printf("error getting chat member %d of chat %d: %v", ctx.UserID, ctx.ChatID, err)
+		return scope, false
+	}
+	return scope, member.IsCreator() || member.IsAdministrator()
+}
+
+// usage explains the arguments of the command.
+
+func (h *DefaultCommandHandler) usage(ctx *Context) {
+	h.bot.API.Send(tgbotapi.NewMessage(ctx.ChatID, key.GetMenuMessage(ctx.Lang, key.DefaultUsageMessage)))
+}
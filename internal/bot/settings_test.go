@@ -0,0 +1,66 @@
+package bot
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/mzfarshad/tlg_bot/internal/key"
+	"github.com/mzfarshad/tlg_bot/internal/setting"
+	"github.com/mzfarshad/tlg_bot/internal/storange"
+)
+
+// useTestDB creates the database in a temporary directory for the test.
+
+func useTestDB(t *testing.T) {
+	// The database is created in the working directory
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	if err := storange.InitDB(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChatPairOverridesUserPair(t *testing.T) {
+	useTestDB(t)
+
+	group := &Context{UserID: 7, ChatID: -100, Lang: key.LangEN}
+	private := &Context{UserID: 7, ChatID: 7, Lang: key.LangEN}
+	rememberPair(7, "en", "fa")
+	rememberPair(7, "de", "fr")
+	if err := setting.ActivateLanguagePairs(setting.User, private.settings(), "en", "fa"); err != nil {
+		t.Fatal(err)
+	}
+	if err := setting.ActivateLanguagePairs(setting.Chat, group.settings(), "de", "fr"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The check mark shows the pair in effect where the keyboard is shown
+	checked := func(ctx *Context) string {
+		for _, row := range recentPairsRows(ctx.settings(), recentFromCommand) {
+			if strings.HasPrefix(row[0].Text, "✅") {
+				return row[0].Text
+			}
+		}
+		return ""
+	}
+	if label := checked(private); !strings.Contains(label, "en → fa") {
+		t.Errorf("active pair in the private chat = %q, want en → fa", label)
+	}
+	if label := checked(group); !strings.Contains(label, "de → fr") {
+		t.Errorf("active pair in the group = %q, want de → fr", label)
+	}
+
+	if text := pairOverriddenText(private); text != "" {
+		t.Errorf("user's own pair reported as overridden: %q", text)
+	}
+	if text := pairOverriddenText(group); !strings.Contains(text, "de → fr") {
+		t.Errorf("pairOverriddenText in the group = %q, want it to name the chat's pair", text)
+	}
+}
@@ -0,0 +1,77 @@
+package bot
+
+import (
+	"sync"
+	"time"
+)
+
+// Default limits of the per-user throttle
+const (
+	throttleRate  = 2  // Updates per second a user can keep sending
+	throttleBurst = 10 // Updates a user can send at once after a pause
+)
+
+// throttleCleanupSize is the number of tracked users above which idle users are forgotten.
+const throttleCleanupSize = 10000
+
+// throttle limits the number of updates each user can send with a token bucket per user.
+
+type throttle struct {
+	mu      sync.Mutex
+	rate    float64 // Tokens added per second
+	burst   float64 // Size of the bucket
+	buckets map[int]*bucket
+	now     func() time.Time
+}
+
+// bucket holds the tokens of one user.
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// newThrottle creates a throttle allowing rate updates per second with bursts of burst updates.
+
+func newThrottle(rate, burst float64) *throttle {
+	return &throttle{rate: rate, burst: burst, buckets: make(map[int]*bucket), now: time.Now}
+}
+
+// allow takes a token from the user's bucket. It reports false if the bucket is empty.
+
+func (t *throttle) allow(userID int) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	b, exist := t.buckets[userID]
+	if !exist {
+		if len(t.buckets) >= throttleCleanupSize {
+			t.cleanup(now)
+		}
+		b = &bucket{tokens: t.burst, last: now}
+		t.buckets[userID] = b
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * t.rate
+	if b.tokens > t.burst {
+		b.tokens = t.burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// cleanup forgets the users whose buckets are full again, as they behave like new users. The lock must be held.
+
+func (t *throttle) cleanup(now time.Time) {
+	for userID, b := range t.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*t.rate >= t.burst {
+			delete(t.buckets, userID)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/mzfarshad/tlg_bot/internal/key"
+)
+
+// menuButton is the menu button of a chat opening a Mini App, as setChatMenuButton expects it.
+// The Telegram library doesn't support the method, so it is called directly.
+
+type menuButton struct {
+	Type   string `json:"type"`
+	Text   string `json:"text"`
+	WebApp struct {
+		URL string `json:"url"`
+	} `json:"web_app"`
+}
+
+// SetWebAppMenuButton sets the default menu button of private chats with the bot to open the Mini App at url.
+
+func (b *Bot) SetWebAppMenuButton(url string) error {
+
+	button := menuButton{Type: "web_app", Text: key.GetKey(key.LangEN, key.KeyWebApp)}
+	button.WebApp.URL = url
+	data, err := json.Marshal(button)
+	if err != nil {
+		return err
+	}
+
+	params := tgbotapi.Params{}
+	params["menu_button"] = string(data)
+	if _, err := b.API.MakeRequest("setChatMenuButton", params); err != nil {
+		return fmt.Errorf("error setting menu button: %w", err)
+	}
+	log.Printf("menu button opens %s", url)
+	return nil
+}
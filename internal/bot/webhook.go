@@ -0,0 +1,117 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// WebhookConfig is the webhook Telegram posts the updates to.
+// The Telegram library doesn't support the secret token, so setWebhook is called directly.
+
+type WebhookConfig struct {
+	URL            string   // Public HTTPS address of the webhook route
+	SecretToken    string   // Token Telegram sends in the X-Telegram-Bot-Api-Secret-Token header; empty for none
+	AllowedUpdates []string // Kinds of updates to receive; empty for the kinds Telegram sends by default
+	MaxConnections int      // Maximum number of simultaneous connections, 1-100; 0 for Telegram's default of 40
+}
+
+// SetWebhook registers the webhook, replacing the one set before.
+
+func (b *Bot) SetWebhook(config WebhookConfig) error {
+
+	params := tgbotapi.Params{}
+	params["url"] = config.URL
+	params.AddNonEmpty("secret_token", config.SecretToken)
+	params.AddNonZero("max_connections", config.MaxConnections)
+	if err := params.AddInterface("allowed_updates", config.AllowedUpdates); err != nil {
+		return err
+	}
+
+	if _, err := b.API.MakeRequest("setWebhook", params); err != nil {
+		return fmt.Errorf("error setting webhook: %w", err)
+	}
+	log.Printf("webhook set to %s", config.URL)
+	return nil
+}
+
+// DeleteWebhook removes the webhook, keeping the pending updates for the next start.
+
+func (b *Bot) DeleteWebhook() error {
+	if _, err := b.API.Request(tgbotapi.DeleteWebhookConfig{}); err != nil {
+		return fmt.Errorf("error deleting webhook: %w", err)
+	}
+	log.Println("webhook deleted")
+	return nil
+}
+
+// WebhookInfo is the state of the webhook as getWebhookInfo reports it.
+
+type WebhookInfo struct {
+	URL                          string   `json:"url"`
+	HasCustomCertificate         bool     `json:"has_custom_certificate"`
+	PendingUpdateCount           int      `json:"pending_update_count"`
+	IPAddress                    string   `json:"ip_address"`
+	LastErrorDate                int64    `json:"last_error_date"`
+	LastErrorMessage             string   `json:"last_error_message"`
+	LastSynchronizationErrorDate int64    `json:"last_synchronization_error_date"`
+	MaxConnections               int      `json:"max_connections"`
+	AllowedUpdates               []string `json:"allowed_updates"`
+}
+
+// GetWebhookInfo returns the state of the webhook of the bot.
+// It only needs the API, so it can run without starting the bot.
+
+func GetWebhookInfo(api *tgbotapi.BotAPI) (WebhookInfo, error) {
+
+	response, err := api.MakeRequest("getWebhookInfo", nil)
+	if err != nil {
+		return WebhookInfo{}, fmt.Errorf("error getting webhook info: %w", err)
+	}
+	var info WebhookInfo
+	if err := json.Unmarshal(response.Result, &info); err != nil {
+		return WebhookInfo{}, fmt.Errorf("error parsing webhook info: %w", err)
+	}
+	return info, nil
+}
+
+// Print writes the webhook state to w, one field per line.
+
+func (info WebhookInfo) Print(w io.Writer) {
+
+	if info.URL == "" {
+		fmt.Fprintln(w, "url:                  (not set, the bot can use polling)")
+	} else {
+		fmt.Fprintf(w, "url:                  %s\n", info.URL)
+	}
+	fmt.Fprintf(w, "pending updates:      %d\n", info.PendingUpdateCount)
+	if info.MaxConnections > 0 {
+		fmt.Fprintf(w, "max connections:      %d\n", info.MaxConnections)
+	}
+	allowed := "default"
+	if len(info.AllowedUpdates) > 0 {
+		allowed = strings.Join(info.AllowedUpdates, ", ")
+	}
+	fmt.Fprintf(w, "allowed updates:      %s\n", allowed)
+	if info.IPAddress != "" {
+		fmt.Fprintf(w, "ip address:           %s\n", info.IPAddress)
+	}
+	fmt.Fprintf(w, "custom certificate:   %t\n", info.HasCustomCertificate)
+	if info.LastErrorDate != 0 {
+		fmt.Fprintf(w, "last error:           %s: %s\n", unixTime(info.LastErrorDate), info.LastErrorMessage)
+	}
+	if info.LastSynchronizationErrorDate != 0 {
+		fmt.Fprintf(w, "last sync error:      %s\n", unixTime(info.LastSynchronizationErrorDate))
+	}
+}
+
+// unixTime formats a Unix time of the Bot API.
+
+func unixTime(seconds int64) string {
+	return time.Unix(seconds, 0).UTC().Format(time.RFC3339)
+}
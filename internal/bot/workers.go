@@ -0,0 +1,257 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Overflow policies, deciding what happens to an update whose queue is full
+const (
+	OverflowBlock      = "block"       // Wait for room in the queue, holding the webhook request meanwhile
+	OverflowDropNewest = "drop-newest" // Acknowledge the new update and drop it
+	OverflowDropOldest = "drop-oldest" // Drop the oldest update waiting in the queue to make room for the new one
+	OverflowReject     = "reject"      // Refuse the update, so the webhook answers 503 and Telegram delivers it again later
+)
+
+var (
+	// ErrQueueFull is returned for an update refused because its queue is full.
+	ErrQueueFull = errors.New("update queue is full")
+
+	// ErrQueueClosed is returned for an update passed after the workers were stopped.
+	ErrQueueClosed = errors.New("update queue is closed")
+)
+
+// Default size of the worker pool
+const (
+	defaultWorkers   = 8
+	defaultQueueSize = 64
+)
+
+// WorkerConfig configures the pool of workers handling the updates.
+
+type WorkerConfig struct {
+	Workers   int    // Number of workers; updates of the same chat always go to the same worker
+	QueueSize int    // Updates waiting for each worker at most
+	Overflow  string // Overflow policy for an update whose queue is full, such as OverflowBlock
+}
+
+// job is an update waiting in the queue of a worker.
+
+type job struct {
+	ctx    *Context
+	queued time.Time
+}
+
+// workerPool handles updates in a fixed number of workers, each with its own bounded queue.
+// The updates of a chat are sharded to one worker, so they're handled one at a time and in order,
+// while updates of different chats are handled concurrently.
+
+type workerPool struct {
+	mu       sync.RWMutex // Held for writing while the queues are closed
+	closed   bool
+	queues   []chan job
+	overflow string
+	handle   func(*Context)
+	metrics  *Metrics
+	done     sync.WaitGroup
+}
+
+// newWorkerPool starts the workers handling the updates with handle.
+
+func newWorkerPool(config WorkerConfig, handle func(*Context), metrics *Metrics) *workerPool {
+
+	if config.Workers <= 0 {
+		config.Workers = defaultWorkers
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = defaultQueueSize
+	}
+	if config.Overflow == "" {
+		config.Overflow = OverflowBlock
+	}
+
+	p := &workerPool{
+		queues:   make([]chan job, config.Workers),
+		overflow: config.Overflow,
+		handle:   handle,
+		metrics:  metrics,
+	}
+	for i := range p.queues {
+		p.queues[i] = make(chan job, config.QueueSize)
+		p.done.Add(1)
+		go p.work(p.queues[i])
+	}
+	metrics.watchQueue(config.Workers, config.Workers*config.QueueSize, p.depth)
+	return p
+}
+
+// work handles the updates of one queue until it's closed.
+
+func (p *workerPool) work(queue chan job) {
+	defer p.done.Done()
+	for j := range queue {
+		started := time.Now()
+		p.metrics.dequeued(started.Sub(j.queued))
+		// Handling time is measured from here, without the time spent in the queue
+		j.ctx.Started = started
+		p.handle(j.ctx)
+	}
+}
+
+// shard returns the queue of an update: the queue of its chat, or of its user for updates outside a chat,
+// such as inline queries.
+
+func (p *workerPool) shard(ctx *Context) chan job {
+	id := ctx.ChatID
+	if id == 0 {
+		id = int64(ctx.UserID)
+	}
+	return p.queues[uint64(id)%uint64(len(p.queues))]
+}
+
+// submit queues an update. With wait set the update waits for room in a full queue whatever the overflow policy,
+// as polling does, since Telegram doesn't deliver an acknowledged update again.
+
+func (p *workerPool) submit(ctx *Context, wait bool) error {
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return ErrQueueClosed
+	}
+
+	j := job{ctx: ctx, queued: time.Now()}
+	queue := p.shard(ctx)
+	select {
+	case queue <- j:
+		p.metrics.enqueued()
+		return nil
+	default:
+	}
+
+	switch {
+	case wait || p.overflow == OverflowBlock:
+		p.metrics.blocked()
+		queue <- j
+		p.metrics.enqueued()
+		return nil
+	case p.overflow == OverflowReject:
+		p.metrics.overflowed(true)
+		return ErrQueueFull
+	case p.overflow == OverflowDropOldest:
+		for {
+			select {
+			case queue <- j:
+				p.metrics.enqueued()
+				return nil
+			case oldest := <-queue:
+				p.metrics.overflowed(false)
+				log.Printf("update queue is full, dropping older %s update of chat %d", oldest.ctx.Kind, oldest.ctx.ChatID)
+			}
+		}
+	default:
+		p.metrics.overflowed(false)
+		log.Printf("update queue is full, dropping %s update of chat %d", ctx.Kind, ctx.ChatID)
+		return nil
+	}
+}
+
+// depth returns the number of updates waiting in all queues and in the fullest queue.
+
+func (p *workerPool) depth() (total, fullest int) {
+	for _, queue := range p.queues {
+		n := len(queue)
+		total += n
+		if n > fullest {
+			fullest = n
+		}
+	}
+	return total, fullest
+}
+
+// stop refuses new updates and waits until the queued ones are handled or ctx is done.
+
+func (p *workerPool) stop(ctx context.Context) error {
+
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		for _, queue := range p.queues {
+			close(queue)
+		}
+	}
+	p.mu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		p.done.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		total, _ := p.depth()
+		return fmt.Errorf("stopped with %d updates left in the queue: %v", total, ctx.Err())
+	}
+}
+
+// StartWorkers makes the bot handle the updates passed to EnqueueUpdateJSON in a pool of workers.
+// Without it, updates are handled in the goroutine passing them.
+
+func (b *Bot) StartWorkers(config WorkerConfig) {
+	b.workers = newWorkerPool(config, b.handle, b.Metrics)
+}
+
+// StopWorkers stops taking updates and waits for the queued ones to be handled, until ctx is done.
+// Updates passed afterwards are refused with ErrQueueClosed.
+
+func (b *Bot) StopWorkers(ctx context.Context) error {
+	if b.workers == nil {
+		return nil
+	}
+	return b.workers.stop(ctx)
+}
+
+// EnqueueUpdateJSON decodes an update as sent by Telegram and queues it in the worker of its chat,
+// returning without waiting for it to be handled. An update already processed is dropped.
+// It returns ErrQueueFull if the queue is full and the overflow policy is OverflowReject,
+// and ErrQueueClosed after StopWorkers; the update is then processed when it's delivered again.
+
+func (b *Bot) EnqueueUpdateJSON(body []byte) error {
+	return b.enqueueJSON(body, false)
+}
+
+// enqueueJSON decodes an update and queues it, waiting for room in a full queue if wait is set.
+
+func (b *Bot) enqueueJSON(body []byte, wait bool) error {
+
+	ctx, err := decodeUpdate(body)
+	if err != nil {
+		return err
+	}
+	if !b.firstDelivery(ctx) {
+		return nil
+	}
+	if b.workers == nil {
+		b.handle(ctx)
+		return nil
+	}
+	err = b.workers.submit(ctx, wait)
+	if refused(err) {
+		// Telegram delivers the refused update again, which mustn't be taken for a duplicate
+		b.updates.forget(ctx.Update.UpdateID)
+	}
+	return err
+}
+
+// refused reports whether an update wasn't queued because the queue was full or closed,
+// so it must be delivered again.
+
+func refused(err error) bool {
+	return errors.Is(err, ErrQueueFull) || errors.Is(err, ErrQueueClosed)
+}
@@ -0,0 +1,186 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWorkersKeepChatOrder(t *testing.T) {
+	const chats, updates = 20, 50
+
+	var mu sync.Mutex
+	handled := make(map[int64][]int)
+	pool := newWorkerPool(WorkerConfig{Workers: 4, QueueSize: 4}, func(ctx *Context) {
+		time.Sleep(time.Duration(rand.Intn(100)) * time.Microsecond)
+		mu.Lock()
+		handled[ctx.ChatID] = append(handled[ctx.ChatID], ctx.UserID)
+		mu.Unlock()
+	}, newMetrics())
+
+	// Every chat sends its updates in order, concurrently with the other chats
+	var senders sync.WaitGroup
+	for chat := int64(1); chat <= chats; chat++ {
+		senders.Add(1)
+		go func(chat int64) {
+			defer senders.Done()
+			for i := 0; i < updates; i++ {
+				if err := pool.submit(&Context{ChatID: -chat, UserID: i}, false); err != nil {
+					t.Error(err)
+				}
+			}
+		}(chat)
+	}
+	senders.Wait()
+	if err := pool.stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	for chat := int64(1); chat <= chats; chat++ {
+		order := handled[-chat]
+		if len(order) != updates {
+			t.Errorf("chat %d: %d updates handled, want %d", -chat, len(order), updates)
+			continue
+		}
+		for i, n := range order {
+			if n != i {
+				t.Errorf("chat %d: handled in order %v", -chat, order)
+				break
+			}
+		}
+	}
+}
+
+// stalledPool is a pool of one worker with room for one update, whose worker is busy with a first update
+// until release is closed.
+
+type stalledPool struct {
+	*workerPool
+	metrics *Metrics
+	release chan struct{}
+	resumed sync.Once
+	handled chan int
+}
+
+func newStalledPool(t *testing.T, overflow string) *stalledPool {
+	started := make(chan struct{}, 1)
+	p := &stalledPool{metrics: newMetrics(), release: make(chan struct{}), handled: make(chan int, 10)}
+	p.workerPool = newWorkerPool(WorkerConfig{Workers: 1, QueueSize: 1, Overflow: overflow}, func(ctx *Context) {
+		if ctx.UserID == 1 {
+			started <- struct{}{}
+			<-p.release
+		}
+		p.handled <- ctx.UserID
+	}, p.metrics)
+
+	if err := p.submit(&Context{ChatID: 5, UserID: 1}, false); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	if err := p.submit(&Context{ChatID: 5, UserID: 2}, false); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// resume lets the worker go on.
+
+func (p *stalledPool) resume() {
+	p.resumed.Do(func() { close(p.release) })
+}
+
+// finish lets the worker go on, stops the pool and returns the updates it handled.
+
+func (p *stalledPool) finish(t *testing.T) []int {
+	p.resume()
+	if err := p.stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	close(p.handled)
+	var handled []int
+	for n := range p.handled {
+		handled = append(handled, n)
+	}
+	return handled
+}
+
+func TestOverflowPolicies(t *testing.T) {
+	tests := []struct {
+		overflow string
+		err      error
+		handled  []int
+		stats    QueueStats
+	}{
+		{OverflowDropNewest, nil, []int{1, 2}, QueueStats{Enqueued: 2, Dropped: 1}},
+		{OverflowDropOldest, nil, []int{1, 3}, QueueStats{Enqueued: 3, Dropped: 1}},
+		{OverflowReject, ErrQueueFull, []int{1, 2}, QueueStats{Enqueued: 2, Rejected: 1}},
+	}
+	for _, test := range tests {
+		p := newStalledPool(t, test.overflow)
+		if err := p.submit(&Context{ChatID: 5, UserID: 3}, false); !errors.Is(err, test.err) {
+			t.Errorf("%s: submit to a full queue = %v, want %v", test.overflow, err, test.err)
+		}
+		stats := *p.metrics.Snapshot().Queue
+		if stats.Enqueued != test.stats.Enqueued || stats.Dropped != test.stats.Dropped ||
+			stats.Rejected != test.stats.Rejected || stats.Blocked != 0 {
+			t.Errorf("%s: queue stats = %+v", test.overflow, stats)
+		}
+		if handled := p.finish(t); !equalInts(handled, test.handled) {
+			t.Errorf("%s: handled %v, want %v", test.overflow, handled, test.handled)
+		}
+	}
+}
+
+func TestOverflowBlock(t *testing.T) {
+	p := newStalledPool(t, OverflowBlock)
+
+	submitted := make(chan error)
+	go func() {
+		submitted <- p.submit(&Context{ChatID: 5, UserID: 3}, false)
+	}()
+	select {
+	case err := <-submitted:
+		t.Fatalf("submit to a full queue returned %v without waiting", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	if stats := p.metrics.Snapshot().Queue; stats.Blocked != 1 || stats.Depth != 1 {
+		t.Errorf("queue stats = %+v", *stats)
+	}
+
+	p.resume()
+	if err := <-submitted; err != nil {
+		t.Fatal(err)
+	}
+	if handled := p.finish(t); !equalInts(handled, []int{1, 2, 3}) {
+		t.Errorf("handled %v, want [1 2 3]", handled)
+	}
+}
+
+func TestSubmitAfterStop(t *testing.T) {
+	b := &Bot{Metrics: newMetrics(), updates: newDedup(10)}
+	b.StartWorkers(WorkerConfig{Workers: 2, QueueSize: 2})
+	if err := b.StopWorkers(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.EnqueueUpdateJSON([]byte(`{"update_id":1,"message":{"message_id":1,"chat":{"id":1}}}`)); !errors.Is(err, ErrQueueClosed) {
+		t.Errorf("EnqueueUpdateJSON after StopWorkers = %v, want ErrQueueClosed", err)
+	}
+	if err := b.StopWorkers(context.Background()); err != nil {
+		t.Errorf("second StopWorkers = %v", err)
+	}
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
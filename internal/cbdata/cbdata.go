@@ -0,0 +1,230 @@
+// Package cbdata encodes and decodes the callback data carried by inline keyboard buttons.
+//
+// Encoded data has the form "<version>:<action>|<arg>|<arg>...", optionally followed by
+// "#<signature>" when a secret is set. The action names the handler that receives the button press;
+// the arguments are stored as text and read back with the typed accessors of Args.
+package cbdata
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Version is the version tag of the encoding. Data with another version is rejected by Decode,
+// so buttons of messages sent before an incompatible change are recognized as outdated.
+const Version = "1"
+
+// MaxLength is the maximum size of callback data accepted by Telegram, in bytes.
+const MaxLength = 64
+
+// signatureBytes is the number of bytes of the HMAC kept in the signature.
+const signatureBytes = 6
+
+// Separators of the encoding
+const (
+	versionSeparator   = ":"
+	argSeparator       = "|"
+	signatureSeparator = "#"
+)
+
+var (
+	// ErrTooLong is returned when encoded data doesn't fit in MaxLength bytes.
+	ErrTooLong = errors.New("callback data is too long")
+
+	// ErrVersion is returned for data without the current version tag.
+	ErrVersion = errors.New("unsupported callback data version")
+
+	// ErrSignature is returned for data with a missing or wrong signature.
+	ErrSignature = errors.New("invalid callback data signature")
+)
+
+// escapedChars are the characters escaped in the action and arguments.
+const escapedChars = "%" + argSeparator + signatureSeparator
+
+var (
+	escaper   = strings.NewReplacer("%", "%25", argSeparator, "%7C", signatureSeparator, "%23")
+	unescaper = strings.NewReplacer("%25", "%", "%7C", argSeparator, "%23", signatureSeparator)
+)
+
+// secret is the key used to sign callback data; no signature is added while it's empty.
+var secret []byte
+
+// SetSecret sets the key used to sign and verify callback data.
+// It must be called before any data is encoded; an empty key turns signing off.
+
+func SetSecret(key []byte) {
+	secret = key
+}
+
+// Args holds the arguments of callback data.
+
+type Args []string
+
+// String returns the argument at index i, or an empty string if there is none.
+
+func (a Args) String(i int) string {
+	if i < 0 || i >= len(a) {
+		return ""
+	}
+	return a[i]
+}
+
+// Int returns the argument at index i as an int.
+
+func (a Args) Int(i int) (int, error) {
+	if i < 0 || i >= len(a) {
+		return 0, fmt.Errorf("missing callback argument %d", i)
+	}
+	return strconv.Atoi(a[i])
+}
+
+// Int64 returns the argument at index i as an int64.
+
+func (a Args) Int64(i int) (int64, error) {
+	if i < 0 || i >= len(a) {
+		return 0, fmt.Errorf("missing callback argument %d", i)
+	}
+	return strconv.ParseInt(a[i], 10, 64)
+}
+
+// Float returns the argument at index i as a float64.
+
+func (a Args) Float(i int) (float64, error) {
+	if i < 0 || i >= len(a) {
+		return 0, fmt.Errorf("missing callback argument %d", i)
+	}
+	return strconv.ParseFloat(a[i], 64)
+}
+
+// Bool returns the argument at index i as a bool.
+
+func (a Args) Bool(i int) (bool, error) {
+	if i < 0 || i >= len(a) {
+		return false, fmt.Errorf("missing callback argument %d", i)
+	}
+	return strconv.ParseBool(a[i])
+}
+
+// Data is decoded callback data: the action naming the handler and its arguments.
+
+type Data struct {
+	Action string
+	Args   Args
+}
+
+// New creates callback data for the action. Arguments are stored as text;
+// strings, integers, floats and bools are formatted compactly, anything else with fmt.
+
+func New(action string, args ...any) Data {
+	data := Data{Action: action, Args: make(Args, 0, len(args))}
+
+	for _, arg := range args {
+		var s string
+		switch v := arg.(type) {
+		case string:
+			s = v
+		case int:
+			s = strconv.Itoa(v)
+		case int64:
+			s = strconv.FormatInt(v, 10)
+		case float64:
+			s = strconv.FormatFloat(v, 'f', -1, 64)
+		case bool:
+			s = strconv.FormatBool(v)
+		default:
+			s = fmt.Sprint(v)
+		}
+		data.Args = append(data.Args, s)
+	}
+	return data
+}
+
+// Encode encodes callback data for the action with the given arguments.
+
+func Encode(action string, args ...any) (string, error) {
+	return New(action, args...).Encode()
+}
+
+// Encode returns the encoded callback data, signed if a secret is set.
+// It returns ErrTooLong if the result exceeds MaxLength bytes.
+
+func (d Data) Encode() (string, error) {
+
+	var b strings.Builder
+	b.WriteString(Version)
+	b.WriteString(versionSeparator)
+	b.WriteString(escaper.Replace(d.Action))
+	for _, arg := range d.Args {
+		b.WriteString(argSeparator)
+		b.WriteString(escaper.Replace(arg))
+	}
+
+	encoded := b.String()
+	if len(secret) > 0 {
+		encoded += signatureSeparator + sign(encoded)
+	}
+
+	if len(encoded) > MaxLength {
+		return "", fmt.Errorf("%w: %q is %d bytes", ErrTooLong, encoded, len(encoded))
+	}
+	return encoded, nil
+}
+
+// Truncate shortens an argument so its escaped form takes at most n bytes, without splitting a rune.
+// Escaped separators take 3 bytes each, so a limit on the raw text doesn't bound the encoded data.
+
+func Truncate(arg string, n int) string {
+	size := 0
+	for i, r := range arg {
+		width := utf8.RuneLen(r)
+		if strings.ContainsRune(escapedChars, r) {
+			width = 3
+		}
+		if size+width > n {
+			return arg[:i]
+		}
+		size += width
+	}
+	return arg
+}
+
+// Decode parses encoded callback data, verifying its signature if a secret is set.
+
+func Decode(encoded string) (Data, error) {
+
+	body := encoded
+	if len(secret) > 0 {
+		var signature string
+		var found bool
+		body, signature, found = strings.Cut(encoded, signatureSeparator)
+		if !found || !hmac.Equal([]byte(signature), []byte(sign(body))) {
+			return Data{}, ErrSignature
+		}
+	}
+
+	version, rest, found := strings.Cut(body, versionSeparator)
+	if !found || version != Version {
+		return Data{}, ErrVersion
+	}
+
+	parts := strings.Split(rest, argSeparator)
+	data := Data{Action: unescaper.Replace(parts[0]), Args: make(Args, 0, len(parts)-1)}
+	for _, part := range parts[1:] {
+		data.Args = append(data.Args, unescaper.Replace(part))
+	}
+	return data, nil
+}
+
+// sign returns the truncated HMAC-SHA256 of the encoded data.
+
+func sign(body string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(body))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:signatureBytes])
+}
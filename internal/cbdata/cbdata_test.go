@@ -0,0 +1,163 @@
+package cbdata
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// withSecret runs the test with a signing secret and restores the previous one afterwards.
+
+func withSecret(t *testing.T, key string) {
+	previous := secret
+	SetSecret([]byte(key))
+	t.Cleanup(func() { SetSecret(previous) })
+}
+
+func TestEncode(t *testing.T) {
+	withSecret(t, "")
+
+	tests := []struct {
+		name    string
+		data    Data
+		encoded string
+	}{
+		{"action only", New("menu"), "1:menu"},
+		{"typed args", New("pair", "tgt", 42, int64(-7), 0.5, true), "1:pair|tgt|42|-7|0.5|true"},
+		{"empty args", New("hist", "", ""), "1:hist||"},
+		{"escaped separators", New("q", "a|b", "50%", "#tag", "%7C"), "1:q|a%7Cb|50%25|%23tag|%257C"},
+		{"escaped action", New("a|b"), "1:a%7Cb"},
+		{"unicode", New("g", "سلام"), "1:g|سلام"},
+	}
+	for _, test := range tests {
+		encoded, err := test.data.Encode()
+		if err != nil || encoded != test.encoded {
+			t.Errorf("%s: Encode = %q, %v, want %q", test.name, encoded, err, test.encoded)
+			continue
+		}
+		decoded, err := Decode(encoded)
+		if err != nil || !reflect.DeepEqual(decoded, test.data) {
+			t.Errorf("%s: Decode(%q) = %+v, %v, want %+v", test.name, encoded, decoded, err, test.data)
+		}
+	}
+}
+
+func TestEncodeMaxLength(t *testing.T) {
+	withSecret(t, "")
+
+	// "1:a|" is 4 bytes
+	if encoded, err := Encode("a", strings.Repeat("x", MaxLength-4)); err != nil || len(encoded) != MaxLength {
+		t.Errorf("data of MaxLength bytes = %q, %v", encoded, err)
+	}
+	if _, err := Encode("a", strings.Repeat("x", MaxLength-3)); !errors.Is(err, ErrTooLong) {
+		t.Errorf("data over MaxLength = %v, want ErrTooLong", err)
+	}
+	// Escaping counts: 21 separators take 63 bytes
+	if _, err := Encode("a", strings.Repeat("|", 21)); !errors.Is(err, ErrTooLong) {
+		t.Errorf("escaped data over MaxLength = %v, want ErrTooLong", err)
+	}
+
+	// The signature counts too
+	withSecret(t, "secret")
+	if _, err := Encode("a", strings.Repeat("x", MaxLength-4)); !errors.Is(err, ErrTooLong) {
+		t.Errorf("signed data over MaxLength = %v, want ErrTooLong", err)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		arg  string
+		n    int
+		want string
+	}{
+		{"hello", 10, "hello"},
+		{"hello", 3, "hel"},
+		{"%%%%", 7, "%%"},
+		{"a#b|c", 5, "a#b"},
+		{"سلام", 5, "سل"},
+		{"", 3, ""},
+	}
+	for _, test := range tests {
+		got := Truncate(test.arg, test.n)
+		if got != test.want {
+			t.Errorf("Truncate(%q, %d) = %q, want %q", test.arg, test.n, got, test.want)
+		}
+		if size := len(escaper.Replace(got)); size > test.n {
+			t.Errorf("Truncate(%q, %d) takes %d bytes escaped", test.arg, test.n, size)
+		}
+	}
+}
+
+func TestDecodeVersion(t *testing.T) {
+	withSecret(t, "")
+
+	for _, encoded := range []string{"2:menu", "menu", ":menu", "10:menu"} {
+		if _, err := Decode(encoded); !errors.Is(err, ErrVersion) {
+			t.Errorf("Decode(%q) = %v, want ErrVersion", encoded, err)
+		}
+	}
+}
+
+func TestSignature(t *testing.T) {
+	withSecret(t, "secret")
+
+	encoded, err := Encode("ban", 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, signature, found := strings.Cut(encoded, signatureSeparator)
+	if !found || body != "1:ban|42" || signature == "" {
+		t.Fatalf("signed data = %q", encoded)
+	}
+	if data, err := Decode(encoded); err != nil || !reflect.DeepEqual(data, New("ban", 42)) {
+		t.Errorf("Decode(%q) = %+v, %v", encoded, data, err)
+	}
+
+	// Data signed with another secret
+	withSecret(t, "other")
+	forged, err := Encode("ban", 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	withSecret(t, "secret")
+
+	tests := map[string]string{
+		"missing signature": body,
+		"empty signature":   body + signatureSeparator,
+		"wrong signature":   body + signatureSeparator + "AAAAAAAA",
+		"changed argument":  "1:ban|43" + signatureSeparator + signature,
+		"other secret":      forged,
+	}
+	for name, encoded := range tests {
+		if _, err := Decode(encoded); !errors.Is(err, ErrSignature) {
+			t.Errorf("%s: Decode(%q) = %v, want ErrSignature", name, encoded, err)
+		}
+	}
+}
+
+func TestArgs(t *testing.T) {
+	args := New("x", "word", 3, int64(9000000000), 1.5, true).Args
+
+	if args.String(0) != "word" || args.String(9) != "" {
+		t.Errorf("String = %q, %q", args.String(0), args.String(9))
+	}
+	if n, err := args.Int(1); n != 3 || err != nil {
+		t.Errorf("Int = %d, %v", n, err)
+	}
+	if n, err := args.Int64(2); n != 9000000000 || err != nil {
+		t.Errorf("Int64 = %d, %v", n, err)
+	}
+	if f, err := args.Float(3); f != 1.5 || err != nil {
+		t.Errorf("Float = %v, %v", f, err)
+	}
+	if b, err := args.Bool(4); !b || err != nil {
+		t.Errorf("Bool = %t, %v", b, err)
+	}
+	if _, err := args.Int(0); err == nil {
+		t.Error("Int of a word succeeded")
+	}
+	if _, err := args.Int(9); err == nil {
+		t.Error("Int of a missing argument succeeded")
+	}
+}
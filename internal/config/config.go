@@ -2,7 +2,11 @@ package config
 
 import (
 	"errors"
+	"fmt"
 	"os"
+	"regexp"
+	"strconv"
+	"strings"
 )
 
 // TokenFromENV retrieves the Telegram bot token from the environment variables.
@@ -16,3 +20,246 @@ func TokenFromENV() (string, error) {
 
 	return token, nil
 }
+
+// AdminIDsFromENV retrieves the Telegram user IDs of the bot admins from the environment variables.
+// ADMIN_IDS holds a comma separated list; an empty value means the bot has no admins.
+
+func AdminIDsFromENV() ([]int64, error) {
+	var ids []int64
+
+	for _, field := range strings.Split(os.Getenv("ADMIN_IDS"), ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid admin id %q in ADMIN_IDS: %v", field, err)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+// MenuFileFromENV retrieves the path of the menu definition file from the environment variables.
+// An empty value means the built-in menu definition is used.
+
+func MenuFileFromENV() string {
+	return os.Getenv("MENU_FILE")
+}
+
+// CallbackSecretFromENV retrieves the key used to sign the callback data of buttons from the environment variables.
+// An empty value means callback data is not signed.
+
+func CallbackSecretFromENV() []byte {
+	return []byte(os.Getenv("CALLBACK_SECRET"))
+}
+
+// DeepLinkSecretFromENV retrieves the key used to sign the start payloads of deep links from the environment variables.
+// An empty value means payloads are only validated, so hand-written links such as start=lang_fa work.
+
+func DeepLinkSecretFromENV() []byte {
+	return []byte(os.Getenv("DEEPLINK_SECRET"))
+}
+
+// WebAppURLFromENV retrieves the public HTTPS address of the Mini App, served at /app, from the environment variables.
+// An empty value means the menu button isn't changed.
+
+func WebAppURLFromENV() string {
+	return os.Getenv("WEBAPP_URL")
+}
+
+// MetricsTokenFromENV retrieves the token the requests to /metrics must carry as a bearer token
+// from the environment variables. An empty METRICS_TOKEN means /metrics isn't served.
+
+func MetricsTokenFromENV() string {
+	return strings.TrimSpace(os.Getenv("METRICS_TOKEN"))
+}
+
+// Ways the bot gets its updates
+const (
+	WebhookMode = "webhook" // Telegram posts the updates to /webhook
+	PollingMode = "polling" // The bot asks Telegram for updates with getUpdates
+)
+
+// UpdateModeFromENV retrieves how the bot gets its updates from the environment variables.
+// UPDATE_MODE is "webhook", the default, or "polling" for development and servers without a public HTTPS address.
+
+func UpdateModeFromENV() (string, error) {
+	switch mode := strings.ToLower(strings.TrimSpace(os.Getenv("UPDATE_MODE"))); mode {
+	case "", WebhookMode:
+		return WebhookMode, nil
+	case PollingMode:
+		return PollingMode, nil
+	default:
+		return "", fmt.Errorf("invalid UPDATE_MODE %q, expected %s or %s", mode, WebhookMode, PollingMode)
+	}
+}
+
+// PollingOffsetFromENV retrieves the identifier of the first update to get by polling from the environment variables.
+// An empty value or 0 resumes after the last handled update; -1 skips to the newest pending update.
+
+func PollingOffsetFromENV() (int, error) {
+	value := strings.TrimSpace(os.Getenv("POLLING_OFFSET"))
+	if value == "" {
+		return 0, nil
+	}
+	offset, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid POLLING_OFFSET %q: %v", value, err)
+	}
+	return offset, nil
+}
+
+// PollingTimeoutFromENV retrieves the seconds a getUpdates request waits for updates from the environment variables,
+// 60 by default.
+
+func PollingTimeoutFromENV() (int, error) {
+	value := strings.TrimSpace(os.Getenv("POLLING_TIMEOUT"))
+	if value == "" {
+		return 60, nil
+	}
+	timeout, err := strconv.Atoi(value)
+	if err != nil || timeout < 0 {
+		return 0, fmt.Errorf("invalid POLLING_TIMEOUT %q, expected a number of seconds", value)
+	}
+	return timeout, nil
+}
+
+// AllowedUpdatesFromENV retrieves the kinds of updates the bot gets from the environment variables.
+// ALLOWED_UPDATES holds a comma separated list such as "message,callback_query,inline_query";
+// an empty value means the kinds Telegram sends by default.
+
+func AllowedUpdatesFromENV() []string {
+	var kinds []string
+	for _, field := range strings.Split(os.Getenv("ALLOWED_UPDATES"), ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			kinds = append(kinds, field)
+		}
+	}
+	return kinds
+}
+
+// secretTokenPattern is the form of a webhook secret token Telegram accepts.
+var secretTokenPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`)
+
+// WebhookURLFromENV retrieves the public HTTPS address of the webhook route, such as https://example.com/webhook,
+// from the environment variables. The webhook is registered at startup if it's set; an empty value leaves
+// the webhook as it was set by hand.
+
+func WebhookURLFromENV() string {
+	return strings.TrimSpace(os.Getenv("WEBHOOK_URL"))
+}
+
+// WebhookSecretFromENV retrieves the secret token of the webhook from the environment variables.
+// WEBHOOK_SECRET holds 1 to 256 letters, digits, _ or -. It may be empty only when WEBHOOK_URL is set,
+// in which case a secret is generated for the registration.
+
+func WebhookSecretFromENV() (string, error) {
+	secret := os.Getenv("WEBHOOK_SECRET")
+	if secret != "" && !secretTokenPattern.MatchString(secret) {
+		return "", errors.New("invalid WEBHOOK_SECRET, expected 1 to 256 letters, digits, _ or -")
+	}
+	return secret, nil
+}
+
+// WebhookMaxConnectionsFromENV retrieves the maximum number of simultaneous webhook connections
+// from the environment variables. WEBHOOK_MAX_CONNECTIONS is between 1 and 100; an empty value
+// means Telegram's default of 40.
+
+func WebhookMaxConnectionsFromENV() (int, error) {
+	value := strings.TrimSpace(os.Getenv("WEBHOOK_MAX_CONNECTIONS"))
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 || n > 100 {
+		return 0, fmt.Errorf("invalid WEBHOOK_MAX_CONNECTIONS %q, expected a number from 1 to 100", value)
+	}
+	return n, nil
+}
+
+// DeleteWebhookOnShutdownFromENV reports whether the webhook is removed when the bot stops,
+// from the environment variables. WEBHOOK_DELETE_ON_SHUTDOWN is a boolean such as true or false, false by default.
+
+func DeleteWebhookOnShutdownFromENV() (bool, error) {
+	value := strings.TrimSpace(os.Getenv("WEBHOOK_DELETE_ON_SHUTDOWN"))
+	if value == "" {
+		return false, nil
+	}
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid WEBHOOK_DELETE_ON_SHUTDOWN %q: %v", value, err)
+	}
+	return enabled, nil
+}
+
+// WorkersFromENV retrieves the number of workers handling the updates from the environment variables.
+// An empty value means the default of 8.
+
+func WorkersFromENV() (int, error) {
+	return positiveIntFromENV("WORKERS")
+}
+
+// WorkerQueueSizeFromENV retrieves the number of updates that can wait for each worker from the environment variables.
+// An empty value means the default of 64.
+
+func WorkerQueueSizeFromENV() (int, error) {
+	return positiveIntFromENV("WORKER_QUEUE_SIZE")
+}
+
+// QueueOverflowFromENV retrieves what happens to an update whose queue is full from the environment variables.
+// QUEUE_OVERFLOW is "block", the default, to wait for room, "drop-newest" to drop the new update,
+// "drop-oldest" to drop the oldest waiting update, or "reject" to answer 503 so Telegram delivers it again later.
+// "drop" is kept as another name of "drop-newest".
+
+func QueueOverflowFromENV() (string, error) {
+	switch policy := strings.ToLower(strings.TrimSpace(os.Getenv("QUEUE_OVERFLOW"))); policy {
+	case "", "block":
+		return "block", nil
+	case "drop", "drop-newest":
+		return "drop-newest", nil
+	case "drop-oldest", "reject":
+		return policy, nil
+	default:
+		return "", fmt.Errorf("invalid QUEUE_OVERFLOW %q, expected block, drop-newest, drop-oldest or reject", policy)
+	}
+}
+
+// DedupWindowFromENV retrieves the number of processed update IDs remembered to drop updates delivered again
+// from the environment variables. An empty value means the default of 10000.
+
+func DedupWindowFromENV() (int, error) {
+	return positiveIntFromENV("DEDUP_WINDOW")
+}
+
+// DedupPersistFromENV reports whether the processed update IDs are saved in the database, so updates
+// delivered again after a restart are dropped too, from the environment variables.
+// DEDUP_PERSIST is a boolean such as true or false, false by default.
+
+func DedupPersistFromENV() (bool, error) {
+	value := strings.TrimSpace(os.Getenv("DEDUP_PERSIST"))
+	if value == "" {
+		return false, nil
+	}
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid DEDUP_PERSIST %q: %v", value, err)
+	}
+	return enabled, nil
+}
+
+// positiveIntFromENV retrieves a positive number from an environment variable, 0 if it's empty.
+
+func positiveIntFromENV(name string) (int, error) {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid %s %q, expected a positive number", name, value)
+	}
+	return n, nil
+}
@@ -0,0 +1,130 @@
+// Package deeplink encodes and decodes the payloads of deep links starting the bot, such as t.me/<bot>?start=pair_fa-en.
+//
+// A payload has the form "<kind>_<value>", optionally followed by "_<signature>" when a secret is set.
+// Telegram only passes payloads of up to 64 characters from A-Z, a-z, 0-9, _ and -,
+// so values can't contain underscores and the signature is written in hexadecimal.
+package deeplink
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Kinds of payloads
+const (
+	Pair     = "pair" // Preconfigured language pair, such as fa-en
+	Language = "lang" // Interface language of the bot, such as fa
+	Referral = "ref"  // Telegram user ID of the user who shared the link
+)
+
+// MaxLength is the maximum size of a start payload accepted by Telegram.
+const MaxLength = 64
+
+// signatureBytes is the number of bytes of the HMAC kept in the signature.
+const signatureBytes = 6
+
+// separator separates the kind, the value and the signature of a payload.
+const separator = "_"
+
+var (
+	// ErrFormat is returned for payloads of an unknown kind or with characters Telegram doesn't allow.
+	ErrFormat = errors.New("invalid start payload")
+
+	// ErrSignature is returned for payloads with a missing or wrong signature.
+	ErrSignature = errors.New("invalid start payload signature")
+)
+
+// kinds are the known kinds of payloads.
+var kinds = map[string]bool{Pair: true, Language: true, Referral: true}
+
+// secret is the key used to sign payloads; no signature is added or required while it's empty.
+var secret []byte
+
+// SetSecret sets the key used to sign and verify payloads.
+// An empty key turns signing off, so hand-written links such as start=lang_fa work.
+
+func SetSecret(key []byte) {
+	secret = key
+}
+
+// Payload is a decoded start payload.
+
+type Payload struct {
+	Kind  string
+	Value string
+}
+
+// Encode returns the payload of a deep link, signed if a secret is set.
+
+func Encode(kind, value string) (string, error) {
+
+	if !kinds[kind] || value == "" || !validChars(value) || strings.Contains(value, separator) {
+		return "", fmt.Errorf("%w: %s %q", ErrFormat, kind, value)
+	}
+
+	encoded := kind + separator + value
+	if len(secret) > 0 {
+		encoded += separator + sign(encoded)
+	}
+	if len(encoded) > MaxLength {
+		return "", fmt.Errorf("%w: %q is %d characters", ErrFormat, encoded, len(encoded))
+	}
+	return encoded, nil
+}
+
+// Decode parses a start payload, verifying its signature if a secret is set.
+
+func Decode(encoded string) (Payload, error) {
+
+	if encoded == "" || len(encoded) > MaxLength || !validChars(encoded) {
+		return Payload{}, ErrFormat
+	}
+
+	parts := strings.Split(encoded, separator)
+	if len(secret) > 0 {
+		if len(parts) != 3 {
+			return Payload{}, ErrSignature
+		}
+		body := parts[0] + separator + parts[1]
+		if !hmac.Equal([]byte(parts[2]), []byte(sign(body))) {
+			return Payload{}, ErrSignature
+		}
+		parts = parts[:2]
+	}
+
+	if len(parts) != 2 || !kinds[parts[0]] || parts[1] == "" {
+		return Payload{}, ErrFormat
+	}
+	return Payload{Kind: parts[0], Value: parts[1]}, nil
+}
+
+// Link returns the deep link starting the bot with the payload.
+
+func Link(botUsername, payload string) string {
+	return fmt.Sprintf("https://t.me/%s?start=%s", botUsername, payload)
+}
+
+// validChars reports whether s only holds characters allowed in start payloads.
+
+func validChars(s string) bool {
+	for _, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// sign returns the truncated HMAC-SHA256 of the payload.
+
+func sign(body string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(body))
+	return hex.EncodeToString(mac.Sum(nil)[:signatureBytes])
+}
@@ -0,0 +1,101 @@
+package deeplink
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// withSecret runs the test with a signing secret and restores the previous one afterwards.
+
+func withSecret(t *testing.T, key string) {
+	previous := secret
+	SetSecret([]byte(key))
+	t.Cleanup(func() { SetSecret(previous) })
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, key := range []string{"", "secret"} {
+		withSecret(t, key)
+		for _, payload := range []Payload{{Pair, "fa-en"}, {Language, "fa"}, {Referral, "123456789"}} {
+			encoded, err := Encode(payload.Kind, payload.Value)
+			if err != nil {
+				t.Errorf("secret %q: Encode(%+v) = %v", key, payload, err)
+				continue
+			}
+			if !validChars(encoded) || len(encoded) > MaxLength {
+				t.Errorf("secret %q: encoded payload %q isn't a valid start parameter", key, encoded)
+			}
+			if decoded, err := Decode(encoded); err != nil || decoded != payload {
+				t.Errorf("secret %q: Decode(%q) = %+v, %v, want %+v", key, encoded, decoded, err, payload)
+			}
+		}
+	}
+
+	// Hand-written links work without a secret
+	withSecret(t, "")
+	if decoded, err := Decode("lang_fa"); err != nil || decoded != (Payload{Language, "fa"}) {
+		t.Errorf("Decode(lang_fa) = %+v, %v", decoded, err)
+	}
+}
+
+func TestForgedSignature(t *testing.T) {
+	withSecret(t, "secret")
+
+	encoded, err := Encode(Referral, "42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature := encoded[strings.LastIndex(encoded, separator)+1:]
+
+	withSecret(t, "other")
+	forged, err := Encode(Referral, "42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	withSecret(t, "secret")
+
+	tests := map[string]string{
+		"missing signature": "ref_42",
+		"wrong signature":   "ref_42_000000000000",
+		"other secret":      forged,
+		"changed value":     "ref_43_" + signature,
+		"changed kind":      "lang_42_" + signature,
+	}
+	for name, payload := range tests {
+		if _, err := Decode(payload); !errors.Is(err, ErrSignature) {
+			t.Errorf("%s: Decode(%q) = %v, want ErrSignature", name, payload, err)
+		}
+	}
+}
+
+func TestInvalidPayloads(t *testing.T) {
+	withSecret(t, "")
+
+	long := "pair_" + strings.Repeat("a", MaxLength)
+	for _, payload := range []string{"", long, "lang_f a", "lang_fa!", "lang_فا", "pair_fa+en", "unknown_fa", "lang_", "lang"} {
+		if _, err := Decode(payload); !errors.Is(err, ErrFormat) {
+			t.Errorf("Decode(%q) = %v, want ErrFormat", payload, err)
+		}
+	}
+
+	tests := []struct{ kind, value string }{
+		{Pair, strings.Repeat("a", MaxLength)},
+		{Pair, "fa en"},
+		{Pair, "fa_en"},
+		{Language, "فا"},
+		{Language, ""},
+		{"unknown", "fa"},
+	}
+	for _, test := range tests {
+		if _, err := Encode(test.kind, test.value); !errors.Is(err, ErrFormat) {
+			t.Errorf("Encode(%q, %q) = %v, want ErrFormat", test.kind, test.value, err)
+		}
+	}
+
+	// The signature counts towards the limit
+	withSecret(t, "secret")
+	if _, err := Encode(Pair, strings.Repeat("a", MaxLength-len("pair_"))); !errors.Is(err, ErrFormat) {
+		t.Errorf("signed payload over MaxLength was encoded")
+	}
+}
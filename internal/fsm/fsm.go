@@ -0,0 +1,242 @@
+// Package fsm runs multi-step conversations as finite state machines.
+//
+// A Flow declares named states, the kind of input each state expects, how long the user may take
+// to answer and the events leading from one state to the next. The Machine keeps one Session per
+// user and chat in a Store, together with the context data collected along the way.
+package fsm
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Input is a kind of update a state can expect.
+type Input string
+
+// Kinds of input
+const (
+	Text     Input = "text"
+	Callback Input = "callback"
+	Document Input = "document"
+)
+
+// End is the target of transitions that finish the conversation.
+const End = "end"
+
+var (
+	// ErrExpired is returned when the conversation timed out; the session is removed.
+	ErrExpired = errors.New("conversation timed out")
+
+	// ErrTransition is returned for an event the current state has no transition for.
+	ErrTransition = errors.New("no transition for event")
+)
+
+// State is one step of a flow.
+
+type State struct {
+	Name    string            // State name, unique within the flow
+	Expects []Input           // Kinds of input the state accepts
+	Timeout time.Duration     // Time the user has to answer; zero means no timeout
+	On      map[string]string // Next state by event name; End finishes the conversation
+}
+
+// Flow is a declarative multi-step conversation.
+
+type Flow struct {
+	Name   string  // Flow name, unique within the machine
+	Start  string  // State the flow starts in
+	States []State // All states of the flow
+}
+
+// Session is the persisted state of a user's conversation in a chat.
+
+type Session struct {
+	UserID  int
+	ChatID  int64
+	Flow    string            // Name of the running flow
+	State   string            // Name of the current state
+	Data    map[string]string // Context data collected by the flow
+	Expires time.Time         // When the current state times out; zero means never
+}
+
+// Get returns the context data stored under the key, or an empty string.
+
+func (s *Session) Get(key string) string {
+	return s.Data[key]
+}
+
+// Set stores context data under the key. It's persisted by the next Fire or Update.
+
+func (s *Session) Set(key, value string) {
+	if s.Data == nil {
+		s.Data = make(map[string]string)
+	}
+	s.Data[key] = value
+}
+
+// Store persists sessions. Load returns nil without error if the user has no session in the chat.
+
+type Store interface {
+	Load(userID int, chatID int64) (*Session, error)
+	Save(session *Session) error
+	Delete(userID int, chatID int64) error
+}
+
+// Machine runs the registered flows on top of a Store.
+
+type Machine struct {
+	flows map[string]map[string]State // States by name, by flow name
+	start map[string]string           // Start state by flow name
+	store Store
+	now   func() time.Time
+}
+
+// NewMachine creates a Machine keeping its sessions in the store.
+
+func NewMachine(store Store) *Machine {
+	return &Machine{
+		flows: make(map[string]map[string]State),
+		start: make(map[string]string),
+		store: store,
+		now:   time.Now,
+	}
+}
+
+// Register validates the flow and makes it available.
+// Every state must expect some input, and every transition must lead to a state of the flow or to End.
+
+func (m *Machine) Register(flow Flow) error {
+
+	if _, exist := m.flows[flow.Name]; exist || flow.Name == "" {
+		return fmt.Errorf("flow %q is registered twice or has no name", flow.Name)
+	}
+
+	states := make(map[string]State)
+	for _, state := range flow.States {
+		if state.Name == "" || state.Name == End {
+			return fmt.Errorf("flow %s: invalid state name %q", flow.Name, state.Name)
+		}
+		if _, exist := states[state.Name]; exist {
+			return fmt.Errorf("flow %s: state %s is defined twice", flow.Name, state.Name)
+		}
+		if len(state.Expects) == 0 {
+			return fmt.Errorf("flow %s: state %s expects no input", flow.Name, state.Name)
+		}
+		states[state.Name] = state
+	}
+
+	if _, exist := states[flow.Start]; !exist {
+		return fmt.Errorf("flow %s: start state %q is not defined", flow.Name, flow.Start)
+	}
+	for _, state := range flow.States {
+		for event, next := range state.On {
+			if _, exist := states[next]; !exist && next != End {
+				return fmt.Errorf("flow %s: event %s of state %s leads to undefined state %s",
+					flow.Name, event, state.Name, next)
+			}
+		}
+	}
+
+	m.flows[flow.Name] = states
+	m.start[flow.Name] = flow.Start
+	return nil
+}
+
+// Start begins the flow in its start state, replacing any conversation the user has in the chat.
+
+func (m *Machine) Start(userID int, chatID int64, flow string, data map[string]string) (*Session, error) {
+	return m.StartAt(userID, chatID, flow, m.start[flow], data)
+}
+
+// StartAt begins the flow in the given state, replacing any conversation the user has in the chat.
+
+func (m *Machine) StartAt(userID int, chatID int64, flow, state string, data map[string]string) (*Session, error) {
+
+	if _, exist := m.flows[flow][state]; !exist {
+		return nil, fmt.Errorf("unknown state %s of flow %s", state, flow)
+	}
+	if data == nil {
+		data = make(map[string]string)
+	}
+
+	session := &Session{UserID: userID, ChatID: chatID, Flow: flow, State: state, Data: data}
+	m.touch(session)
+	if err := m.store.Save(session); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// Current returns the user's conversation in the chat, or nil if there is none.
+// A timed out conversation is removed and reported with ErrExpired.
+
+func (m *Machine) Current(userID int, chatID int64) (*Session, error) {
+
+	session, err := m.store.Load(userID, chatID)
+	if err != nil || session == nil {
+		return nil, err
+	}
+
+	if _, exist := m.flows[session.Flow][session.State]; !exist {
+		// The flow changed since the session was saved
+		return nil, m.store.Delete(userID, chatID)
+	}
+	if !session.Expires.IsZero() && m.now().After(session.Expires) {
+		if err := m.store.Delete(userID, chatID); err != nil {
+			return nil, err
+		}
+		return nil, ErrExpired
+	}
+	return session, nil
+}
+
+// Expects reports whether the current state of the session accepts the kind of input.
+
+func (m *Machine) Expects(session *Session, input Input) bool {
+	for _, expected := range m.flows[session.Flow][session.State].Expects {
+		if expected == input {
+			return true
+		}
+	}
+	return false
+}
+
+// Fire moves the session along the transition of the event and persists it with its context data.
+// A transition to End removes the session.
+
+func (m *Machine) Fire(session *Session, event string) error {
+
+	next, exist := m.flows[session.Flow][session.State].On[event]
+	if !exist {
+		return fmt.Errorf("%w %s in state %s of flow %s", ErrTransition, event, session.State, session.Flow)
+	}
+
+	if next == End {
+		return m.store.Delete(session.UserID, session.ChatID)
+	}
+	session.State = next
+	m.touch(session)
+	return m.store.Save(session)
+}
+
+// Update persists changes to the context data without changing the state.
+
+func (m *Machine) Update(session *Session) error {
+	return m.store.Save(session)
+}
+
+// Cancel ends the user's conversation in the chat, if any.
+
+func (m *Machine) Cancel(userID int, chatID int64) error {
+	return m.store.Delete(userID, chatID)
+}
+
+// touch restarts the timeout of the session's current state.
+
+func (m *Machine) touch(session *Session) {
+	session.Expires = time.Time{}
+	if timeout := m.flows[session.Flow][session.State].Timeout; timeout > 0 {
+		session.Expires = m.now().Add(timeout)
+	}
+}
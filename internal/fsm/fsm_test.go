@@ -0,0 +1,191 @@
+package fsm
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// memoryStore keeps the sessions in a map.
+
+type memoryStore struct {
+	sessions map[[2]int64]Session
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{sessions: make(map[[2]int64]Session)}
+}
+
+func (s *memoryStore) Load(userID int, chatID int64) (*Session, error) {
+	session, exist := s.sessions[[2]int64{int64(userID), chatID}]
+	if !exist {
+		return nil, nil
+	}
+	return &session, nil
+}
+
+func (s *memoryStore) Save(session *Session) error {
+	s.sessions[[2]int64{int64(session.UserID), session.ChatID}] = *session
+	return nil
+}
+
+func (s *memoryStore) Delete(userID int, chatID int64) error {
+	delete(s.sessions, [2]int64{int64(userID), chatID})
+	return nil
+}
+
+// testFlow asks for a word, then for a confirmation button.
+var testFlow = Flow{
+	Name:  "add",
+	Start: "word",
+	States: []State{
+		{Name: "word", Expects: []Input{Text}, Timeout: time.Minute, On: map[string]string{"typed": "confirm"}},
+		{Name: "confirm", Expects: []Input{Callback}, On: map[string]string{"yes": End, "no": "word"}},
+	},
+}
+
+// newTestMachine returns a machine running testFlow, whose clock is read from now.
+
+func newTestMachine(t *testing.T, now *time.Time) (*Machine, *memoryStore) {
+	store := newMemoryStore()
+	m := NewMachine(store)
+	m.now = func() time.Time { return *now }
+	if err := m.Register(testFlow); err != nil {
+		t.Fatal(err)
+	}
+	return m, store
+}
+
+func TestRegisterRejectsInvalidFlows(t *testing.T) {
+	tests := map[string]Flow{
+		"no name":          {Start: "a", States: []State{{Name: "a", Expects: []Input{Text}}}},
+		"missing start":    {Name: "f", Start: "b", States: []State{{Name: "a", Expects: []Input{Text}}}},
+		"unknown target":   {Name: "f", Start: "a", States: []State{{Name: "a", Expects: []Input{Text}, On: map[string]string{"go": "b"}}}},
+		"duplicate state":  {Name: "f", Start: "a", States: []State{{Name: "a", Expects: []Input{Text}}, {Name: "a", Expects: []Input{Text}}}},
+		"state named end":  {Name: "f", Start: End, States: []State{{Name: End, Expects: []Input{Text}}}},
+		"expects no input": {Name: "f", Start: "a", States: []State{{Name: "a"}}},
+	}
+	for name, flow := range tests {
+		if err := NewMachine(newMemoryStore()).Register(flow); err == nil {
+			t.Errorf("%s: Register accepted the flow", name)
+		}
+	}
+
+	m := NewMachine(newMemoryStore())
+	if err := m.Register(testFlow); err != nil {
+		t.Fatalf("Register(testFlow) = %v", err)
+	}
+	if err := m.Register(testFlow); err == nil {
+		t.Error("Register accepted a flow registered twice")
+	}
+}
+
+func TestFire(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	m, store := newTestMachine(t, &now)
+
+	session, err := m.Start(1, 10, "add", map[string]string{"lang": "fa"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.State != "word" || !session.Expires.Equal(now.Add(time.Minute)) {
+		t.Fatalf("started session = %+v", session)
+	}
+
+	session.Set("word", "salam")
+	if err := m.Fire(session, "typed"); err != nil {
+		t.Fatal(err)
+	}
+	current, err := m.Current(1, 10)
+	if err != nil || current == nil {
+		t.Fatalf("Current = %v, %v", current, err)
+	}
+	if current.State != "confirm" || current.Get("word") != "salam" || current.Get("lang") != "fa" {
+		t.Errorf("session after typed = %+v", current)
+	}
+	if !current.Expires.IsZero() {
+		t.Errorf("state without timeout expires at %v", current.Expires)
+	}
+
+	if err := m.Fire(current, "typed"); !errors.Is(err, ErrTransition) {
+		t.Errorf("Fire with an unknown event = %v, want ErrTransition", err)
+	}
+
+	if err := m.Fire(current, "no"); err != nil || current.State != "word" {
+		t.Errorf("Fire(no) = %v, state %s", err, current.State)
+	}
+	if err := m.Fire(current, "typed"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A transition to End clears the session
+	if err := m.Fire(current, "yes"); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.sessions) != 0 {
+		t.Errorf("sessions after End = %v", store.sessions)
+	}
+	if current, err := m.Current(1, 10); current != nil || err != nil {
+		t.Errorf("Current after End = %v, %v", current, err)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	now := time.Now()
+	m, store := newTestMachine(t, &now)
+
+	if _, err := m.Start(1, 10, "add", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Start(2, 10, "add", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Cancel(1, 10); err != nil {
+		t.Fatal(err)
+	}
+	if _, exist := store.sessions[[2]int64{1, 10}]; exist || len(store.sessions) != 1 {
+		t.Errorf("sessions after Cancel = %v", store.sessions)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	m, store := newTestMachine(t, &now)
+
+	if _, err := m.Start(1, 10, "add", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(time.Minute)
+	if current, err := m.Current(1, 10); current == nil || err != nil {
+		t.Fatalf("Current at the timeout = %v, %v", current, err)
+	}
+
+	now = now.Add(time.Second)
+	if current, err := m.Current(1, 10); current != nil || !errors.Is(err, ErrExpired) {
+		t.Errorf("Current after the timeout = %v, %v, want ErrExpired", current, err)
+	}
+	if len(store.sessions) != 0 {
+		t.Errorf("expired session wasn't removed: %v", store.sessions)
+	}
+}
+
+func TestExpects(t *testing.T) {
+	now := time.Now()
+	m, _ := newTestMachine(t, &now)
+
+	session, err := m.StartAt(1, 10, "add", "confirm", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.Expects(session, Callback) {
+		t.Error("confirm doesn't expect a callback")
+	}
+	if m.Expects(session, Text) || m.Expects(session, Document) {
+		t.Error("confirm expects text or a document")
+	}
+
+	if _, err := m.StartAt(1, 10, "add", "missing", nil); err == nil {
+		t.Error("StartAt accepted an unknown state")
+	}
+}
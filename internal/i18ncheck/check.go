@@ -0,0 +1,305 @@
+// Package i18ncheck reports problems of the locale catalogs: keys a catalog doesn't translate,
+// keys no code or menu uses, translations made from an English text that has changed since,
+// and messages that aren't valid templates or use other parameters than the English text.
+package i18ncheck
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mzfarshad/tlg_bot/internal/key"
+)
+
+// keyPackage is the import path of the package declaring the catalog keys.
+const keyPackage = "github.com/mzfarshad/tlg_bot/internal/key"
+
+// Report lists the problems of one locale, by key ID such as messages.mainMessage.
+
+type Report struct {
+	Language key.Language
+	Missing  []string // Keys used by the bot that the catalog doesn't translate
+	Unused   []string // Keys of the catalog that nothing uses
+	Stale    []string // Keys translated from an older English text
+	Invalid  []string // Messages that can't be parsed or don't use the parameters of the English text, with the reason
+}
+
+// Problems returns the number of problems in the report.
+
+func (r Report) Problems() int {
+	return len(r.Missing) + len(r.Unused) + len(r.Stale) + len(r.Invalid)
+}
+
+// Check reports the problems of every locale catalog, looking for the keys used in the Go files
+// and menu definitions under root.
+
+func Check(root string) ([]Report, error) {
+
+	used, err := usedKeys(root)
+	if err != nil {
+		return nil, err
+	}
+
+	var reports []Report
+	for _, lang := range key.Languages() {
+		catalog := key.GetCatalog(lang)
+		report := Report{Language: lang, Stale: key.StaleKeys(lang), Invalid: invalidMessages(lang)}
+
+		defined := make(map[string]bool)
+		for button, text := range catalog.Buttons {
+			if text != "" {
+				defined[key.ButtonID(button)] = true
+			}
+		}
+		for message, text := range catalog.Messages {
+			if text != "" {
+				defined[key.MessageID(message)] = true
+			}
+		}
+
+		for id := range used {
+			if !defined[id] {
+				report.Missing = append(report.Missing, id)
+			}
+		}
+		for id := range defined {
+			if !used[id] {
+				report.Unused = append(report.Unused, id)
+			}
+		}
+		sort.Strings(report.Missing)
+		sort.Strings(report.Unused)
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// Print writes the reports to w and returns the total number of problems.
+
+func Print(w io.Writer, reports []Report) int {
+
+	total := 0
+	for _, report := range reports {
+		fmt.Fprintf(w, "%s (%s): %d missing, %d unused, %d stale, %d invalid\n", report.Language,
+			key.LanguageName(report.Language), len(report.Missing), len(report.Unused), len(report.Stale), len(report.Invalid))
+		for _, id := range report.Missing {
+			fmt.Fprintf(w, "  missing %s\n", id)
+		}
+		for _, id := range report.Unused {
+			fmt.Fprintf(w, "  unused  %s\n", id)
+		}
+		for _, id := range report.Stale {
+			fmt.Fprintf(w, "  stale   %s (source %s)\n", id, currentHash(id))
+		}
+		for _, problem := range report.Invalid {
+			fmt.Fprintf(w, "  invalid %s\n", problem)
+		}
+		total += report.Problems()
+	}
+	return total
+}
+
+// currentHash returns the source hash of the current English text of a key,
+// which a translator records in the sources of a catalog after updating the translation.
+
+func currentHash(id string) string {
+	kind, name, _ := strings.Cut(id, ".")
+	if kind == "buttons" {
+		return key.SourceHash(key.GetKey(key.FallbackLanguage, key.TextButton(name)))
+	}
+	return key.SourceHash(key.GetMenuMessage(key.FallbackLanguage, key.TextMessage(name)))
+}
+
+// invalidMessages returns the messages of a catalog that aren't valid templates
+// or whose parameters differ from the English text.
+
+func invalidMessages(lang key.Language) []string {
+
+	var invalid []string
+	for message, text := range key.GetCatalog(lang).Messages {
+		id := key.MessageID(message)
+		params, err := key.TemplateParams(text)
+		if err != nil {
+			invalid = append(invalid, fmt.Sprintf("%s: %v", id, err))
+			continue
+		}
+		want, err := key.TemplateParams(key.GetMenuMessage(key.FallbackLanguage, message))
+		if err != nil {
+			continue // Reported for the English catalog
+		}
+		for name := range params {
+			if !want[name] {
+				invalid = append(invalid, fmt.Sprintf("%s: unknown parameter {%s}", id, name))
+			}
+		}
+		for name := range want {
+			if !params[name] {
+				invalid = append(invalid, fmt.Sprintf("%s: parameter {%s} is missing", id, name))
+			}
+		}
+	}
+	sort.Strings(invalid)
+	return invalid
+}
+
+// usedKeys returns the IDs of the catalog keys the bot uses: button and message constants of the key
+// package referred to by Go code, and the text and message keys of the menu definitions.
+
+func usedKeys(root string) (map[string]bool, error) {
+
+	constants, err := keyConstants(filepath.Join(root, "internal", "key", "keys.go"))
+	if err != nil {
+		return nil, err
+	}
+
+	used := make(map[string]bool)
+	err = filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if name := entry.Name(); path != root && (strings.HasPrefix(name, ".") || name == "vendor") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		switch {
+		case strings.HasSuffix(path, ".go") && !strings.HasSuffix(path, "_test.go"):
+			return goKeys(path, constants, used)
+		case filepath.Base(path) == "menus.json":
+			return menuKeys(path, used)
+		}
+		return nil
+	})
+	return used, err
+}
+
+// keyConstants reads the button and message constants declared in keys.go and returns their key IDs by name.
+
+func keyConstants(path string) (map[string]string, error) {
+
+	file, err := parser.ParseFile(token.NewFileSet(), path, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	constants := make(map[string]string)
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.CONST {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			value := spec.(*ast.ValueSpec)
+			kind, ok := value.Type.(*ast.Ident)
+			if !ok || len(value.Values) != len(value.Names) {
+				continue
+			}
+			for i, name := range value.Names {
+				literal, ok := value.Values[i].(*ast.BasicLit)
+				if !ok {
+					continue
+				}
+				text, err := strconv.Unquote(literal.Value)
+				if err != nil {
+					return nil, err
+				}
+				switch kind.Name {
+				case "TextButton":
+					constants[name.Name] = key.ButtonID(key.TextButton(text))
+				case "TextMessage":
+					constants[name.Name] = key.MessageID(key.TextMessage(text))
+				}
+			}
+		}
+	}
+	return constants, nil
+}
+
+// goKeys adds the key constants a Go file refers to, as key.Name outside the key package
+// and as Name inside it, except in keys.go where they are declared.
+
+func goKeys(path string, constants map[string]string, used map[string]bool) error {
+
+	file, err := parser.ParseFile(token.NewFileSet(), path, nil, 0)
+	if err != nil {
+		return err
+	}
+
+	inKeyPackage := file.Name.Name == "key"
+	if inKeyPackage && filepath.Base(path) == "keys.go" {
+		return nil
+	}
+
+	keyName := ""
+	for _, spec := range file.Imports {
+		if importPath, _ := strconv.Unquote(spec.Path.Value); importPath == keyPackage {
+			keyName = "key"
+			if spec.Name != nil {
+				keyName = spec.Name.Name
+			}
+		}
+	}
+
+	ast.Inspect(file, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.SelectorExpr:
+			if pkg, ok := node.X.(*ast.Ident); ok && keyName != "" && pkg.Name == keyName {
+				if id, exist := constants[node.Sel.Name]; exist {
+					used[id] = true
+				}
+			}
+		case *ast.Ident:
+			if id, exist := constants[node.Name]; exist && inKeyPackage {
+				used[id] = true
+			}
+		}
+		return true
+	})
+	return nil
+}
+
+// menuKeys adds the message and button text keys of a menu definition.
+
+func menuKeys(path string, used map[string]bool) error {
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var tree struct {
+		Menus []struct {
+			Message string `json:"message"`
+			Rows    [][]struct {
+				Text string `json:"text"`
+			} `json:"rows"`
+		} `json:"menus"`
+	}
+	if err := json.Unmarshal(data, &tree); err != nil {
+		return fmt.Errorf("error reading menus %s: %w", path, err)
+	}
+
+	for _, menu := range tree.Menus {
+		if menu.Message != "" {
+			used[key.MessageID(key.TextMessage(menu.Message))] = true
+		}
+		for _, row := range menu.Rows {
+			for _, button := range row {
+				if button.Text != "" {
+					used[key.ButtonID(key.TextButton(button.Text))] = true
+				}
+			}
+		}
+	}
+	return nil
+}
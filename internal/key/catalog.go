@@ -0,0 +1,226 @@
+package key
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strings"
+)
+
+// FallbackLanguage is the language of texts missing from the catalog of another language.
+const FallbackLanguage = LangEN
+
+// Catalog holds the texts of one interface language, read from locales/<language>.json.
+
+type Catalog struct {
+	Name     string                 `json:"name"` // Name of the language in the language itself
+	Buttons  map[TextButton]string  `json:"buttons"`
+	Messages map[TextMessage]string `json:"messages"`
+	Sources  map[string]string      `json:"sources"` // SourceHash of the English text each key was translated from
+}
+
+//go:embed locales/*.json
+var locales embed.FS
+
+// catalogs are the catalogs bundled with the bot, by language.
+var catalogs = loadCatalogs(locales)
+
+// loadCatalogs reads every catalog of the locales directory.
+// The catalogs are built into the binary, so a malformed one is a programming error.
+
+func loadCatalogs(files fs.FS) map[Language]*Catalog {
+
+	names, err := fs.Glob(files, "locales/*.json")
+	if err != nil {
+		log.Panic(err)
+	}
+
+	catalogs := make(map[Language]*Catalog, len(names))
+	for _, name := range names {
+		data, err := fs.ReadFile(files, name)
+		if err != nil {
+			log.Panic(err)
+		}
+		catalog := &Catalog{}
+		if err := json.Unmarshal(data, catalog); err != nil {
+			log.Panic(fmt.Errorf("error reading locale catalog %s: %w", name, err))
+		}
+		catalogs[Language(strings.TrimSuffix(path.Base(name), ".json"))] = catalog
+	}
+
+	if _, exist := catalogs[FallbackLanguage]; !exist {
+		log.Panicf("locale catalog of the fallback language %s is missing", FallbackLanguage)
+	}
+	return catalogs
+}
+
+// Languages returns the interface languages that have a catalog, sorted by code.
+
+func Languages() []Language {
+	languages := make([]Language, 0, len(catalogs))
+	for lang := range catalogs {
+		languages = append(languages, lang)
+	}
+	sort.Slice(languages, func(i, j int) bool { return languages[i] < languages[j] })
+	return languages
+}
+
+// Supported reports whether lang is an interface language of the bot.
+
+func Supported(lang Language) bool {
+	_, exist := catalogs[lang]
+	return exist
+}
+
+// GetCatalog returns the catalog of a language, or nil if the bot doesn't speak it.
+
+func GetCatalog(lang Language) *Catalog {
+	return catalogs[lang]
+}
+
+// Get the text for a button based on the language and button key,
+// in the fallback language if the language has no text for it
+func GetKey(lang Language, button TextButton) string {
+	if text, exist := catalogs[lang].button(button); exist {
+		return text
+	}
+	text, _ := catalogs[FallbackLanguage].button(button)
+	return text
+}
+
+// Get the menu message based on the language and message key,
+// in the fallback language if the language has no text for it
+func GetMenuMessage(lang Language, message TextMessage) string {
+	if text, exist := catalogs[lang].message(message); exist {
+		return text
+	}
+	text, _ := catalogs[FallbackLanguage].message(message)
+	return text
+}
+
+// HasButton reports whether a button text is defined in the fallback catalog.
+
+func HasButton(button TextButton) bool {
+	_, exist := catalogs[FallbackLanguage].button(button)
+	return exist
+}
+
+// HasMessage reports whether a message is defined in the fallback catalog.
+
+func HasMessage(message TextMessage) bool {
+	_, exist := catalogs[FallbackLanguage].message(message)
+	return exist
+}
+
+// MissingKeys returns the keys of the fallback catalog that the catalog of lang doesn't translate, sorted.
+
+func MissingKeys(lang Language) []string {
+
+	catalog := catalogs[lang]
+	var missing []string
+	for button := range catalogs[FallbackLanguage].Buttons {
+		if _, exist := catalog.button(button); !exist {
+			missing = append(missing, ButtonID(button))
+		}
+	}
+	for message := range catalogs[FallbackLanguage].Messages {
+		if _, exist := catalog.message(message); !exist {
+			missing = append(missing, MessageID(message))
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// StaleKeys returns the keys the catalog of lang translates from an older English text, sorted:
+// their source hash is missing or differs from the hash of the current English text.
+
+func StaleKeys(lang Language) []string {
+
+	catalog := catalogs[lang]
+	if catalog == nil || lang == FallbackLanguage {
+		return nil
+	}
+	var stale []string
+	check := func(id, text, translation string) {
+		if translation != "" && catalog.Sources[id] != SourceHash(text) {
+			stale = append(stale, id)
+		}
+	}
+	for button, text := range catalogs[FallbackLanguage].Buttons {
+		check(ButtonID(button), text, catalog.Buttons[button])
+	}
+	for message, text := range catalogs[FallbackLanguage].Messages {
+		check(MessageID(message), text, catalog.Messages[message])
+	}
+	sort.Strings(stale)
+	return stale
+}
+
+// Closest returns the interface language closest to an IETF language tag such as a Telegram client reports,
+// like fa or pt-BR: the language of the whole tag, or else of its primary subtag.
+// It reports false and returns the fallback language if the bot speaks neither.
+
+func Closest(tag string) (Language, bool) {
+	tag = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(tag), "_", "-"))
+	if Supported(Language(tag)) {
+		return Language(tag), true
+	}
+	if primary, _, found := strings.Cut(tag, "-"); found && Supported(Language(primary)) {
+		return Language(primary), true
+	}
+	return FallbackLanguage, false
+}
+
+// LanguageName returns the name of an interface language in the language itself.
+
+func LanguageName(lang Language) string {
+	if catalog := catalogs[lang]; catalog != nil && catalog.Name != "" {
+		return catalog.Name
+	}
+	return string(lang)
+}
+
+// ButtonID returns the ID of a button key in the Sources of a catalog and in reports, such as buttons.back.
+
+func ButtonID(button TextButton) string {
+	return "buttons." + string(button)
+}
+
+// MessageID returns the ID of a message key in the Sources of a catalog and in reports.
+
+func MessageID(message TextMessage) string {
+	return "messages." + string(message)
+}
+
+// SourceHash returns the short hash of an English text that a translation records in its Sources,
+// so a translation made before the English text changed can be found.
+
+func SourceHash(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:4])
+}
+
+// button returns the non-empty text of a button in the catalog.
+
+func (c *Catalog) button(button TextButton) (string, bool) {
+	if c == nil || c.Buttons[button] == "" {
+		return "", false
+	}
+	return c.Buttons[button], true
+}
+
+// message returns the non-empty text of a message in the catalog.
+
+func (c *Catalog) message(message TextMessage) (string, bool) {
+	if c == nil || c.Messages[message] == "" {
+		return "", false
+	}
+	return c.Messages[message], true
+}
@@ -0,0 +1,53 @@
+package key
+
+import (
+	"testing"
+)
+
+// TestCatalogsComplete fails when the catalog of a language lacks a text of the fallback catalog,
+// so the bot never shows a user a text in another language by accident.
+
+func TestCatalogsComplete(t *testing.T) {
+	for _, lang := range Languages() {
+		if missing := MissingKeys(lang); len(missing) > 0 {
+			t.Errorf("locale %s is missing %d keys: %v", lang, len(missing), missing)
+		}
+	}
+}
+
+// TestFallback checks that texts missing from a catalog are shown in the fallback language.
+
+func TestFallback(t *testing.T) {
+	if got, want := GetKey("xx", KeyBack), GetKey(FallbackLanguage, KeyBack); got != want || got == "" {
+		t.Errorf("GetKey of an unknown language = %q, want %q", got, want)
+	}
+	if got, want := GetMenuMessage("xx", MainMessage), GetMenuMessage(FallbackLanguage, MainMessage); got != want || got == "" {
+		t.Errorf("GetMenuMessage of an unknown language = %q, want %q", got, want)
+	}
+}
+
+// TestCatalogTemplates fails when a message isn't a valid template or uses other parameters than the English one,
+// so a translation can't drop or misspell a parameter.
+
+func TestCatalogTemplates(t *testing.T) {
+	for _, lang := range Languages() {
+		for message, text := range GetCatalog(lang).Messages {
+			params, err := TemplateParams(text)
+			if err != nil {
+				t.Errorf("locale %s, message %s: %v", lang, message, err)
+				continue
+			}
+			want, _ := TemplateParams(GetMenuMessage(FallbackLanguage, message))
+			for name := range params {
+				if !want[name] {
+					t.Errorf("locale %s, message %s: unknown parameter %s", lang, message, name)
+				}
+			}
+			for name := range want {
+				if !params[name] {
+					t.Errorf("locale %s, message %s: parameter %s is missing", lang, message, name)
+				}
+			}
+		}
+	}
+}
@@ -7,7 +7,7 @@ type TextMessage string
 type MenuState string
 type HandlerName string
 
-// Constants defining language codes and button keys
+// Constants defining language codes and the keys of the texts in the locale catalogs
 const (
 	LangEN Language = "en"
 	LangFA Language = "fa"
@@ -23,10 +23,36 @@ const (
 	KeyResetTranslationSetting TextButton = "resetTranslationSetting"
 	KeyFinishSetup             TextButton = "finishSetup"
 	KeyResetTranslateYes       TextButton = "resetTranslateYes"
+	KeyAutoDetect              TextButton = "autoDetect"
+	KeySearchLanguage          TextButton = "searchLanguage"
+	KeySwapLanguages           TextButton = "swapLanguages"
+	KeyConfirmPairs            TextButton = "confirmPairs"
+	KeyChangePairs             TextButton = "changePairs"
+	KeyNextPage                TextButton = "nextPage"
+	KeyPreviousPage            TextButton = "previousPage"
+	KeyHistorySearch           TextButton = "historySearch"
+	KeyClearSearch             TextButton = "clearSearch"
+	KeyClearHistory            TextButton = "clearHistory"
+	KeyPauseHistory            TextButton = "pauseHistory"
+	KeyResumeHistory           TextButton = "resumeHistory"
+	KeySavePhrase              TextButton = "savePhrase"
+	KeyPhraseSaved             TextButton = "phraseSaved"
+	KeyExportCSV               TextButton = "exportCSV"
+	KeyExportText              TextButton = "exportText"
+	KeyThumbsUp                TextButton = "thumbsUp"
+	KeyThumbsDown              TextButton = "thumbsDown"
+	KeySuggestCorrection       TextButton = "suggestCorrection"
+	KeyReviewCorrections       TextButton = "reviewCorrections"
+	KeyApprove                 TextButton = "approve"
+	KeyReject                  TextButton = "reject"
+	KeyFeedbackStats           TextButton = "feedbackStats"
+	KeyAddGlossaryTerm         TextButton = "addGlossaryTerm"
+	KeyImportGlossary          TextButton = "importGlossary"
+	KeySettingSources          TextButton = "settingSources"
+	KeyWebApp                  TextButton = "webApp"
 
 	// Message keys
 	MainMessage                        TextMessage = "mainMessage"
-	StartMessage                       TextMessage = "startMessage"
 	SettingMessage                     TextMessage = "settingsMessage"
 	SettingLanguageMessage             TextMessage = "settingLanguageMessage"
 	ChangeLanguageMessage              TextMessage = "changLanguageMessage"
@@ -37,6 +63,93 @@ const (
 	TranslateFinishSetupMessage        TextMessage = "translateFinishSetupMessage"
 	ResetTranslateSettingMessage       TextMessage = "resetTranslateMessage"
 	FinishResetTranslateSettingMessage TextMessage = "finishResetTranslateSettingMessage"
+	FailedResetTranslateSettingMessage TextMessage = "failedResetTranslateSettingMessage"
+	SelectSourceLanguageMessage        TextMessage = "selectSourceLanguageMessage"
+	SelectTargetLanguageMessage        TextMessage = "selectTargetLanguageMessage"
+	ConfirmLanguagePairsMessage        TextMessage = "confirmLanguagePairsMessage"
+	SearchLanguageMessage              TextMessage = "searchLanguageMessage"
+	SearchLanguageResultMessage        TextMessage = "searchLanguageResultMessage"
+	LanguageNotFoundMessage            TextMessage = "languageNotFoundMessage"
+	RecentPairsMessage                 TextMessage = "recentPairsMessage"
+	NoRecentPairsMessage               TextMessage = "noRecentPairsMessage"
+	HistoryMessage                     TextMessage = "historyMessage"
+	HistorySearchResultMessage         TextMessage = "historySearchResultMessage"
+	HistoryEmptyMessage                TextMessage = "historyEmptyMessage"
+	HistoryNoMatchMessage              TextMessage = "historyNoMatchMessage"
+	HistoryPausedMessage               TextMessage = "historyPausedMessage"
+	HistorySearchMessage               TextMessage = "historySearchMessage"
+	ClearHistoryConfirmMessage         TextMessage = "clearHistoryConfirmMessage"
+	TranslationNotActiveMessage        TextMessage = "translationNotActiveMessage"
+	TranslationFailedMessage           TextMessage = "translationFailedMessage"
+	InlineTranslateTitle               TextMessage = "inlineTranslateTitle"
+	PhrasebookMessage                  TextMessage = "phrasebookMessage"
+	PhrasebookEmptyMessage             TextMessage = "phrasebookEmptyMessage"
+	PhrasebookPairMessage              TextMessage = "phrasebookPairMessage"
+	SuggestCorrectionMessage           TextMessage = "suggestCorrectionMessage"
+	CorrectionThanksMessage            TextMessage = "correctionThanksMessage"
+	FeedbackStatsMessage               TextMessage = "feedbackStatsMessage"
+	FeedbackStatsEmptyMessage          TextMessage = "feedbackStatsEmptyMessage"
+	NoPendingCorrectionsMessage        TextMessage = "noPendingCorrectionsMessage"
+	AdminOnlyMessage                   TextMessage = "adminOnlyMessage"
+	OutdatedMenuMessage                TextMessage = "outdatedMenuMessage"
+	OtherUserMenuMessage               TextMessage = "otherUserMenuMessage"
+	PairActivatedMessage               TextMessage = "pairActivatedMessage"
+	PairOverriddenMessage              TextMessage = "pairOverriddenMessage"
+	FeedbackThanksMessage              TextMessage = "feedbackThanksMessage"
+	ConversationExpiredMessage         TextMessage = "conversationExpiredMessage"
+	GlossaryMessage                    TextMessage = "glossaryMessage"
+	GlossaryEmptyMessage               TextMessage = "glossaryEmptyMessage"
+	GlossaryTermMessage                TextMessage = "glossaryTermMessage"
+	GlossaryTranslationMessage         TextMessage = "glossaryTranslationMessage"
+	GlossarySavedMessage               TextMessage = "glossarySavedMessage"
+	GlossaryImportMessage              TextMessage = "glossaryImportMessage"
+	GlossaryImportedMessage            TextMessage = "glossaryImportedMessage"
+	GlossaryImportFailedMessage        TextMessage = "glossaryImportFailedMessage"
+	ThrottledMessage                   TextMessage = "throttledMessage"
+	BanUsageMessage                    TextMessage = "banUsageMessage"
+	UserBannedMessage                  TextMessage = "userBannedMessage"
+	UserUnbannedMessage                TextMessage = "userUnbannedMessage"
+	UserNotBannedMessage               TextMessage = "userNotBannedMessage"
+	UnknownCommandMessage              TextMessage = "unknownCommandMessage"
+	PrivateCommandMessage              TextMessage = "privateCommandMessage"
+	InviteMessage                      TextMessage = "inviteMessage"
+	LinkUsageMessage                   TextMessage = "linkUsageMessage"
+	SettingSourcesMessage              TextMessage = "settingSourcesMessage"
+	BotLanguageLabel                   TextMessage = "botLanguageLabel"
+	LanguagePairLabel                  TextMessage = "languagePairLabel"
+	TranslationLabel                   TextMessage = "translationLabel"
+	SettingOnLabel                     TextMessage = "settingOnLabel"
+	SettingOffLabel                    TextMessage = "settingOffLabel"
+	SettingNotSetLabel                 TextMessage = "settingNotSetLabel"
+	DefaultScopeName                   TextMessage = "defaultScopeName"
+	GlobalScopeName                    TextMessage = "globalScopeName"
+	UserScopeName                      TextMessage = "userScopeName"
+	ChatScopeName                      TextMessage = "chatScopeName"
+	TopicScopeName                     TextMessage = "topicScopeName"
+	InferredScopeName                  TextMessage = "inferredScopeName"
+	UnsupportedLanguageMessage         TextMessage = "unsupportedLanguageMessage"
+	SamePairLanguagesMessage           TextMessage = "samePairLanguagesMessage"
+	DefaultUsageMessage                TextMessage = "defaultUsageMessage"
+	DefaultSavedMessage                TextMessage = "defaultSavedMessage"
+	DefaultClearedMessage              TextMessage = "defaultClearedMessage"
+	ChatAdminOnlyMessage               TextMessage = "chatAdminOnlyMessage"
+	HelpMessage                        TextMessage = "helpMessage"
+	ContactUsMessage                   TextMessage = "contactUsMessage"
+
+	// Descriptions of the commands in the Telegram command menu
+	StartCommandDescription      TextMessage = "startCommandDescription"
+	HelpCommandDescription       TextMessage = "helpCommandDescription"
+	SettingsCommandDescription   TextMessage = "settingsCommandDescription"
+	PairsCommandDescription      TextMessage = "pairsCommandDescription"
+	HistoryCommandDescription    TextMessage = "historyCommandDescription"
+	PhrasebookCommandDescription TextMessage = "phrasebookCommandDescription"
+	GlossaryCommandDescription   TextMessage = "glossaryCommandDescription"
+	FeedbackCommandDescription   TextMessage = "feedbackCommandDescription"
+	BanCommandDescription        TextMessage = "banCommandDescription"
+	UnbanCommandDescription      TextMessage = "unbanCommandDescription"
+	InviteCommandDescription     TextMessage = "inviteCommandDescription"
+	LinkCommandDescription       TextMessage = "linkCommandDescription"
+	DefaultCommandDescription    TextMessage = "defaultCommandDescription"
 
 	// Menu states
 	MenuMain                     MenuState = "main"
@@ -50,75 +163,23 @@ const (
 	MenuContactUs                MenuState = "contactUs"
 
 	// Handler names
-	StartHandler HandlerName = "start"
+	StartHandler          HandlerName = "start"
+	LanguagePairsHandler  HandlerName = "pair"
+	NoopHandler           HandlerName = "noop"
+	PairsHandler          HandlerName = "pairs"
+	RecentPairsHandler    HandlerName = "recent"
+	HistoryHandler        HandlerName = "history"
+	PhrasebookHandler     HandlerName = "phrasebook"
+	FeedbackHandler       HandlerName = "feedback"
+	MenuHandler           HandlerName = "menu"
+	BotLanguageHandler    HandlerName = "lang"
+	GlossaryHandler       HandlerName = "glossary"
+	BanHandler            HandlerName = "ban"
+	UnbanHandler          HandlerName = "unban"
+	HelpHandler           HandlerName = "help"
+	SettingsHandler       HandlerName = "settings"
+	InviteHandler         HandlerName = "invite"
+	LinkHandler           HandlerName = "link"
+	SettingSourcesHandler HandlerName = "sources"
+	DefaultHandler        HandlerName = "default"
 )
-
-// Map of button texts for different languages
-var Buttons = map[Language]map[TextButton]string{
-	LangEN: {
-		KeyTranslaion:              "Translation",
-		KeyContactUs:               "Contact us",
-		KeyHelp:                    "Help",
-		KeySettings:                "Settings",
-		KeyBack:                    "Back",
-		KeySettingsLanguage:        "Language",
-		KeyTranslateSentMessage:    "Translate Sent Message",
-		KeyResetTranslationSetting: "Reset Translation Settings",
-		KeyFinishSetup:             "Finish Setup",
-		KeyResetTranslateYes:       "Yes",
-	},
-	LangFA: {
-		KeyTranslaion:              "ترجمه",
-		KeyHelp:                    "راهنما",
-		KeyContactUs:               "ارتباط با ما",
-		KeySettings:                "تنظیمات",
-		KeyBack:                    "بازگشت",
-		KeySettingsLanguage:        "زبان",
-		KeyTranslateSentMessage:    "ترجمه پیام های ارسالی",
-		KeyResetTranslationSetting: "بازنشانی تنظیمات ترجمه",
-		KeyFinishSetup:             "اتمام تنظیمات",
-		KeyResetTranslateYes:       "بله",
-	},
-}
-
-// Get the text for a button based on the language and button key
-func GetKey(lang Language, button TextButton) string {
-	return Buttons[lang][button]
-}
-
-// Map of messages for different languages
-var Messages = map[Language]map[TextMessage]string{
-	LangEN: {
-		MainMessage:                        "Main Menu",
-		StartMessage:                       "Welcome",
-		SettingMessage:                     "Settings Menu",
-		SettingLanguageMessage:             "Select bot language",
-		ChangeLanguageMessage:              "Your language has been changed to English",
-		FailedChangeLanguageMessage:        "Sorry, there is a problem changing the language",
-		TranslationMenuMessage:             "Please select one of the keys:",
-		SelectLanguagePairsMessage:         "Please separate the languages with the ( - ) symbol without a space",
-		TranslateFinishMessage:             "Please save the translation settings:",
-		TranslateFinishSetupMessage:        "The Translation settings are saved and activated",
-		ResetTranslateSettingMessage:       "Do you want to reset the translation settings for sending messages?",
-		FinishResetTranslateSettingMessage: "Settings reset successfully",
-	},
-	LangFA: {
-		MainMessage:                        "منو اصلی",
-		StartMessage:                       "خوش امدید",
-		SettingMessage:                     "منو تنظیمات",
-		SettingLanguageMessage:             "زبات بات را انتخاب کنید",
-		ChangeLanguageMessage:              "زبان شما به فارسی تغییر یافت",
-		FailedChangeLanguageMessage:        "متاسفانه  مشکلی  برای تغییر زبان برای وجود دارد",
-		TranslationMenuMessage:             "لطفا یکی از کلیدها را انتخاب کنید:",
-		SelectLanguagePairsMessage:         "لطفا زبان های مبدا و مقصد  را با نماد ` - ` بدون فاصله از یکدیگر جدا کنید",
-		TranslateFinishMessage:             "لطفا تنظیمات ترجمه را ذخیره کنید:",
-		TranslateFinishSetupMessage:        "تنظیمات ترجمه ذخیره و فعال شده است",
-		ResetTranslateSettingMessage:       "ایا می خواهید تنظیمات ترجمه برای ارسال پیام را بازنشانی کنید؟",
-		FinishResetTranslateSettingMessage: "تنظیمات با موفقیت بازنشانی شد",
-	},
-}
-
-// Get the menu message based on the language and message key
-func GetMenuMessage(lang Language, message TextMessage) string {
-	return Messages[lang][message]
-}
@@ -0,0 +1,133 @@
+package key
+
+import (
+	"strconv"
+	"strings"
+)
+
+// PluralCategory is a CLDR plural category, the grammatical form a language uses for a number.
+type PluralCategory string
+
+// Plural categories, see https://cldr.unicode.org/index/cldr-spec/plural-rules
+const (
+	Zero  PluralCategory = "zero"
+	One   PluralCategory = "one"
+	Two   PluralCategory = "two"
+	Few   PluralCategory = "few"
+	Many  PluralCategory = "many"
+	Other PluralCategory = "other"
+)
+
+// pluralCategories are the valid plural categories.
+var pluralCategories = map[PluralCategory]bool{Zero: true, One: true, Two: true, Few: true, Many: true, Other: true}
+
+// pluralRules are the CLDR cardinal plural rules of the interface languages, for integers.
+// Languages without a rule use the English one.
+var pluralRules = map[Language]func(n int64) PluralCategory{
+	LangEN: oneOther,
+	"de":   oneOther,
+	"tr":   oneOther,
+
+	// Persian uses the singular for zero and one
+	LangFA: func(n int64) PluralCategory {
+		if n == 0 || n == 1 {
+			return One
+		}
+		return Other
+	},
+
+	"fr": func(n int64) PluralCategory {
+		switch {
+		case n == 0 || n == 1:
+			return One
+		case n%1000000 == 0:
+			return Many
+		}
+		return Other
+	},
+
+	"es": func(n int64) PluralCategory {
+		switch {
+		case n == 1:
+			return One
+		case n != 0 && n%1000000 == 0:
+			return Many
+		}
+		return Other
+	},
+
+	"ar": func(n int64) PluralCategory {
+		switch mod := n % 100; {
+		case n == 0:
+			return Zero
+		case n == 1:
+			return One
+		case n == 2:
+			return Two
+		case mod >= 3 && mod <= 10:
+			return Few
+		case mod >= 11 && mod <= 99:
+			return Many
+		}
+		return Other
+	},
+
+	"ru": func(n int64) PluralCategory {
+		switch mod10, mod100 := n%10, n%100; {
+		case mod10 == 1 && mod100 != 11:
+			return One
+		case mod10 >= 2 && mod10 <= 4 && (mod100 < 12 || mod100 > 14):
+			return Few
+		}
+		return Many
+	},
+}
+
+// oneOther is the plural rule of languages using the singular for one only, such as English.
+
+func oneOther(n int64) PluralCategory {
+	if n == 1 {
+		return One
+	}
+	return Other
+}
+
+// PluralCategoryOf returns the plural category of an integer in a language.
+
+func PluralCategoryOf(lang Language, n int64) PluralCategory {
+	if n < 0 {
+		n = -n
+	}
+	rule, exist := pluralRules[lang]
+	if !exist {
+		rule = oneOther
+	}
+	return rule(n)
+}
+
+// digits are the digits of the languages that don't write numbers with 0-9, from zero to nine.
+var digits = map[Language][]rune{
+	LangFA: []rune("۰۱۲۳۴۵۶۷۸۹"),
+	"ar":   []rune("٠١٢٣٤٥٦٧٨٩"),
+}
+
+// FormatNumber writes an integer in the digits of a language.
+
+func FormatNumber(lang Language, n int64) string {
+	return localizeDigits(lang, strconv.FormatInt(n, 10))
+}
+
+// localizeDigits replaces the digits 0-9 of a number with the digits of a language.
+
+func localizeDigits(lang Language, number string) string {
+	local, exist := digits[lang]
+	if !exist {
+		return number
+	}
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return local[r-'0']
+		}
+		return r
+	}, number)
+}
@@ -0,0 +1,359 @@
+package key
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/mzfarshad/tlg_bot/internal/bidi"
+)
+
+// Messages can be templates in a subset of ICU MessageFormat:
+//
+//	{name}                                             the value of a parameter
+//	{count, plural, =0 {none} one {# item} other {# items}}   the case of the number's plural category
+//	{kind, select, pair {...} other {...}}             the case of the parameter's value
+//
+// In a plural case # is the number. Numbers are written in the digits of the language, and text values
+// written in the other direction than the language, such as English words in a Persian message, are isolated.
+// Quote { } and # with apostrophes, as in '{', and write '' for an apostrophe next to them;
+// other apostrophes, as in l'historique, are kept.
+
+// Params are the named values of a message template, such as {"count": 3}.
+type Params map[string]any
+
+// template is a parsed message, a sequence of parts.
+type template []part
+
+// part is a piece of a template.
+type part interface {
+	format(b *strings.Builder, lang Language, params Params, number any)
+}
+
+// literal is text written as is.
+type literal string
+
+// argument writes the value of a parameter.
+type argument string
+
+// hash writes the number of the enclosing plural case.
+type hash struct{}
+
+// choice writes the case of a plural or select argument matching the value of its parameter.
+type choice struct {
+	name   string
+	plural bool
+	cases  map[string]template // By =N, plural category or select value; "other" is required
+}
+
+// templates caches the parsed templates by their text.
+var templates sync.Map
+
+// Format returns a message in the given language, or the fallback language if the language has no text for it,
+// with its template filled in with the parameters.
+
+func Format(lang Language, message TextMessage, params Params) string {
+	return FormatText(lang, GetMenuMessage(lang, message), params)
+}
+
+// FormatText fills in a template with the parameters, writing numbers and plurals for the language.
+// A template that can't be parsed is logged and returned as is.
+
+func FormatText(lang Language, text string, params Params) string {
+
+	var parsed template
+	if cached, exist := templates.Load(text); exist {
+		parsed = cached.(template)
+	} else {
+		var err error
+		if parsed, err = parseTemplate(text); err != nil {
+			log.Printf("invalid message template %q: %v", text, err)
+			return text
+		}
+		templates.Store(text, parsed)
+	}
+
+	var b strings.Builder
+	parsed.format(&b, lang, params, nil)
+	return b.String()
+}
+
+// TemplateParams returns the names of the parameters a template uses, or an error if it can't be parsed.
+
+func TemplateParams(text string) (map[string]bool, error) {
+	parsed, err := parseTemplate(text)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool)
+	parsed.params(names)
+	return names, nil
+}
+
+func (t template) format(b *strings.Builder, lang Language, params Params, number any) {
+	for _, part := range t {
+		part.format(b, lang, params, number)
+	}
+}
+
+// params adds the names of the parameters of the template.
+
+func (t template) params(names map[string]bool) {
+	for _, part := range t {
+		switch part := part.(type) {
+		case argument:
+			names[string(part)] = true
+		case *choice:
+			names[part.name] = true
+			for _, c := range part.cases {
+				c.params(names)
+			}
+		}
+	}
+}
+
+func (l literal) format(b *strings.Builder, lang Language, params Params, number any) {
+	b.WriteString(string(l))
+}
+
+func (a argument) format(b *strings.Builder, lang Language, params Params, number any) {
+	value, exist := params[string(a)]
+	if !exist {
+		// A missing parameter stays visible rather than silently disappearing
+		b.WriteString("{" + string(a) + "}")
+		return
+	}
+	if text, ok := value.(string); ok {
+		// Keep the punctuation around text of the other direction in place
+		b.WriteString(bidi.IsolateIn(bidi.LanguageDirection(string(lang)), text))
+		return
+	}
+	b.WriteString(formatValue(lang, value))
+}
+
+func (hash) format(b *strings.Builder, lang Language, params Params, number any) {
+	b.WriteString(formatValue(lang, number))
+}
+
+func (c *choice) format(b *strings.Builder, lang Language, params Params, number any) {
+
+	value := params[c.name]
+	selected := c.cases["other"]
+	if c.plural {
+		if n, ok := integer(value); ok {
+			if exact, found := c.cases["="+strconv.FormatInt(n, 10)]; found {
+				selected = exact
+			} else if category, found := c.cases[string(PluralCategoryOf(lang, n))]; found {
+				selected = category
+			}
+		}
+		number = value
+	} else if matched, found := c.cases[fmt.Sprint(value)]; found {
+		selected = matched
+	}
+	selected.format(b, lang, params, number)
+}
+
+// formatValue writes the value of a parameter, numbers in the digits of the language.
+
+func formatValue(lang Language, value any) string {
+	if n, ok := integer(value); ok {
+		return FormatNumber(lang, n)
+	}
+	switch value := value.(type) {
+	case string:
+		return value
+	case float64:
+		return localizeDigits(lang, strconv.FormatFloat(value, 'f', -1, 64))
+	case float32:
+		return localizeDigits(lang, strconv.FormatFloat(float64(value), 'f', -1, 32))
+	}
+	return fmt.Sprint(value)
+}
+
+// integer returns the value of an integer parameter.
+
+func integer(value any) (int64, bool) {
+	switch value := value.(type) {
+	case int:
+		return int64(value), true
+	case int8:
+		return int64(value), true
+	case int16:
+		return int64(value), true
+	case int32:
+		return int64(value), true
+	case int64:
+		return value, true
+	case uint:
+		return int64(value), true
+	case uint8:
+		return int64(value), true
+	case uint16:
+		return int64(value), true
+	case uint32:
+		return int64(value), true
+	case uint64:
+		return int64(value), true
+	}
+	return 0, false
+}
+
+// templateParser parses the text of a template.
+
+type templateParser struct {
+	text []rune
+	pos  int
+}
+
+// parseTemplate parses the text of a message template.
+
+func parseTemplate(text string) (template, error) {
+	p := &templateParser{text: []rune(text)}
+	parsed, err := p.template(false)
+	if err == nil && p.pos < len(p.text) {
+		err = p.errorf("unexpected }")
+	}
+	return parsed, err
+}
+
+// template parses text up to the } closing a case, or the end of the text.
+// Inside a plural case # stands for the number.
+
+func (p *templateParser) template(inPlural bool) (template, error) {
+
+	var parsed template
+	var text strings.Builder
+	flush := func() {
+		if text.Len() > 0 {
+			parsed = append(parsed, literal(text.String()))
+			text.Reset()
+		}
+	}
+
+	for p.pos < len(p.text) {
+		r := p.text[p.pos]
+		switch {
+		case r == '}':
+			flush()
+			return parsed, nil
+
+		case r == '{':
+			flush()
+			p.pos++
+			arg, err := p.argument()
+			if err != nil {
+				return nil, err
+			}
+			parsed = append(parsed, arg)
+
+		case r == '#' && inPlural:
+			flush()
+			parsed = append(parsed, hash{})
+			p.pos++
+
+		case r == '\'' && p.pos+1 < len(p.text) && p.text[p.pos+1] == '\'':
+			text.WriteRune('\'')
+			p.pos += 2
+
+		case r == '\'' && p.pos+1 < len(p.text) && strings.ContainsRune("{}#", p.text[p.pos+1]):
+			// Quoted text up to the next apostrophe
+			end := p.pos + 1
+			for end < len(p.text) && p.text[end] != '\'' {
+				end++
+			}
+			text.WriteString(string(p.text[p.pos+1 : end]))
+			p.pos = end + 1
+
+		default:
+			text.WriteRune(r)
+			p.pos++
+		}
+	}
+	flush()
+	return parsed, nil
+}
+
+// argument parses an argument after its opening {, up to and including its closing }.
+
+func (p *templateParser) argument() (part, error) {
+
+	name, end := p.word(",}")
+	if name == "" {
+		return nil, p.errorf("argument without a name")
+	}
+	if end == '}' {
+		return argument(name), nil
+	}
+
+	kind, end := p.word(",}")
+	if end != ',' || (kind != "plural" && kind != "select") {
+		return nil, p.errorf("argument %s: unknown type %q, expected plural or select", name, kind)
+	}
+
+	c := &choice{name: name, plural: kind == "plural", cases: make(map[string]template)}
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.text) {
+			return nil, p.errorf("argument %s is not closed", name)
+		}
+		if p.text[p.pos] == '}' {
+			p.pos++
+			break
+		}
+
+		selector, open := p.word("{")
+		if open != '{' || selector == "" {
+			return nil, p.errorf("argument %s: expected a case and {", name)
+		}
+		if c.plural && !strings.HasPrefix(selector, "=") && !pluralCategories[PluralCategory(selector)] {
+			return nil, p.errorf("argument %s: unknown plural category %q", name, selector)
+		}
+		cased, err := p.template(c.plural)
+		if err != nil {
+			return nil, err
+		}
+		if p.pos >= len(p.text) {
+			return nil, p.errorf("case %s of argument %s is not closed", selector, name)
+		}
+		p.pos++
+		c.cases[selector] = cased
+	}
+
+	if _, exist := c.cases["other"]; !exist {
+		return nil, p.errorf("argument %s has no other case", name)
+	}
+	return c, nil
+}
+
+// word reads text up to one of the stop runes, which it consumes and returns, and trims it.
+// It returns a zero rune at the end of the text.
+
+func (p *templateParser) word(stops string) (string, rune) {
+	start := p.pos
+	for p.pos < len(p.text) {
+		r := p.text[p.pos]
+		if strings.ContainsRune(stops, r) {
+			p.pos++
+			return strings.TrimSpace(string(p.text[start : p.pos-1])), r
+		}
+		p.pos++
+	}
+	return strings.TrimSpace(string(p.text[start:])), 0
+}
+
+// skipSpace moves past white space.
+
+func (p *templateParser) skipSpace() {
+	for p.pos < len(p.text) && strings.ContainsRune(" \t\n", p.text[p.pos]) {
+		p.pos++
+	}
+}
+
+// errorf returns a parse error at the current position.
+
+func (p *templateParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("at %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
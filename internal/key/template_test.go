@@ -0,0 +1,83 @@
+package key
+
+import (
+	"testing"
+)
+
+// TestPluralCategories checks the plural rules against examples of the CLDR rules.
+
+func TestPluralCategories(t *testing.T) {
+	tests := []struct {
+		lang Language
+		want map[int64]PluralCategory
+	}{
+		{LangEN, map[int64]PluralCategory{0: Other, 1: One, 2: Other, 11: Other}},
+		{LangFA, map[int64]PluralCategory{0: One, 1: One, 2: Other, 21: Other}},
+		{"ar", map[int64]PluralCategory{0: Zero, 1: One, 2: Two, 3: Few, 10: Few, 11: Many, 99: Many, 100: Other, 102: Other, 103: Few, 111: Many}},
+		{"ru", map[int64]PluralCategory{1: One, 2: Few, 4: Few, 5: Many, 11: Many, 12: Many, 21: One, 22: Few, 111: Many, 0: Many}},
+		{"fr", map[int64]PluralCategory{0: One, 1: One, 2: Other, 1000000: Many}},
+		{"es", map[int64]PluralCategory{0: Other, 1: One, 2: Other, 2000000: Many}},
+	}
+	for _, test := range tests {
+		for n, want := range test.want {
+			if got := PluralCategoryOf(test.lang, n); got != want {
+				t.Errorf("PluralCategoryOf(%s, %d) = %s, want %s", test.lang, n, got, want)
+			}
+		}
+	}
+}
+
+// TestFormatText checks arguments, plural and select cases, quoting and the digits of each language.
+
+func TestFormatText(t *testing.T) {
+	const plural = "{count, plural, =0 {none} one {# item} two {# items (two)} few {# items (few)} many {# items (many)} other {# items}}"
+	tests := []struct {
+		lang   Language
+		text   string
+		params Params
+		want   string
+	}{
+		{LangEN, "Hello {name}", Params{"name": "Ali"}, "Hello Ali"},
+		{LangEN, "Hello {name}", nil, "Hello {name}"},
+		{LangEN, plural, Params{"count": 0}, "none"},
+		{LangEN, plural, Params{"count": 1}, "1 item"},
+		{LangEN, plural, Params{"count": 5}, "5 items"},
+		{LangFA, plural, Params{"count": 1}, "۱ item"},
+		{LangFA, plural, Params{"count": 25}, "۲۵ items"},
+		{"ar", plural, Params{"count": 2}, "٢ items (two)"},
+		{"ar", plural, Params{"count": 7}, "٧ items (few)"},
+		{"ar", plural, Params{"count": 12}, "١٢ items (many)"},
+		{"ar", plural, Params{"count": 100}, "١٠٠ items"},
+		{"ru", plural, Params{"count": 3}, "3 items (few)"},
+		{"ru", plural, Params{"count": 5}, "5 items (many)"},
+		{LangFA, "page {page} of {pages}", Params{"page": 2, "pages": int64(10)}, "page ۲ of ۱۰"},
+		{LangFA, "user {id}", Params{"id": "123"}, "user 123"},
+		{LangEN, "{kind, select, pair {a pair} other {something}}", Params{"kind": "pair"}, "a pair"},
+		{LangEN, "{kind, select, pair {a pair} other {something}}", Params{"kind": "x"}, "something"},
+		{LangEN, "'{literal}' l'historique '#' it''s", nil, "{literal} l'historique # it's"},
+		{LangEN, "{count, plural, other {'#' #}}", Params{"count": 3}, "# 3"},
+	}
+	for _, test := range tests {
+		if got := FormatText(test.lang, test.text, test.params); got != test.want {
+			t.Errorf("FormatText(%s, %q) = %q, want %q", test.lang, test.text, got, test.want)
+		}
+	}
+}
+
+// TestInvalidTemplates checks that malformed templates are rejected.
+
+func TestInvalidTemplates(t *testing.T) {
+	for _, text := range []string{
+		"{",
+		"{}",
+		"text }",
+		"{count, plural, one {# item}}",
+		"{count, plural, several {x} other {y}}",
+		"{count, number}",
+		"{count, plural, other {x}",
+	} {
+		if _, err := TemplateParams(text); err == nil {
+			t.Errorf("TemplateParams(%q) returned no error", text)
+		}
+	}
+}
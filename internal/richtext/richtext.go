@@ -0,0 +1,222 @@
+// Package richtext builds formatted Telegram messages in HTML or MarkdownV2.
+//
+// Every text added to a Builder is escaped for the parse mode, so user-supplied and translated text
+// can't break the formatting. The builder writes the same message without formatting along the way,
+// which is sent instead when Telegram rejects the formatted one.
+package richtext
+
+import (
+	"html"
+	"strings"
+)
+
+// Mode is a Telegram parse mode.
+type Mode string
+
+// Parse modes, see https://core.telegram.org/bots/api#formatting-options
+const (
+	Plain      Mode = ""
+	HTML       Mode = "HTML"
+	MarkdownV2 Mode = "MarkdownV2"
+)
+
+var (
+	// markdownEscaper escapes the characters reserved in MarkdownV2 text.
+	markdownEscaper = strings.NewReplacer(
+		`\`, `\\`, "_", `\_`, "*", `\*`, "[", `\[`, "]", `\]`, "(", `\(`, ")", `\)`, "~", `\~`, "`", "\\`",
+		">", `\>`, "#", `\#`, "+", `\+`, "-", `\-`, "=", `\=`, "|", `\|`, "{", `\{`, "}", `\}`, ".", `\.`, "!", `\!`,
+	)
+
+	// markdownCodeEscaper escapes the characters reserved in MarkdownV2 code and pre entities.
+	markdownCodeEscaper = strings.NewReplacer(`\`, `\\`, "`", "\\`")
+
+	// markdownURLEscaper escapes the characters reserved in the URL of a MarkdownV2 link.
+	markdownURLEscaper = strings.NewReplacer(`\`, `\\`, ")", `\)`)
+)
+
+// Escape escapes text for a parse mode.
+
+func Escape(mode Mode, text string) string {
+	switch mode {
+	case HTML:
+		return html.EscapeString(text)
+	case MarkdownV2:
+		return markdownEscaper.Replace(text)
+	}
+	return text
+}
+
+// Message is a message text in a parse mode, with its text without formatting.
+
+type Message struct {
+	Text  string // Text in the parse mode
+	Mode  Mode   // Parse mode of the text, Plain for text without formatting
+	Plain string // Same text without formatting, sent when Telegram can't parse the text
+}
+
+// PlainText returns a message without formatting.
+
+func PlainText(text string) Message {
+	return Message{Text: text, Plain: text}
+}
+
+// IsZero reports whether the message has no text.
+
+func (m Message) IsZero() bool {
+	return m.Text == ""
+}
+
+// Builder writes a message in a parse mode piece by piece.
+
+type Builder struct {
+	mode  Mode
+	text  strings.Builder
+	plain strings.Builder
+}
+
+// New returns a builder writing in the parse mode.
+
+func New(mode Mode) *Builder {
+	return &Builder{mode: mode}
+}
+
+// Text adds text without formatting.
+
+func (b *Builder) Text(text string) *Builder {
+	b.text.WriteString(Escape(b.mode, text))
+	b.plain.WriteString(text)
+	return b
+}
+
+// Line adds a line break.
+
+func (b *Builder) Line() *Builder {
+	return b.Text("\n")
+}
+
+// Bold adds bold text, such as a label.
+
+func (b *Builder) Bold(text string) *Builder {
+	return b.entity(text, "<b>", "</b>", "*", "*")
+}
+
+// Italic adds italic text.
+
+func (b *Builder) Italic(text string) *Builder {
+	return b.entity(text, "<i>", "</i>", "_", "_")
+}
+
+// Code adds inline monospace text.
+
+func (b *Builder) Code(text string) *Builder {
+	if text == "" {
+		return b
+	}
+	switch b.mode {
+	case HTML:
+		b.text.WriteString("<code>" + html.EscapeString(text) + "</code>")
+	case MarkdownV2:
+		b.text.WriteString("`" + markdownCodeEscaper.Replace(text) + "`")
+	default:
+		b.text.WriteString(text)
+	}
+	b.plain.WriteString(text)
+	return b
+}
+
+// Pre adds a block of preformatted text, highlighted as the programming language if one is given.
+
+func (b *Builder) Pre(language, text string) *Builder {
+	if text == "" {
+		return b
+	}
+	switch b.mode {
+	case HTML:
+		if language != "" {
+			b.text.WriteString(`<pre><code class="language-` + html.EscapeString(language) + `">` +
+				html.EscapeString(text) + "</code></pre>")
+		} else {
+			b.text.WriteString("<pre>" + html.EscapeString(text) + "</pre>")
+		}
+	case MarkdownV2:
+		b.text.WriteString("```" + markdownCodeEscaper.Replace(language) + "\n" + markdownCodeEscaper.Replace(text) + "\n```")
+	default:
+		b.text.WriteString(text)
+	}
+	b.plain.WriteString(text)
+	return b
+}
+
+// Quote adds a block quotation, such as the original of a translation. The quotation ends its line.
+
+func (b *Builder) Quote(text string) *Builder {
+	if text == "" {
+		return b
+	}
+	switch b.mode {
+	case HTML:
+		b.text.WriteString("<blockquote>" + html.EscapeString(text) + "</blockquote>\n")
+	case MarkdownV2:
+		lines := strings.Split(text, "\n")
+		for i, line := range lines {
+			lines[i] = ">" + markdownEscaper.Replace(line)
+		}
+		b.text.WriteString(strings.Join(lines, "\n") + "\n")
+	default:
+		b.text.WriteString(text + "\n")
+	}
+	b.plain.WriteString(text + "\n")
+	return b
+}
+
+// Link adds a link with the text, or the URL itself if the text is empty.
+// Without formatting the URL follows the text in parentheses.
+
+func (b *Builder) Link(text, url string) *Builder {
+	plain := url
+	if text == "" {
+		text = url
+	} else if text != url {
+		plain = text + " (" + url + ")"
+	}
+	switch b.mode {
+	case HTML:
+		b.text.WriteString(`<a href="` + html.EscapeString(url) + `">` + html.EscapeString(text) + "</a>")
+	case MarkdownV2:
+		b.text.WriteString("[" + markdownEscaper.Replace(text) + "](" + markdownURLEscaper.Replace(url) + ")")
+	default:
+		b.text.WriteString(plain)
+	}
+	b.plain.WriteString(plain)
+	return b
+}
+
+// entity adds text between the opening and closing tags of the parse mode.
+
+func (b *Builder) entity(text, openHTML, closeHTML, openMarkdown, closeMarkdown string) *Builder {
+	if text == "" {
+		return b
+	}
+	switch b.mode {
+	case HTML:
+		b.text.WriteString(openHTML + html.EscapeString(text) + closeHTML)
+	case MarkdownV2:
+		b.text.WriteString(openMarkdown + markdownEscaper.Replace(text) + closeMarkdown)
+	default:
+		b.text.WriteString(text)
+	}
+	b.plain.WriteString(text)
+	return b
+}
+
+// Len returns the length of the text without formatting, in bytes.
+
+func (b *Builder) Len() int {
+	return b.plain.Len()
+}
+
+// Message returns the message built so far.
+
+func (b *Builder) Message() Message {
+	return Message{Text: b.text.String(), Mode: b.mode, Plain: b.plain.String()}
+}
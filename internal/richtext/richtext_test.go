@@ -0,0 +1,32 @@
+package richtext
+
+import "testing"
+
+func TestBuilder(t *testing.T) {
+	build := func(mode Mode) Message {
+		return New(mode).Bold("English:").Text(" 1 < 2 & a_b*c.").Line().
+			Quote("x > y\n(z)").Code("a`b").Text(" ").Link("docs!", "https://example.com/a_(b)").Message()
+	}
+
+	html := build(HTML)
+	if want := "<b>English:</b> 1 &lt; 2 &amp; a_b*c.\n<blockquote>x &gt; y\n(z)</blockquote>\n<code>a`b</code> " +
+		`<a href="https://example.com/a_(b)">docs!</a>`; html.Text != want {
+		t.Errorf("HTML text = %q, want %q", html.Text, want)
+	}
+
+	markdown := build(MarkdownV2)
+	if want := "*English:* 1 < 2 & a\\_b\\*c\\.\n>x \\> y\n>\\(z\\)\n`a\\`b` [docs\\!](https://example.com/a_(b\\))"; markdown.Text != want {
+		t.Errorf("MarkdownV2 text = %q, want %q", markdown.Text, want)
+	}
+
+	plain := build(Plain)
+	want := "English: 1 < 2 & a_b*c.\nx > y\n(z)\na`b docs! (https://example.com/a_(b))"
+	for _, message := range []Message{html, markdown, plain} {
+		if message.Plain != want {
+			t.Errorf("%s plain text = %q, want %q", message.Mode, message.Plain, want)
+		}
+	}
+	if plain.Text != want || plain.Mode != Plain {
+		t.Errorf("plain message = %+v", plain)
+	}
+}
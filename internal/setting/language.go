@@ -2,21 +2,84 @@ package setting
 
 import (
 	"fmt"
+	"strconv"
 
 	"github.com/mzfarshad/tlg_bot/internal/key"
 	"github.com/mzfarshad/tlg_bot/internal/storange"
 )
 
-// BotLanguage retrieves the bot's language setting for a specific user and chat.
-// If no language is set in the database, it defaults to English.
+// BotLanguage retrieves the bot's language for a target, resolved through the setting scopes.
+// If no scope defines a known language, it defaults to English.
 
-func BotLanguage(userID int, chatID int64) (key.Language, error) {
-	lang, err := storange.GetBotLanguage(userID, chatID)
+func BotLanguage(target Target) (key.Language, Scope, error) {
+	values, err := Resolve(target, BotLanguageSetting)
 	if err != nil {
-		return "", fmt.Errorf("failed to get language from db: %v", err)
+		return key.LangEN, Default, fmt.Errorf("failed to get language from db: %v", err)
 	}
-	if lang == "" {
-		return key.LangEN, nil
+	value, exist := values[BotLanguageSetting]
+	if !exist || !key.Supported(key.Language(value.Value)) {
+		return key.LangEN, Default, nil
 	}
-	return key.Language(lang), nil
+	return key.Language(value.Value), value.Scope, nil
+}
+
+// SaveBotLanguage sets the bot's language in a scope of the target.
+// In the user scope it is the user's explicit choice, which InferBotLanguage never overrides.
+
+func SaveBotLanguage(scope Scope, target Target, lang key.Language) error {
+	values := map[string]string{BotLanguageSetting: string(lang)}
+	if scope == User {
+		values[LanguageInferredSetting] = strconv.FormatBool(false)
+	}
+	return Save(scope, target, values)
+}
+
+// InferBotLanguage sets the user's bot language to the interface language closest to the language code
+// their Telegram client reports, and records that it was inferred. It does nothing once the user chose
+// a language, and updates an inferred language when the client reports another one.
+// It reports whether the language was saved.
+
+func InferBotLanguage(userID int, languageCode string) (bool, error) {
+
+	if languageCode == "" {
+		return false, nil
+	}
+
+	target := ForUser(userID)
+	stored, err := storange.GetSettings([]storange.SettingScope{target.key(User)},
+		[]string{BotLanguageSetting, LanguageInferredSetting})
+	if err != nil {
+		return false, fmt.Errorf("failed to get language from db: %v", err)
+	}
+	values := make(map[string]string, len(stored))
+	for _, value := range stored {
+		values[value.Name] = value.Value
+	}
+
+	current, exist := values[BotLanguageSetting]
+	if inferred, _ := strconv.ParseBool(values[LanguageInferredSetting]); exist && !inferred {
+		return false, nil
+	}
+
+	lang, _ := key.Closest(languageCode)
+	if current == string(lang) {
+		return false, nil
+	}
+	err = Save(User, target, map[string]string{
+		BotLanguageSetting:      string(lang),
+		LanguageInferredSetting: strconv.FormatBool(true),
+	})
+	return err == nil, err
+}
+
+// LanguageInferred reports whether the user's bot language was inferred from their Telegram client
+// rather than chosen.
+
+func LanguageInferred(userID int) (bool, error) {
+	values, err := storange.GetSettings([]storange.SettingScope{ForUser(userID).key(User)},
+		[]string{LanguageInferredSetting})
+	if err != nil || len(values) == 0 {
+		return false, err
+	}
+	return strconv.ParseBool(values[0].Value)
 }
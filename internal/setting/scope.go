@@ -0,0 +1,154 @@
+package setting
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/mzfarshad/tlg_bot/internal/storange"
+)
+
+// Scope is where the value of a setting is defined.
+type Scope string
+
+// Scopes of the settings, from the most general to the most specific
+const (
+	Default Scope = "default" // Built-in value, when no scope defines the setting
+	Global  Scope = "global"  // All users and chats
+	User    Scope = "user"    // One user, in every chat
+	Chat    Scope = "chat"    // Everyone in one group chat
+	Topic   Scope = "topic"   // Everyone in one forum topic of a group
+)
+
+// Names of the settings
+const (
+	BotLanguageSetting       = "bot_language"
+	LanguageInferredSetting  = "bot_language_inferred" // Whether the user's bot language was inferred rather than chosen
+	SourceLanguageSetting    = "source_language"
+	TargetLanguageSetting    = "target_language"
+	SentMessageSetting       = "sent_message"
+	ActiveTranslationSetting = "active_translation"
+)
+
+// Target is the user and place a setting is looked up for.
+
+type Target struct {
+	UserID   int   // Zero if the setting doesn't depend on a user
+	ChatID   int64 // Zero outside a chat, such as for inline queries
+	ThreadID int   // Forum topic of the chat, zero outside a topic
+}
+
+// ForUser returns the target of a user's own settings, outside any chat.
+
+func ForUser(userID int) Target {
+	return Target{UserID: userID}
+}
+
+// Value is the effective value of a setting and the scope it comes from.
+
+type Value struct {
+	Value string
+	Scope Scope
+}
+
+// key returns the stored scope of the target for a kind of scope.
+
+func (t Target) key(scope Scope) storange.SettingScope {
+	switch scope {
+	case User:
+		return storange.SettingScope{Scope: string(User), ID: strconv.Itoa(t.UserID)}
+	case Chat:
+		return storange.SettingScope{Scope: string(Chat), ID: strconv.FormatInt(t.ChatID, 10)}
+	case Topic:
+		return storange.SettingScope{Scope: string(Topic), ID: fmt.Sprintf("%d:%d", t.ChatID, t.ThreadID)}
+	}
+	return storange.SettingScope{Scope: string(Global)}
+}
+
+// Chain returns the scopes a setting of the target is looked up in, the first defining it wins:
+// the forum topic, the group chat, the user and finally the global settings.
+// A private chat is the user's own chat, so it has no scope of its own.
+
+func (t Target) Chain() []Scope {
+	var chain []Scope
+	if t.ChatID != 0 && t.ThreadID != 0 {
+		chain = append(chain, Topic)
+	}
+	if t.ChatID != 0 && t.ChatID != int64(t.UserID) {
+		chain = append(chain, Chat)
+	}
+	if t.UserID != 0 {
+		chain = append(chain, User)
+	}
+	return append(chain, Global)
+}
+
+// Resolve looks up the effective values of the named settings for the target.
+// Settings no scope defines are missing from the result.
+
+func Resolve(target Target, names ...string) (map[string]Value, error) {
+
+	chain := target.Chain()
+	keys := make([]storange.SettingScope, 0, len(chain))
+	for _, scope := range chain {
+		keys = append(keys, target.key(scope))
+	}
+
+	stored, err := storange.GetSettings(keys, names)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep the value of the most specific scope
+	rank := make(map[Scope]int, len(chain))
+	for i, scope := range chain {
+		rank[scope] = i
+	}
+	values := make(map[string]Value, len(names))
+	for _, setting := range stored {
+		scope := Scope(setting.Scope)
+		if current, exist := values[setting.Name]; !exist || rank[scope] < rank[current.Scope] {
+			values[setting.Name] = Value{Value: setting.Value, Scope: scope}
+		}
+	}
+	return values, nil
+}
+
+// Save defines settings in a scope of the target.
+
+func Save(scope Scope, target Target, values map[string]string) error {
+	return storange.SaveSettings(target.key(scope), values)
+}
+
+// HasOwnSettings reports whether the user defined settings of their own. A bot language inferred from
+// their Telegram client isn't their choice, so a user new to the bot has none.
+
+func HasOwnSettings(userID int) (bool, error) {
+
+	names := append([]string{BotLanguageSetting, LanguageInferredSetting}, translationSettings...)
+	stored, err := storange.GetSettings([]storange.SettingScope{ForUser(userID).key(User)}, names)
+	if err != nil {
+		return false, fmt.Errorf("failed to get settings from db: %v", err)
+	}
+
+	inferred := false
+	for _, value := range stored {
+		if value.Name == LanguageInferredSetting {
+			inferred, _ = strconv.ParseBool(value.Value)
+		}
+	}
+	for _, value := range stored {
+		if value.Name == LanguageInferredSetting || value.Name == BotLanguageSetting && inferred {
+			continue
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// Clear removes settings from a scope of the target, so their values are inherited again.
+// It reports whether the scope defined any of them.
+
+func Clear(scope Scope, target Target, names ...string) (bool, error) {
+	n, err := storange.DeleteSettings(target.key(scope), names...)
+	return n > 0, err
+}
@@ -0,0 +1,87 @@
+package setting
+
+import (
+	"strconv"
+)
+
+// translationSettings are the names of the settings of message translation.
+var translationSettings = []string{
+	SourceLanguageSetting, TargetLanguageSetting, SentMessageSetting, ActiveTranslationSetting,
+}
+
+// TranslationSetting holds the effective translation settings of a target.
+
+type TranslationSetting struct {
+	SourceLanguage    string
+	TargetLanguage    string
+	SentMessage       bool
+	ActiveTranslation bool
+	Sources           map[string]Scope // Scope each setting comes from, by setting name
+}
+
+// GetTranslationSetting retrieves the translation settings of a target, resolved through the setting scopes.
+// Settings no scope defines keep their zero value and come from the Default scope.
+
+func GetTranslationSetting(target Target) (*TranslationSetting, error) {
+
+	values, err := Resolve(target, translationSettings...)
+	if err != nil {
+		return nil, err
+	}
+
+	setting := &TranslationSetting{Sources: make(map[string]Scope, len(translationSettings))}
+	for _, name := range translationSettings {
+		setting.Sources[name] = Default
+		if value, exist := values[name]; exist {
+			setting.Sources[name] = value.Scope
+		}
+	}
+	setting.SourceLanguage = values[SourceLanguageSetting].Value
+	setting.TargetLanguage = values[TargetLanguageSetting].Value
+	setting.SentMessage, _ = strconv.ParseBool(values[SentMessageSetting].Value)
+	setting.ActiveTranslation, _ = strconv.ParseBool(values[ActiveTranslationSetting].Value)
+	return setting, nil
+}
+
+// StartTranslationSetup turns on translation of sent messages in a scope of the target,
+// inactive until a language pair is chosen.
+
+func StartTranslationSetup(scope Scope, target Target) error {
+	return Save(scope, target, map[string]string{
+		SentMessageSetting:       strconv.FormatBool(true),
+		ActiveTranslationSetting: strconv.FormatBool(false),
+	})
+}
+
+// SaveLanguagePairs saves the language pair in a scope of the target without activating it.
+
+func SaveLanguagePairs(scope Scope, target Target, sourceLang, targetLang string) error {
+	return Save(scope, target, map[string]string{
+		SourceLanguageSetting: sourceLang,
+		TargetLanguageSetting: targetLang,
+	})
+}
+
+// ActivateTranslation turns translation with the saved language pair on or off in a scope of the target.
+
+func ActivateTranslation(scope Scope, target Target, active bool) error {
+	return Save(scope, target, map[string]string{ActiveTranslationSetting: strconv.FormatBool(active)})
+}
+
+// ActivateLanguagePairs saves the language pair and activates translation in one step.
+
+func ActivateLanguagePairs(scope Scope, target Target, sourceLang, targetLang string) error {
+	return Save(scope, target, map[string]string{
+		SourceLanguageSetting:    sourceLang,
+		TargetLanguageSetting:    targetLang,
+		SentMessageSetting:       strconv.FormatBool(true),
+		ActiveTranslationSetting: strconv.FormatBool(true),
+	})
+}
+
+// ResetTranslationSettings removes the translation settings of a scope, so the inherited ones apply again.
+// It reports whether the scope had any.
+
+func ResetTranslationSettings(scope Scope, target Target) (bool, error) {
+	return Clear(scope, target, translationSettings...)
+}
@@ -0,0 +1,43 @@
+package storange
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// BanUser bans a user from the bot, replacing the reason of an existing ban.
+
+func BanUser(userID int, reason string, bannedBy int) error {
+	_, err := db.Exec(`INSERT OR REPLACE INTO ban (user_id, reason, banned_by, created_at)
+	 VALUES (?, ?, ?, ?)`, userID, reason, bannedBy, time.Now().Unix())
+	if err != nil {
+		return fmt.Errorf("failed to ban user in db: %v", err)
+	}
+	return nil
+}
+
+// UnbanUser lifts the ban of a user. It reports whether the user was banned.
+
+func UnbanUser(userID int) (bool, error) {
+	result, err := db.Exec(`DELETE FROM ban WHERE user_id = ?`, userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to unban user in db: %v", err)
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// IsBanned reports whether the user is banned from the bot.
+
+func IsBanned(userID int) (bool, error) {
+	var id int
+	err := db.QueryRow(`SELECT user_id FROM ban WHERE user_id = ?`, userID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to check user ban in db: %v", err)
+	}
+	return true, nil
+}
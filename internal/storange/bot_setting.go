@@ -2,70 +2,136 @@ package storange
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"strings"
+	"time"
 )
 
-// SaveBotLanguage saves or updates the bot's language setting for a user and chat.
+// ErrMenuStateConflict is returned when the menu state kept changing while it was being updated.
+var ErrMenuStateConflict = errors.New("menu state changed concurrently")
 
-func SaveBotLanguage(chatID int64, userID int, language string) error {
-	_, err := db.Exec(`INSERT OR REPLACE INTO bot_setting (user_id, chat_id, bot_language)
-		VALUES (?, ?, ?)`, userID, chatID, language)
+// menuStateRetries is the number of times an update of the menu state is tried before giving up.
+const menuStateRetries = 5
+
+// loadMenuState retrieves the menu state for a user and chat with its version.
+// The version is 0 if there is no state; a state not updated within ttl is returned empty, with its version.
+
+func loadMenuState(userID int, chatID int64, ttl time.Duration) ([]string, int64, error) {
+	var stateStr string
+	var version, updatedAt int64
+	err := db.QueryRow(`SELECT state, version, updated_at FROM menu_state WHERE user_id = ? AND chat_id = ?`,
+		userID, chatID).Scan(&stateStr, &version, &updatedAt)
+	if err == sql.ErrNoRows {
+		return nil, 0, nil
+	}
 	if err != nil {
-		return fmt.Errorf("failed to saving bot language in db:%v", err)
+		return nil, 0, fmt.Errorf("failed to get state menu in db: %v", err)
 	}
-	return nil
+	if stateStr == "" || time.Since(time.Unix(updatedAt, 0)) > ttl {
+		return nil, version, nil
+	}
+	return strings.Split(stateStr, "/"), version, nil
 }
 
-// GetBotLanguage retrieves the bot's language setting for a user and chat.
+// GetMenuState retrieves the menu state for a user and chat. A state not updated within ttl counts as empty.
 
-func GetBotLanguage(userID int, chatID int64) (string, error) {
-	var lang string
-	err := db.QueryRow(`SELECT bot_language FROM bot_setting WHERE user_id = ? AND chat_id = ?`,
-		userID, chatID).Scan(&lang)
-	if err == sql.ErrNoRows {
-		return "", nil
+func GetMenuState(chatID int64, userID int, ttl time.Duration) ([]string, error) {
+	state, _, err := loadMenuState(userID, chatID, ttl)
+	return state, err
+}
+
+// UpdateMenuState changes the menu state for a user and chat atomically and returns the new state.
+// The state is written only if its version didn't change since it was read; otherwise it's read again
+// and update is applied to the new state. A state not updated within ttl is passed to update as empty.
+
+func UpdateMenuState(userID int, chatID int64, ttl time.Duration, update func(state []string) []string) ([]string, error) {
+
+	for attempt := 0; attempt < menuStateRetries; attempt++ {
+		state, version, err := loadMenuState(userID, chatID, ttl)
+		if err != nil {
+			return nil, err
+		}
+		state = update(state)
+
+		var result sql.Result
+		if version == 0 {
+			result, err = db.Exec(`INSERT OR IGNORE INTO menu_state (user_id, chat_id, state, version, updated_at)
+			 VALUES (?, ?, ?, 1, ?)`, userID, chatID, strings.Join(state, "/"), time.Now().Unix())
+		} else {
+			result, err = db.Exec(`UPDATE menu_state SET state = ?, version = version + 1, updated_at = ?
+			 WHERE user_id = ? AND chat_id = ? AND version = ?`,
+				strings.Join(state, "/"), time.Now().Unix(), userID, chatID, version)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to save state menu in db: %v", err)
+		}
+		if n, err := result.RowsAffected(); err == nil && n == 1 {
+			return state, nil
+		}
+	}
+	return nil, ErrMenuStateConflict
+}
+
+// DeleteExpiredMenuStates removes the menu states not updated within ttl and returns how many were removed.
+
+func DeleteExpiredMenuStates(ttl time.Duration) (int64, error) {
+	result, err := db.Exec(`DELETE FROM menu_state WHERE updated_at < ?`, time.Now().Add(-ttl).Unix())
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired menu states: %v", err)
 	}
+	return result.RowsAffected()
+}
+
+// ClearMenuState removes the menu state for a user and chat.
+
+func ClearMenuState(chatID int64, userID int) error {
+	_, err := db.Exec(`DELETE FROM menu_state WHERE chat_id = ? AND user_id = ?`, chatID, userID)
 	if err != nil {
-		return "", fmt.Errorf("failed to get bot language in db: %v", err)
+		return fmt.Errorf("failed to clear menu state: %v", err)
 	}
-	return lang, nil
+	return nil
 }
 
-// SaveMenuState saves or updates the menu state for a user and chat.
+// SaveMenuMessageID saves the ID of the message showing the active menu for a user and chat.
 
-func SaveMenuState(userID int, chatID int64, state []string) error {
-	stateStr := strings.Join(state, "/")
-	_, err := db.Exec(`INSERT OR REPLACE INTO menu_state (user_id, chat_id, state)
-	 VALUES (?, ?, ?)`, userID, chatID, stateStr)
+func SaveMenuMessageID(userID int, chatID int64, messageID int) error {
+	_, err := db.Exec(`INSERT OR REPLACE INTO menu_message (user_id, chat_id, message_id)
+	 VALUES (?, ?, ?)`, userID, chatID, messageID)
 	if err != nil {
-		return fmt.Errorf("failed to save state menu in db: %v", err)
+		return fmt.Errorf("failed to save menu message in db: %v", err)
 	}
 	return nil
 }
 
-// GetMenuState retrieves the menu state for a user and chat.
+// GetMenuMessageOwner retrieves the user whose active menu a message of a chat shows.
+// It returns 0 if the message isn't the active menu of any user.
 
-func GetMenuState(chatID int64, userID int) ([]string, error) {
-	var stateStr string
-	err := db.QueryRow(`SELECT state FROM menu_state WHERE user_id = ? AND chat_id = ?`,
-		userID, chatID).Scan(&stateStr)
+func GetMenuMessageOwner(chatID int64, messageID int) (int, error) {
+	var userID int
+	err := db.QueryRow(`SELECT user_id FROM menu_message WHERE chat_id = ? AND message_id = ?`,
+		chatID, messageID).Scan(&userID)
 	if err == sql.ErrNoRows {
-		return nil, nil
+		return 0, nil
 	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to get state menu in db: %v", err)
+		return 0, fmt.Errorf("failed to get menu message owner in db: %v", err)
 	}
-	state := strings.Split(stateStr, "/")
-	return state, nil
+	return userID, nil
 }
 
-// ClearMenuState removes the menu state for a user and chat.
+// GetMenuMessageID retrieves the ID of the message showing the active menu for a user and chat.
+// It returns 0 if no menu was shown yet.
 
-func ClearMenuState(chatID int64, userID int) error {
-	_, err := db.Exec(`DELETE FROM menu_state WHERE chat_id = ? AND user_id = ?`, chatID, userID)
+func GetMenuMessageID(userID int, chatID int64) (int, error) {
+	var messageID int
+	err := db.QueryRow(`SELECT message_id FROM menu_message WHERE user_id = ? AND chat_id = ?`,
+		userID, chatID).Scan(&messageID)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
 	if err != nil {
-		return fmt.Errorf("failed to clear menu state: %v", err)
+		return 0, fmt.Errorf("failed to get menu message in db: %v", err)
 	}
-	return nil
+	return messageID, nil
 }
@@ -0,0 +1,75 @@
+package storange
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Conversation is the saved state of a multi-step conversation of a user in a chat.
+type Conversation struct {
+	UserID    int
+	ChatID    int64
+	Flow      string
+	State     string
+	Data      map[string]string
+	ExpiresAt time.Time // Zero if the conversation doesn't time out
+}
+
+// SaveConversation saves or replaces the conversation of a user in a chat.
+
+func SaveConversation(conversation Conversation) error {
+	data, err := json.Marshal(conversation.Data)
+	if err != nil {
+		return fmt.Errorf("failed to encode conversation data: %v", err)
+	}
+
+	var expiresAt int64
+	if !conversation.ExpiresAt.IsZero() {
+		expiresAt = conversation.ExpiresAt.Unix()
+	}
+
+	_, err = db.Exec(`INSERT OR REPLACE INTO conversation (user_id, chat_id, flow, state, data, expires_at)
+	 VALUES (?, ?, ?, ?, ?, ?)`, conversation.UserID, conversation.ChatID, conversation.Flow,
+		conversation.State, string(data), expiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to save conversation in db: %v", err)
+	}
+	return nil
+}
+
+// GetConversation retrieves the conversation of a user in a chat. It returns nil if there is none.
+
+func GetConversation(userID int, chatID int64) (*Conversation, error) {
+	conversation := Conversation{UserID: userID, ChatID: chatID}
+	var data string
+	var expiresAt int64
+
+	err := db.QueryRow(`SELECT flow, state, data, expires_at FROM conversation WHERE user_id = ? AND chat_id = ?`,
+		userID, chatID).Scan(&conversation.Flow, &conversation.State, &data, &expiresAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get conversation in db: %v", err)
+	}
+
+	if err := json.Unmarshal([]byte(data), &conversation.Data); err != nil {
+		return nil, fmt.Errorf("failed to decode conversation data: %v", err)
+	}
+	if expiresAt > 0 {
+		conversation.ExpiresAt = time.Unix(expiresAt, 0)
+	}
+	return &conversation, nil
+}
+
+// ClearConversation removes the conversation of a user in a chat.
+
+func ClearConversation(userID int, chatID int64) error {
+	_, err := db.Exec(`DELETE FROM conversation WHERE user_id = ? AND chat_id = ?`, userID, chatID)
+	if err != nil {
+		return fmt.Errorf("failed to clear conversation: %v", err)
+	}
+	return nil
+}
@@ -13,48 +13,246 @@ var db *sql.DB
 
 func InitDB() error {
 	var err error
-	db, err = sql.Open("sqlite3", "./bot.db")
+	// Concurrent updates wait for each other's writes instead of failing with "database is locked"
+	db, err = sql.Open("sqlite3", "./bot.db?_busy_timeout=5000")
 	if err != nil {
 		return fmt.Errorf("failed to create db: %v", err)
 	}
 
-	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS bot_setting(
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS setting(
+	scope TEXT,
+	scope_id TEXT,
+	name TEXT,
+	value TEXT,
+	PRIMARY KEY(scope, scope_id, name)
+	);`)
+	if err != nil {
+		return fmt.Errorf("failed to create setting table: %v", err)
+	}
+
+	if err := migrateSettings(); err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS menu_state(
+	chat_id INTEGER,
+	user_id INTEGER,
+	state TEXT,
+	version INTEGER NOT NULL DEFAULT 1,
+	updated_at INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY(user_id, chat_id)
+	);`)
+	if err != nil {
+		return fmt.Errorf("failed to create menu_state table: %v", err)
+	}
+
+	// Menu states saved before they had versions count as expired
+	if err := addColumn("menu_state", "version", "INTEGER NOT NULL DEFAULT 1"); err != nil {
+		return err
+	}
+	if err := addColumn("menu_state", "updated_at", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS menu_message(
 	user_id INTEGER,
 	chat_id INTEGER,
-	bot_language TEXT,
+	message_id INTEGER,
 	PRIMARY KEY(user_id, chat_id)
 	);`)
+	if err != nil {
+		return fmt.Errorf("failed to create menu_message table: %v", err)
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS ban(
+	user_id INTEGER PRIMARY KEY,
+	reason TEXT DEFAULT '',
+	banned_by INTEGER,
+	created_at INTEGER
+	);`)
+	if err != nil {
+		return fmt.Errorf("failed to create ban table: %v", err)
+	}
 
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS referral(
+	user_id INTEGER PRIMARY KEY,
+	referrer_id INTEGER,
+	created_at INTEGER
+	);`)
 	if err != nil {
-		return fmt.Errorf("failed to create bot_setting table: %v", err)
+		return fmt.Errorf("failed to create referral table: %v", err)
 	}
 
-	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS menu_state(
-	chat_id INTEGER,
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS referral_referrer ON referral (referrer_id);`)
+	if err != nil {
+		return fmt.Errorf("failed to create referral index: %v", err)
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS conversation(
 	user_id INTEGER,
+	chat_id INTEGER,
+	flow TEXT,
 	state TEXT,
+	data TEXT,
+	expires_at INTEGER DEFAULT 0,
 	PRIMARY KEY(user_id, chat_id)
 	);`)
 	if err != nil {
-		return fmt.Errorf("failed to create menu_state table: %v", err)
+		return fmt.Errorf("failed to create conversation table: %v", err)
 	}
 
-	// _, err = db.Exec(`DROP TABLE IF EXISTS translation`)
-	// if err != nil {
-	// 	return fmt.Errorf("failed to drop existing translation table: %v", err)
-	// }
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS glossary(
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER,
+	source_language TEXT,
+	target_language TEXT,
+	term TEXT COLLATE NOCASE,
+	translation TEXT,
+	created_at INTEGER,
+	UNIQUE (user_id, source_language, target_language, term)
+	);`)
+	if err != nil {
+		return fmt.Errorf("failed to create glossary table: %v", err)
+	}
 
-	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS translation (
-    user_id INTEGER,
-    sent_message BOOLEAN DEFAULT FALSE,
-    sent_source_language TEXT,
-    sent_target_language TEXT,
-	active_translation BOOLEAN DEFAULT FALSE,
-    PRIMARY KEY (user_id)
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS recent_pairs (
+	user_id INTEGER,
+	source_language TEXT,
+	target_language TEXT,
+	used_at INTEGER,
+	PRIMARY KEY (user_id, source_language, target_language)
 	);`)
 	if err != nil {
-		return fmt.Errorf("failed to create translation table: %v", err)
+		return fmt.Errorf("failed to create recent_pairs table: %v", err)
 	}
 
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS translation_history (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER,
+	source_text TEXT,
+	translated_text TEXT,
+	source_language TEXT,
+	target_language TEXT,
+	provider TEXT,
+	created_at INTEGER
+	);`)
+	if err != nil {
+		return fmt.Errorf("failed to create translation_history table: %v", err)
+	}
+
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS translation_history_user
+	ON translation_history (user_id, id);`)
+	if err != nil {
+		return fmt.Errorf("failed to create translation_history index: %v", err)
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS user_preference (
+	user_id INTEGER,
+	save_history BOOLEAN DEFAULT TRUE,
+	PRIMARY KEY (user_id)
+	);`)
+	if err != nil {
+		return fmt.Errorf("failed to create user_preference table: %v", err)
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS phrasebook (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER,
+	source_text TEXT,
+	translated_text TEXT,
+	source_language TEXT,
+	target_language TEXT,
+	created_at INTEGER,
+	UNIQUE (user_id, source_language, target_language, source_text, translated_text)
+	);`)
+	if err != nil {
+		return fmt.Errorf("failed to create phrasebook table: %v", err)
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS feedback (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER,
+	chat_id INTEGER,
+	message_id INTEGER,
+	source_text TEXT,
+	translated_text TEXT,
+	source_language TEXT,
+	target_language TEXT,
+	provider TEXT,
+	score REAL,
+	quality REAL,
+	rating INTEGER DEFAULT 0,
+	correction TEXT DEFAULT '',
+	correction_status TEXT DEFAULT '',
+	created_at INTEGER,
+	UNIQUE (user_id, chat_id, message_id)
+	);`)
+	if err != nil {
+		return fmt.Errorf("failed to create feedback table: %v", err)
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS translation_reply (
+	chat_id INTEGER,
+	message_id INTEGER,
+	source_text TEXT,
+	translated_text TEXT,
+	source_language TEXT,
+	target_language TEXT,
+	provider TEXT,
+	score REAL,
+	quality REAL,
+	created_at INTEGER,
+	PRIMARY KEY(chat_id, message_id)
+	);`)
+	if err != nil {
+		return fmt.Errorf("failed to create translation_reply table: %v", err)
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS update_offset(
+	id INTEGER PRIMARY KEY CHECK (id = 1),
+	next_update_id INTEGER
+	);`)
+	if err != nil {
+		return fmt.Errorf("failed to create update_offset table: %v", err)
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS processed_update(
+	seq INTEGER PRIMARY KEY AUTOINCREMENT,
+	update_id INTEGER UNIQUE
+	);`)
+	if err != nil {
+		return fmt.Errorf("failed to create processed_update table: %v", err)
+	}
+
+	return nil
+}
+
+// addColumn adds a column to a table created by an older version of the bot, unless the table already has it.
+
+func addColumn(table, column, definition string) error {
+
+	rows, err := db.Query(`SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return fmt.Errorf("failed to read columns of %s table: %v", table, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return fmt.Errorf("failed to read columns of %s table: %v", table, err)
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read columns of %s table: %v", table, err)
+	}
+	rows.Close()
+
+	if _, err := db.Exec(`ALTER TABLE ` + table + ` ADD COLUMN ` + column + ` ` + definition); err != nil {
+		return fmt.Errorf("failed to add %s column to %s table: %v", column, table, err)
+	}
 	return nil
 }
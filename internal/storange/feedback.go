@@ -0,0 +1,222 @@
+package storange
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Review states of a suggested correction
+const (
+	CorrectionPending  = "pending"
+	CorrectionApproved = "approved"
+	CorrectionRejected = "rejected"
+)
+
+// Feedback is a user's rating of a translation reply, with an optional suggested correction.
+// Rating is 1 for a thumbs up, -1 for a thumbs down and 0 when the user only suggested a correction.
+type Feedback struct {
+	ID             int64
+	UserID         int
+	ChatID         int64
+	MessageID      int
+	SourceText     string
+	TranslatedText string
+	SourceLanguage string
+	TargetLanguage string
+	Provider       string
+	Score          float64
+	Quality        float64
+	Rating         int
+	Correction     string
+	Status         string
+	CreatedAt      time.Time
+}
+
+// FeedbackStat summarizes the feedback collected for one provider and language pair.
+type FeedbackStat struct {
+	Provider string
+	LanguagePair
+	Ratings             int
+	ThumbsUp            int
+	ThumbsDown          int
+	AverageScore        float64
+	Corrections         int
+	ApprovedCorrections int
+}
+
+// TranslationReply is a translation reply sent by the bot, kept so the feedback on it is stored
+// with the provider and scores the bot found, rather than with values carried by its buttons.
+type TranslationReply struct {
+	ChatID         int64
+	MessageID      int
+	SourceText     string
+	TranslatedText string
+	SourceLanguage string
+	TargetLanguage string
+	Provider       string
+	Score          float64
+	Quality        float64
+	CreatedAt      time.Time
+}
+
+// SaveTranslationReply stores a translation reply sent to a chat.
+
+func SaveTranslationReply(reply TranslationReply) error {
+	_, err := db.Exec(`INSERT OR REPLACE INTO translation_reply
+						(chat_id, message_id, source_text, translated_text, source_language, target_language,
+						 provider, score, quality, created_at)
+						VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		reply.ChatID, reply.MessageID, reply.SourceText, reply.TranslatedText, reply.SourceLanguage,
+		reply.TargetLanguage, reply.Provider, reply.Score, reply.Quality, reply.CreatedAt.Unix())
+	if err != nil {
+		return fmt.Errorf("failed to save translation reply in db: %v", err)
+	}
+	return nil
+}
+
+// GetTranslationReply returns the translation reply with the message ID in the chat, or nil if it isn't stored.
+
+func GetTranslationReply(chatID int64, messageID int) (*TranslationReply, error) {
+	reply := TranslationReply{ChatID: chatID, MessageID: messageID}
+	var createdAt int64
+	err := db.QueryRow(`SELECT source_text, translated_text, source_language, target_language, provider,
+						score, quality, created_at
+						FROM translation_reply WHERE chat_id = ? AND message_id = ?`, chatID, messageID).
+		Scan(&reply.SourceText, &reply.TranslatedText, &reply.SourceLanguage, &reply.TargetLanguage,
+			&reply.Provider, &reply.Score, &reply.Quality, &createdAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get translation reply in db: %v", err)
+	}
+	reply.CreatedAt = time.Unix(createdAt, 0)
+	return &reply, nil
+}
+
+// DeleteExpiredTranslationReplies removes the translation replies older than ttl and returns how many were removed.
+
+func DeleteExpiredTranslationReplies(ttl time.Duration) (int64, error) {
+	result, err := db.Exec(`DELETE FROM translation_reply WHERE created_at < ?`, time.Now().Add(-ttl).Unix())
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired translation replies: %v", err)
+	}
+	return result.RowsAffected()
+}
+
+// SaveFeedback stores the feedback for a translation reply and returns its ID.
+// Feedback on the same reply is updated in place; a zero rating keeps the previous rating.
+
+func SaveFeedback(feedback Feedback) (int64, error) {
+	var id int64
+	err := db.QueryRow(`INSERT INTO feedback
+						(user_id, chat_id, message_id, source_text, translated_text, source_language,
+						 target_language, provider, score, quality, rating, created_at)
+						VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+						ON CONFLICT(user_id, chat_id, message_id) DO UPDATE SET
+						rating = CASE WHEN excluded.rating != 0 THEN excluded.rating ELSE feedback.rating END
+						RETURNING id`,
+		feedback.UserID, feedback.ChatID, feedback.MessageID, feedback.SourceText, feedback.TranslatedText,
+		feedback.SourceLanguage, feedback.TargetLanguage, feedback.Provider, feedback.Score, feedback.Quality,
+		feedback.Rating, feedback.CreatedAt.Unix()).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to save feedback in db: %v", err)
+	}
+	return id, nil
+}
+
+// SaveCorrection attaches the user's suggested translation to the feedback and queues it for review.
+
+func SaveCorrection(userID int, feedbackID int64, correction string) error {
+	_, err := db.Exec(`UPDATE feedback SET correction = ?, correction_status = ?
+					   WHERE id = ? AND user_id = ?`, correction, CorrectionPending, feedbackID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to save correction in db: %v", err)
+	}
+	return nil
+}
+
+// GetFeedbackStats summarizes the feedback for every provider and language pair.
+
+func GetFeedbackStats() ([]FeedbackStat, error) {
+	rows, err := db.Query(`SELECT provider, source_language, target_language,
+						   COUNT(*),
+						   COALESCE(SUM(rating = 1), 0),
+						   COALESCE(SUM(rating = -1), 0),
+						   COALESCE(AVG(score), 0),
+						   COALESCE(SUM(correction != ''), 0),
+						   COALESCE(SUM(correction_status = ?), 0)
+						   FROM feedback GROUP BY provider, source_language, target_language
+						   ORDER BY COUNT(*) DESC`, CorrectionApproved)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get feedback stats in db: %v", err)
+	}
+	defer rows.Close()
+
+	var stats []FeedbackStat
+	for rows.Next() {
+		var stat FeedbackStat
+		if err := rows.Scan(&stat.Provider, &stat.Source, &stat.Target, &stat.Ratings, &stat.ThumbsUp,
+			&stat.ThumbsDown, &stat.AverageScore, &stat.Corrections, &stat.ApprovedCorrections); err != nil {
+			return nil, fmt.Errorf("failed to scan feedback stats: %v", err)
+		}
+		stats = append(stats, stat)
+	}
+	return stats, rows.Err()
+}
+
+// GetPendingCorrection retrieves the oldest correction waiting for review, or nil if there is none.
+
+func GetPendingCorrection() (*Feedback, error) {
+	feedback := &Feedback{}
+	var createdAt int64
+	err := db.QueryRow(`SELECT id, user_id, source_text, translated_text, source_language, target_language,
+						provider, score, quality, rating, correction, created_at
+						FROM feedback WHERE correction_status = ? ORDER BY id LIMIT 1`, CorrectionPending).
+		Scan(&feedback.ID, &feedback.UserID, &feedback.SourceText, &feedback.TranslatedText,
+			&feedback.SourceLanguage, &feedback.TargetLanguage, &feedback.Provider, &feedback.Score,
+			&feedback.Quality, &feedback.Rating, &feedback.Correction, &createdAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pending correction in db: %v", err)
+	}
+	feedback.Status = CorrectionPending
+	feedback.CreatedAt = time.Unix(createdAt, 0)
+	return feedback, nil
+}
+
+// ReviewCorrection approves or rejects a pending correction.
+
+func ReviewCorrection(feedbackID int64, approved bool) error {
+	status := CorrectionRejected
+	if approved {
+		status = CorrectionApproved
+	}
+	_, err := db.Exec(`UPDATE feedback SET correction_status = ? WHERE id = ? AND correction_status = ?`,
+		status, feedbackID, CorrectionPending)
+	if err != nil {
+		return fmt.Errorf("failed to review correction in db: %v", err)
+	}
+	return nil
+}
+
+// FindApprovedCorrection returns the newest approved correction for exactly this source text and language pair.
+
+func FindApprovedCorrection(sourceText, sourceLang, targetLang string) (string, bool, error) {
+	var correction string
+	err := db.QueryRow(`SELECT correction FROM feedback
+						WHERE correction_status = ? AND source_text = ? AND source_language = ? AND target_language = ?
+						ORDER BY id DESC LIMIT 1`,
+		CorrectionApproved, strings.TrimSpace(sourceText), sourceLang, targetLang).Scan(&correction)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("failed to find approved correction in db: %v", err)
+	}
+	return correction, true, nil
+}
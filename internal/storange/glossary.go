@@ -0,0 +1,96 @@
+package storange
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// GlossaryTerm is a fixed translation of a term the user defined for a language pair.
+type GlossaryTerm struct {
+	ID             int64
+	UserID         int
+	SourceLanguage string
+	TargetLanguage string
+	Term           string
+	Translation    string
+	CreatedAt      time.Time
+}
+
+// SaveGlossaryTerm stores a term in the user's glossary, replacing the translation of an existing term.
+
+func SaveGlossaryTerm(term GlossaryTerm) error {
+	_, err := db.Exec(`INSERT INTO glossary
+					   (user_id, source_language, target_language, term, translation, created_at)
+					   VALUES (?, ?, ?, ?, ?, ?)
+					   ON CONFLICT (user_id, source_language, target_language, term)
+					   DO UPDATE SET translation = excluded.translation`,
+		term.UserID, term.SourceLanguage, term.TargetLanguage, strings.TrimSpace(term.Term),
+		strings.TrimSpace(term.Translation), term.CreatedAt.Unix())
+	if err != nil {
+		return fmt.Errorf("failed to save glossary term in db: %v", err)
+	}
+	return nil
+}
+
+// GetGlossary retrieves one page of the user's glossary for a language pair, sorted by term.
+// It also returns the total number of terms for the pair.
+
+func GetGlossary(userID int, sourceLang, targetLang string, offset, limit int) ([]GlossaryTerm, int, error) {
+	var total int
+	err := db.QueryRow(`SELECT COUNT(*) FROM glossary
+						WHERE user_id = ? AND source_language = ? AND target_language = ?`,
+		userID, sourceLang, targetLang).Scan(&total)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count glossary terms in db: %v", err)
+	}
+
+	rows, err := db.Query(`SELECT id, term, translation, created_at FROM glossary
+						   WHERE user_id = ? AND source_language = ? AND target_language = ?
+						   ORDER BY term LIMIT ? OFFSET ?`,
+		userID, sourceLang, targetLang, limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get glossary in db: %v", err)
+	}
+	defer rows.Close()
+
+	var terms []GlossaryTerm
+	for rows.Next() {
+		term := GlossaryTerm{UserID: userID, SourceLanguage: sourceLang, TargetLanguage: targetLang}
+		var createdAt int64
+		if err := rows.Scan(&term.ID, &term.Term, &term.Translation, &createdAt); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan glossary term: %v", err)
+		}
+		term.CreatedAt = time.Unix(createdAt, 0)
+		terms = append(terms, term)
+	}
+	return terms, total, rows.Err()
+}
+
+// FindGlossaryTerm looks up the translation of a term in the user's glossary for a language pair.
+// Terms are matched ignoring case and surrounding spaces.
+
+func FindGlossaryTerm(userID int, sourceLang, targetLang, term string) (string, bool, error) {
+	var translation string
+	err := db.QueryRow(`SELECT translation FROM glossary
+						WHERE user_id = ? AND source_language = ? AND target_language = ? AND term = ?`,
+		userID, sourceLang, targetLang, strings.TrimSpace(term)).Scan(&translation)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("failed to find glossary term in db: %v", err)
+	}
+	return translation, true, nil
+}
+
+// DeleteGlossaryTerm removes a term from the user's glossary.
+
+func DeleteGlossaryTerm(userID int, id int64) error {
+	_, err := db.Exec(`DELETE FROM glossary WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete glossary term: %v", err)
+	}
+	return nil
+}
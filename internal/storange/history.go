@@ -0,0 +1,129 @@
+package storange
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// HistoryEntry is a single translation remembered for a user.
+type HistoryEntry struct {
+	ID             int64
+	UserID         int
+	SourceText     string
+	TranslatedText string
+	SourceLanguage string
+	TargetLanguage string
+	Provider       string
+	CreatedAt      time.Time
+}
+
+// SaveHistory stores a translation in the user's history.
+
+func SaveHistory(entry HistoryEntry) error {
+	_, err := db.Exec(`INSERT INTO translation_history
+					   (user_id, source_text, translated_text, source_language, target_language, provider, created_at)
+					   VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		entry.UserID, entry.SourceText, entry.TranslatedText, entry.SourceLanguage,
+		entry.TargetLanguage, entry.Provider, entry.CreatedAt.Unix())
+	if err != nil {
+		return fmt.Errorf("failed to save translation history in db: %v", err)
+	}
+	return nil
+}
+
+// historyFilter builds the WHERE clause selecting a user's entries that contain the keyword.
+// An empty keyword selects every entry of the user.
+
+func historyFilter(userID int, keyword string) (string, []interface{}) {
+	if keyword == "" {
+		return "user_id = ?", []interface{}{userID}
+	}
+
+	escaper := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+	pattern := "%" + escaper.Replace(keyword) + "%"
+	return `user_id = ? AND (source_text LIKE ? ESCAPE '\' OR translated_text LIKE ? ESCAPE '\')`,
+		[]interface{}{userID, pattern, pattern}
+}
+
+// GetHistory retrieves one page of the user's history, newest first, optionally filtered by a keyword.
+// It also returns the total number of matching entries.
+
+func GetHistory(userID int, keyword string, offset, limit int) ([]HistoryEntry, int, error) {
+	where, args := historyFilter(userID, keyword)
+
+	var total int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM translation_history WHERE `+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count translation history in db: %v", err)
+	}
+
+	rows, err := db.Query(`SELECT id, user_id, source_text, translated_text, source_language,
+						   target_language, provider, created_at FROM translation_history
+						   WHERE `+where+` ORDER BY id DESC LIMIT ? OFFSET ?`,
+		append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get translation history in db: %v", err)
+	}
+	defer rows.Close()
+
+	var entries []HistoryEntry
+	for rows.Next() {
+		var entry HistoryEntry
+		var createdAt int64
+		if err := rows.Scan(&entry.ID, &entry.UserID, &entry.SourceText, &entry.TranslatedText,
+			&entry.SourceLanguage, &entry.TargetLanguage, &entry.Provider, &createdAt); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan translation history: %v", err)
+		}
+		entry.CreatedAt = time.Unix(createdAt, 0)
+		entries = append(entries, entry)
+	}
+	return entries, total, rows.Err()
+}
+
+// DeleteHistory removes a single entry from the user's history.
+
+func DeleteHistory(userID int, id int64) error {
+	_, err := db.Exec(`DELETE FROM translation_history WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete translation history entry: %v", err)
+	}
+	return nil
+}
+
+// ClearHistory removes every entry from the user's history.
+
+func ClearHistory(userID int) error {
+	_, err := db.Exec(`DELETE FROM translation_history WHERE user_id = ?`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to clear translation history: %v", err)
+	}
+	return nil
+}
+
+// HistoryEnabled reports whether translations of the user should be saved in the history.
+// History is enabled unless the user opted out.
+
+func HistoryEnabled(userID int) (bool, error) {
+	var enabled bool
+	err := db.QueryRow(`SELECT save_history FROM user_preference WHERE user_id = ?`, userID).Scan(&enabled)
+	if err == sql.ErrNoRows {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get history preference in db: %v", err)
+	}
+	return enabled, nil
+}
+
+// SetHistoryEnabled saves whether translations of the user should be saved in the history.
+
+func SetHistoryEnabled(userID int, enabled bool) error {
+	_, err := db.Exec(`INSERT INTO user_preference (user_id, save_history) VALUES (?, ?)
+					   ON CONFLICT(user_id) DO UPDATE SET save_history = excluded.save_history`,
+		userID, enabled)
+	if err != nil {
+		return fmt.Errorf("failed to save history preference in db: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package storange
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Phrase is a translation the user saved to the phrasebook.
+type Phrase struct {
+	ID             int64
+	UserID         int
+	SourceText     string
+	TranslatedText string
+	SourceLanguage string
+	TargetLanguage string
+	CreatedAt      time.Time
+}
+
+// PhrasePairCount is the number of saved phrases for one language pair.
+type PhrasePairCount struct {
+	LanguagePair
+	Count int
+}
+
+// SavePhrase stores a translation in the user's phrasebook. Saving the same translation twice keeps one copy.
+
+func SavePhrase(phrase Phrase) error {
+	_, err := db.Exec(`INSERT OR IGNORE INTO phrasebook
+					   (user_id, source_text, translated_text, source_language, target_language, created_at)
+					   VALUES (?, ?, ?, ?, ?, ?)`,
+		phrase.UserID, phrase.SourceText, phrase.TranslatedText, phrase.SourceLanguage,
+		phrase.TargetLanguage, phrase.CreatedAt.Unix())
+	if err != nil {
+		return fmt.Errorf("failed to save phrase in db: %v", err)
+	}
+	return nil
+}
+
+// GetPhrasePairs retrieves the language pairs of the user's phrasebook with the number of phrases in each.
+
+func GetPhrasePairs(userID int) ([]PhrasePairCount, error) {
+	rows, err := db.Query(`SELECT source_language, target_language, COUNT(*) FROM phrasebook
+						   WHERE user_id = ? GROUP BY source_language, target_language
+						   ORDER BY MAX(id) DESC`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get phrasebook pairs in db: %v", err)
+	}
+	defer rows.Close()
+
+	var pairs []PhrasePairCount
+	for rows.Next() {
+		var pair PhrasePairCount
+		if err := rows.Scan(&pair.Source, &pair.Target, &pair.Count); err != nil {
+			return nil, fmt.Errorf("failed to scan phrasebook pair: %v", err)
+		}
+		pairs = append(pairs, pair)
+	}
+	return pairs, rows.Err()
+}
+
+// scanPhrases reads phrase rows selected in the column order used by the phrasebook queries.
+
+func scanPhrases(query string, args ...interface{}) ([]Phrase, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get phrases in db: %v", err)
+	}
+	defer rows.Close()
+
+	var phrases []Phrase
+	for rows.Next() {
+		var phrase Phrase
+		var createdAt int64
+		if err := rows.Scan(&phrase.ID, &phrase.UserID, &phrase.SourceText, &phrase.TranslatedText,
+			&phrase.SourceLanguage, &phrase.TargetLanguage, &createdAt); err != nil {
+			return nil, fmt.Errorf("failed to scan phrase: %v", err)
+		}
+		phrase.CreatedAt = time.Unix(createdAt, 0)
+		phrases = append(phrases, phrase)
+	}
+	return phrases, rows.Err()
+}
+
+const phraseColumns = `id, user_id, source_text, translated_text, source_language, target_language, created_at`
+
+// GetPhrases retrieves one page of the user's phrases for a language pair, newest first.
+// It also returns the total number of phrases for the pair.
+
+func GetPhrases(userID int, sourceLang, targetLang string, offset, limit int) ([]Phrase, int, error) {
+	var total int
+	err := db.QueryRow(`SELECT COUNT(*) FROM phrasebook
+						WHERE user_id = ? AND source_language = ? AND target_language = ?`,
+		userID, sourceLang, targetLang).Scan(&total)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count phrases in db: %v", err)
+	}
+
+	phrases, err := scanPhrases(`SELECT `+phraseColumns+` FROM phrasebook
+								 WHERE user_id = ? AND source_language = ? AND target_language = ?
+								 ORDER BY id DESC LIMIT ? OFFSET ?`,
+		userID, sourceLang, targetLang, limit, offset)
+	return phrases, total, err
+}
+
+// GetAllPhrases retrieves the whole phrasebook of the user, grouped by language pair.
+
+func GetAllPhrases(userID int) ([]Phrase, error) {
+	return scanPhrases(`SELECT `+phraseColumns+` FROM phrasebook WHERE user_id = ?
+						ORDER BY source_language, target_language, id`, userID)
+}
+
+// SearchPhrases retrieves the user's newest phrases containing the query in either text.
+// An empty query matches every phrase.
+
+func SearchPhrases(userID int, query string, limit int) ([]Phrase, error) {
+	escaper := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+	pattern := "%" + escaper.Replace(query) + "%"
+	return scanPhrases(`SELECT `+phraseColumns+` FROM phrasebook WHERE user_id = ?
+						AND (source_text LIKE ? ESCAPE '\' OR translated_text LIKE ? ESCAPE '\')
+						ORDER BY id DESC LIMIT ?`, userID, pattern, pattern, limit)
+}
+
+// DeletePhrase removes a phrase from the user's phrasebook.
+
+func DeletePhrase(userID int, id int64) error {
+	_, err := db.Exec(`DELETE FROM phrasebook WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete phrase: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package storange
+
+import (
+	"fmt"
+	"time"
+)
+
+// RecentPairsLimit is the number of language pairs remembered for each user.
+const RecentPairsLimit = 5
+
+// LanguagePair is a source and target language used together for translation.
+type LanguagePair struct {
+	Source string
+	Target string
+}
+
+// SaveRecentPair marks the language pair as the most recently used one for the user
+// and forgets the oldest pairs beyond RecentPairsLimit.
+
+func SaveRecentPair(userID int, sourceLang, targetLang string) error {
+	_, err := db.Exec(`INSERT OR REPLACE INTO recent_pairs
+					   (user_id, source_language, target_language, used_at)
+					   VALUES (?, ?, ?, ?)`, userID, sourceLang, targetLang, time.Now().UnixNano())
+	if err != nil {
+		return fmt.Errorf("failed to save recent language pair in db: %v", err)
+	}
+
+	_, err = db.Exec(`DELETE FROM recent_pairs WHERE user_id = ? AND rowid NOT IN (
+					   SELECT rowid FROM recent_pairs WHERE user_id = ?
+					   ORDER BY used_at DESC LIMIT ?)`, userID, userID, RecentPairsLimit)
+	if err != nil {
+		return fmt.Errorf("failed to trim recent language pairs in db: %v", err)
+	}
+	return nil
+}
+
+// GetRecentPairs retrieves the user's recent language pairs, most recently used first.
+
+func GetRecentPairs(userID int) ([]LanguagePair, error) {
+	rows, err := db.Query(`SELECT source_language, target_language FROM recent_pairs
+						   WHERE user_id = ? ORDER BY used_at DESC LIMIT ?`, userID, RecentPairsLimit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get recent language pairs in db: %v", err)
+	}
+	defer rows.Close()
+
+	var pairs []LanguagePair
+	for rows.Next() {
+		var pair LanguagePair
+		if err := rows.Scan(&pair.Source, &pair.Target); err != nil {
+			return nil, fmt.Errorf("failed to scan recent language pair: %v", err)
+		}
+		pairs = append(pairs, pair)
+	}
+	return pairs, rows.Err()
+}
@@ -0,0 +1,30 @@
+package storange
+
+import (
+	"fmt"
+	"time"
+)
+
+// SaveReferral records that a user came to the bot through the deep link shared by another user.
+// Only the first referral of a user is kept; it reports whether the referral was recorded.
+
+func SaveReferral(userID, referrerID int) (bool, error) {
+	result, err := db.Exec(`INSERT OR IGNORE INTO referral (user_id, referrer_id, created_at)
+	 VALUES (?, ?, ?)`, userID, referrerID, time.Now().Unix())
+	if err != nil {
+		return false, fmt.Errorf("failed to save referral in db: %v", err)
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// CountReferrals returns the number of users who came to the bot through the deep link of a user.
+
+func CountReferrals(referrerID int) (int, error) {
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM referral WHERE referrer_id = ?`, referrerID).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count referrals in db: %v", err)
+	}
+	return count, nil
+}
@@ -0,0 +1,161 @@
+package storange
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// SettingScope identifies where a setting is defined, such as the settings of one user or one chat.
+
+type SettingScope struct {
+	Scope string // Kind of scope, such as user or chat
+	ID    string // ID of the user, chat or topic; empty for global settings
+}
+
+// SettingValue is a setting stored for a scope.
+
+type SettingValue struct {
+	SettingScope
+	Name  string
+	Value string
+}
+
+// SaveSettings saves or updates settings of a scope in one transaction.
+
+func SaveSettings(scope SettingScope, values map[string]string) error {
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to save settings in db: %v", err)
+	}
+	defer tx.Rollback()
+
+	for name, value := range values {
+		_, err := tx.Exec(`INSERT OR REPLACE INTO setting (scope, scope_id, name, value)
+		 VALUES (?, ?, ?, ?)`, scope.Scope, scope.ID, name, value)
+		if err != nil {
+			return fmt.Errorf("failed to save setting %s in db: %v", name, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to save settings in db: %v", err)
+	}
+	return nil
+}
+
+// DeleteSettings removes settings of a scope, so their values are inherited again.
+// It returns the number of removed settings.
+
+func DeleteSettings(scope SettingScope, names ...string) (int64, error) {
+
+	if len(names) == 0 {
+		return 0, nil
+	}
+	args := []any{scope.Scope, scope.ID}
+	for _, name := range names {
+		args = append(args, name)
+	}
+
+	result, err := db.Exec(`DELETE FROM setting WHERE scope = ? AND scope_id = ? AND name IN (?`+
+		strings.Repeat(", ?", len(names)-1)+`)`, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete settings in db: %v", err)
+	}
+	return result.RowsAffected()
+}
+
+// GetSettings retrieves the named settings defined in any of the scopes.
+
+func GetSettings(scopes []SettingScope, names []string) ([]SettingValue, error) {
+
+	if len(scopes) == 0 || len(names) == 0 {
+		return nil, nil
+	}
+
+	var conditions []string
+	var args []any
+	for _, scope := range scopes {
+		conditions = append(conditions, "(scope = ? AND scope_id = ?)")
+		args = append(args, scope.Scope, scope.ID)
+	}
+	for _, name := range names {
+		args = append(args, name)
+	}
+
+	rows, err := db.Query(`SELECT scope, scope_id, name, value FROM setting
+	 WHERE (`+strings.Join(conditions, " OR ")+`) AND name IN (?`+strings.Repeat(", ?", len(names)-1)+`)`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get settings in db: %v", err)
+	}
+	defer rows.Close()
+
+	var values []SettingValue
+	for rows.Next() {
+		var value SettingValue
+		if err := rows.Scan(&value.Scope, &value.ID, &value.Name, &value.Value); err != nil {
+			return nil, fmt.Errorf("failed to scan setting: %v", err)
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
+
+// migrateSettings moves the settings of the bot_setting and translation tables, used before settings had scopes,
+// to user settings and drops the old tables. Settings of a user's private chat win over those of other chats.
+
+func migrateSettings() error {
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to migrate settings: %v", err)
+	}
+	defer tx.Rollback()
+
+	migrations := map[string][]string{
+		"bot_setting": {
+			`INSERT OR IGNORE INTO setting (scope, scope_id, name, value)
+			 SELECT 'user', CAST(user_id AS TEXT), 'bot_language', bot_language FROM bot_setting
+			 WHERE COALESCE(bot_language, '') != '' ORDER BY user_id != chat_id`,
+		},
+		"translation": {
+			`INSERT OR IGNORE INTO setting (scope, scope_id, name, value)
+			 SELECT 'user', CAST(user_id AS TEXT), 'source_language', sent_source_language FROM translation
+			 WHERE COALESCE(sent_source_language, '') != ''`,
+			`INSERT OR IGNORE INTO setting (scope, scope_id, name, value)
+			 SELECT 'user', CAST(user_id AS TEXT), 'target_language', sent_target_language FROM translation
+			 WHERE COALESCE(sent_target_language, '') != ''`,
+			`INSERT OR IGNORE INTO setting (scope, scope_id, name, value)
+			 SELECT 'user', CAST(user_id AS TEXT), 'sent_message',
+			 CASE WHEN sent_message THEN 'true' ELSE 'false' END FROM translation`,
+			`INSERT OR IGNORE INTO setting (scope, scope_id, name, value)
+			 SELECT 'user', CAST(user_id AS TEXT), 'active_translation',
+			 CASE WHEN active_translation THEN 'true' ELSE 'false' END FROM translation`,
+		},
+	}
+
+	for table, statements := range migrations {
+		var name string
+		err := tx.QueryRow(`SELECT name FROM sqlite_master WHERE type = 'table' AND name = ?`, table).Scan(&name)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to migrate %s table: %v", table, err)
+		}
+
+		for _, statement := range statements {
+			if _, err := tx.Exec(statement); err != nil {
+				return fmt.Errorf("failed to migrate %s table: %v", table, err)
+			}
+		}
+		if _, err := tx.Exec(`DROP TABLE ` + table); err != nil {
+			return fmt.Errorf("failed to drop %s table: %v", table, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to migrate settings: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package storange
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// GetUpdateOffset returns the identifier of the next update to get by polling, or 0 if none was saved.
+
+func GetUpdateOffset() (int, error) {
+	var offset int
+	err := db.QueryRow(`SELECT next_update_id FROM update_offset WHERE id = 1`).Scan(&offset)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get update offset in db: %v", err)
+	}
+	return offset, nil
+}
+
+// SaveUpdateOffset saves the identifier of the next update to get by polling.
+
+func SaveUpdateOffset(offset int) error {
+	_, err := db.Exec(`INSERT OR REPLACE INTO update_offset (id, next_update_id) VALUES (1, ?)`, offset)
+	if err != nil {
+		return fmt.Errorf("failed to save update offset in db: %v", err)
+	}
+	return nil
+}
+
+// GetProcessedUpdates returns the identifiers of the last processed updates, at most limit, oldest first.
+
+func GetProcessedUpdates(limit int) ([]int, error) {
+	rows, err := db.Query(`SELECT update_id FROM (
+		SELECT seq, update_id FROM processed_update ORDER BY seq DESC LIMIT ?
+	) ORDER BY seq`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get processed updates in db: %v", err)
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan processed update: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// SaveProcessedUpdate records a processed update and forgets the ones older than the last window updates.
+
+func SaveProcessedUpdate(updateID, window int) error {
+	_, err := db.Exec(`INSERT OR IGNORE INTO processed_update (update_id) VALUES (?)`, updateID)
+	if err != nil {
+		return fmt.Errorf("failed to save processed update in db: %v", err)
+	}
+	_, err = db.Exec(`DELETE FROM processed_update WHERE seq <= (SELECT MAX(seq) FROM processed_update) - ?`, window)
+	if err != nil {
+		return fmt.Errorf("failed to delete old processed updates in db: %v", err)
+	}
+	return nil
+}
+
+// DeleteProcessedUpdate forgets a processed update, so it's processed when it's delivered again.
+
+func DeleteProcessedUpdate(updateID int) error {
+	_, err := db.Exec(`DELETE FROM processed_update WHERE update_id = ?`, updateID)
+	if err != nil {
+		return fmt.Errorf("failed to delete processed update in db: %v", err)
+	}
+	return nil
+}
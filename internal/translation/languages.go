@@ -0,0 +1,97 @@
+package translation
+
+import (
+	"strings"
+)
+
+// Language describes a language that can be used as a translation source or target.
+type Language struct {
+	Code   string // ISO 639-1 code understood by the MyMemory API
+	Name   string // English name of the language
+	Native string // Name of the language written in the language itself
+}
+
+// AutoDetect is the pseudo language code used when the source language should be detected by the API.
+const AutoDetect = "auto"
+
+// Languages lists every language offered in the language pair picker, in display order.
+var Languages = []Language{
+	{Code: "en", Name: "English", Native: "English"},
+	{Code: "fa", Name: "Persian", Native: "فارسی"},
+	{Code: "ar", Name: "Arabic", Native: "العربية"},
+	{Code: "de", Name: "German", Native: "Deutsch"},
+	{Code: "fr", Name: "French", Native: "Français"},
+	{Code: "es", Name: "Spanish", Native: "Español"},
+	{Code: "tr", Name: "Turkish", Native: "Türkçe"},
+	{Code: "ru", Name: "Russian", Native: "Русский"},
+	{Code: "it", Name: "Italian", Native: "Italiano"},
+	{Code: "pt", Name: "Portuguese", Native: "Português"},
+	{Code: "zh", Name: "Chinese", Native: "中文"},
+	{Code: "ja", Name: "Japanese", Native: "日本語"},
+	{Code: "ko", Name: "Korean", Native: "한국어"},
+	{Code: "hi", Name: "Hindi", Native: "हिन्दी"},
+	{Code: "ur", Name: "Urdu", Native: "اردو"},
+	{Code: "ps", Name: "Pashto", Native: "پښتو"},
+	{Code: "az", Name: "Azerbaijani", Native: "Azərbaycan"},
+	{Code: "ku", Name: "Kurdish", Native: "Kurdî"},
+	{Code: "he", Name: "Hebrew", Native: "עברית"},
+	{Code: "nl", Name: "Dutch", Native: "Nederlands"},
+	{Code: "pl", Name: "Polish", Native: "Polski"},
+	{Code: "uk", Name: "Ukrainian", Native: "Українська"},
+	{Code: "sv", Name: "Swedish", Native: "Svenska"},
+	{Code: "da", Name: "Danish", Native: "Dansk"},
+	{Code: "fi", Name: "Finnish", Native: "Suomi"},
+	{Code: "no", Name: "Norwegian", Native: "Norsk"},
+	{Code: "el", Name: "Greek", Native: "Ελληνικά"},
+	{Code: "ro", Name: "Romanian", Native: "Română"},
+	{Code: "cs", Name: "Czech", Native: "Čeština"},
+	{Code: "hu", Name: "Hungarian", Native: "Magyar"},
+	{Code: "id", Name: "Indonesian", Native: "Bahasa Indonesia"},
+	{Code: "ms", Name: "Malay", Native: "Bahasa Melayu"},
+	{Code: "th", Name: "Thai", Native: "ไทย"},
+	{Code: "vi", Name: "Vietnamese", Native: "Tiếng Việt"},
+	{Code: "hy", Name: "Armenian", Native: "Հայերեն"},
+	{Code: "ka", Name: "Georgian", Native: "ქართული"},
+}
+
+// LanguageByCode returns the language registered under the given code.
+func LanguageByCode(code string) (Language, bool) {
+	for _, l := range Languages {
+		if l.Code == code {
+			return l, true
+		}
+	}
+	return Language{}, false
+}
+
+// IsSupportedLanguage reports whether the code belongs to a language in the picker list.
+func IsSupportedLanguage(code string) bool {
+	_, ok := LanguageByCode(code)
+	return ok
+}
+
+// SearchLanguages finds languages whose code, English name or native name matches the query.
+// Exact code matches come first, followed by prefix matches and then substring matches.
+func SearchLanguages(query string) []Language {
+	query = strings.ToLower(strings.TrimSpace(query))
+	if query == "" {
+		return nil
+	}
+
+	var exact, prefix, contains []Language
+	for _, l := range Languages {
+		name := strings.ToLower(l.Name)
+		native := strings.ToLower(l.Native)
+
+		switch {
+		case l.Code == query:
+			exact = append(exact, l)
+		case strings.HasPrefix(name, query) || strings.HasPrefix(native, query):
+			prefix = append(prefix, l)
+		case strings.Contains(name, query) || strings.Contains(native, query):
+			contains = append(contains, l)
+		}
+	}
+
+	return append(append(exact, prefix...), contains...)
+}
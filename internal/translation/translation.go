@@ -13,9 +13,27 @@ import (
 	"unicode"
 )
 
+// ProviderMyMemory is the name recorded for translations made with the MyMemory API.
+const ProviderMyMemory = "mymemory"
+
+// ProviderCorrection is the name recorded for translations reused from approved user corrections.
+const ProviderCorrection = "correction"
+
+// ProviderGlossary is the name recorded for translations taken from the user's glossary.
+const ProviderGlossary = "glossary"
+
+// Result is a translation together with the scores it was chosen by.
+type Result struct {
+	Text     string  // Translated text
+	Provider string  // Service that produced the translation
+	Score    float64 // Combined score from scoreTranslation
+	Quality  float64 // Match quality reported by the provider
+}
+
 // ResponseData represents the response data from MyMemory API
 type ResponseData struct {
-	TranslatedText string `json:"translatedText"`
+	TranslatedText string  `json:"translatedText"`
+	Match          float64 `json:"match"`
 }
 
 // Match represents individual match data from MyMemory API
@@ -53,9 +71,14 @@ func processMatchQuality(rawQuality json.RawMessage) (float64, error) {
 }
 
 // Function translate text using MyMemory API
-func TranslateText(sourceText, sourceLang, targetLang string) (string, error) {
+func TranslateText(sourceText, sourceLang, targetLang string) (*Result, error) {
 	baseURL := "https://api.mymemory.translated.net/get"
 
+	// MyMemory expects the literal "Autodetect" when the source language is unknown
+	if sourceLang == AutoDetect {
+		sourceLang = "Autodetect"
+	}
+
 	params := url.Values{}
 	params.Add("q", sourceText)
 	params.Add("langpair", sourceLang+"|"+targetLang)
@@ -64,34 +87,31 @@ func TranslateText(sourceText, sourceLang, targetLang string) (string, error) {
 
 	resp, err := http.Get(finalURL)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("failed to get valid response, status code: %d", resp.StatusCode)
+		return nil, fmt.Errorf("failed to get valid response, status code: %d", resp.StatusCode)
 	}
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	fmt.Println("Response Body:", string(body))
-
 	var result MyMemoryResponse
 	if err := json.Unmarshal(body, &result); err != nil {
-		return "", err
+		return nil, err
 	}
 
-	log.Println("result: ", result)
-
 	// Save the initial translation
 	initialTranslation := result.ResponseData.TranslatedText
 	initialQuality := 80.0 // You can change this value
 
 	var bestTranslation string
 	var bestScore float64
+	var bestQuality float64
 
 	for _, match := range result.Matches {
 		quality, err := processMatchQuality(match.Quality)
@@ -107,20 +127,26 @@ func TranslateText(sourceText, sourceLang, targetLang string) (string, error) {
 
 		if score > bestScore && len(match.Translation) > 0 && !containsWeirdCharacters(match.Translation) {
 			bestScore = score
+			bestQuality = quality
 			bestTranslation = match.Translation
-			log.Println("Best translation so far: ", bestTranslation)
 		}
 	}
 
 	// Use the original translation in the absence of a better translation
 	if bestTranslation == "" || bestScore < initialQuality {
 		bestTranslation = initialTranslation
-		log.Println("Using initial translation: ", bestTranslation)
+		bestQuality = result.ResponseData.Match * 100
+		bestScore = scoreTranslation(sourceText, initialTranslation, bestQuality)
 	}
 
 	bestTranslation = html.UnescapeString(bestTranslation)
 
-	return bestTranslation, nil
+	return &Result{
+		Text:     bestTranslation,
+		Provider: ProviderMyMemory,
+		Score:    bestScore,
+		Quality:  bestQuality,
+	}, nil
 }
 
 // A function to detect unusual strings
@@ -146,6 +172,9 @@ func containsWeirdCharacters(s string) bool {
 // Scoring based on keywords
 func scoreTranslationByKeywords(sourceText, translatedText string) float64 {
 	keyWords := extractKeywords(sourceText)
+	if len(keyWords) == 0 {
+		return 0
+	}
 
 	matchedCount := 0
 	for _, word := range keyWords {
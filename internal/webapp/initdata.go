@@ -0,0 +1,83 @@
+package webapp
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInitData is returned for init data that can't be parsed or has no user.
+	ErrInitData = errors.New("invalid init data")
+
+	// ErrInitDataHash is returned for init data with a missing or wrong hash, which Telegram didn't sign.
+	ErrInitDataHash = errors.New("invalid init data hash")
+
+	// ErrInitDataExpired is returned for init data older than the allowed age.
+	ErrInitDataExpired = errors.New("init data expired")
+)
+
+// User is the Telegram user who opened the Mini App, as described by the init data.
+
+type User struct {
+	ID           int64  `json:"id"`
+	FirstName    string `json:"first_name"`
+	LastName     string `json:"last_name"`
+	Username     string `json:"username"`
+	LanguageCode string `json:"language_code"`
+}
+
+// ValidateInitData checks that init data passed to the Mini App by Telegram was signed with the bot token
+// and isn't older than maxAge, and returns the user it describes.
+// See https://core.telegram.org/bots/webapps#validating-data-received-via-the-mini-app
+
+func ValidateInitData(initData, botToken string, maxAge time.Duration) (User, error) {
+
+	values, err := url.ParseQuery(initData)
+	if err != nil {
+		return User{}, ErrInitData
+	}
+
+	hash := values.Get("hash")
+	if hash == "" {
+		return User{}, ErrInitDataHash
+	}
+
+	// The data check string holds every field but the hash, sorted by name
+	fields := make([]string, 0, len(values))
+	for name := range values {
+		if name != "hash" {
+			fields = append(fields, name+"="+values.Get(name))
+		}
+	}
+	sort.Strings(fields)
+
+	secret := hmac.New(sha256.New, []byte("WebAppData"))
+	secret.Write([]byte(botToken))
+	mac := hmac.New(sha256.New, secret.Sum(nil))
+	mac.Write([]byte(strings.Join(fields, "\n")))
+	if !hmac.Equal([]byte(hex.EncodeToString(mac.Sum(nil))), []byte(strings.ToLower(hash))) {
+		return User{}, ErrInitDataHash
+	}
+
+	authDate, err := strconv.ParseInt(values.Get("auth_date"), 10, 64)
+	if err != nil {
+		return User{}, ErrInitData
+	}
+	if maxAge > 0 && time.Since(time.Unix(authDate, 0)) > maxAge {
+		return User{}, ErrInitDataExpired
+	}
+
+	var user User
+	if err := json.Unmarshal([]byte(values.Get("user")), &user); err != nil || user.ID == 0 {
+		return User{}, ErrInitData
+	}
+	return user, nil
+}
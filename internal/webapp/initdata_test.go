@@ -0,0 +1,94 @@
+package webapp
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testBotToken = "123456:TEST-token"
+
+// testInitData was signed with testBotToken outside of Go, as Telegram signs init data.
+const testInitData = "auth_date=1700000000&query_id=AAHdF6IQAAAAAN0XohDhrOrc" +
+	"&user=%7B%22id%22%3A279058397%2C%22first_name%22%3A%22Vlad%22%2C%22username%22%3A%22vlad%22%2C%22language_code%22%3A%22fa%22%7D" +
+	"&hash=1ca3cbd4f804c4ba92b84a2a1ad7312d4b21e515157acbe6c3746b3ad03a5231"
+
+// signInitData returns init data with the fields, signed with the token.
+
+func signInitData(fields url.Values, token string) string {
+	var lines []string
+	for name := range fields {
+		lines = append(lines, name+"="+fields.Get(name))
+	}
+	sort.Strings(lines)
+	secret := hmac.New(sha256.New, []byte("WebAppData"))
+	secret.Write([]byte(token))
+	mac := hmac.New(sha256.New, secret.Sum(nil))
+	mac.Write([]byte(strings.Join(lines, "\n")))
+
+	signed := url.Values{}
+	for name := range fields {
+		signed.Set(name, fields.Get(name))
+	}
+	signed.Set("hash", hex.EncodeToString(mac.Sum(nil)))
+	return signed.Encode()
+}
+
+func TestValidateInitDataVector(t *testing.T) {
+	user, err := ValidateInitData(testInitData, testBotToken, 0)
+	if err != nil {
+		t.Fatalf("ValidateInitData = %v", err)
+	}
+	want := User{ID: 279058397, FirstName: "Vlad", Username: "vlad", LanguageCode: "fa"}
+	if user != want {
+		t.Errorf("user = %+v, want %+v", user, want)
+	}
+
+	// The vector was signed long ago
+	if _, err := ValidateInitData(testInitData, testBotToken, 24*time.Hour); !errors.Is(err, ErrInitDataExpired) {
+		t.Errorf("old init data = %v, want ErrInitDataExpired", err)
+	}
+}
+
+func TestValidateInitDataRejects(t *testing.T) {
+	fresh := url.Values{
+		"auth_date": {strconv.FormatInt(time.Now().Unix(), 10)},
+		"user":      {`{"id":42,"first_name":"Sara"}`},
+	}
+	if user, err := ValidateInitData(signInitData(fresh, testBotToken), testBotToken, time.Hour); err != nil || user.ID != 42 {
+		t.Fatalf("fresh init data = %+v, %v", user, err)
+	}
+
+	old := url.Values{
+		"auth_date": {strconv.FormatInt(time.Now().Add(-2*time.Hour).Unix(), 10)},
+		"user":      {`{"id":42,"first_name":"Sara"}`},
+	}
+	noUser := url.Values{"auth_date": {strconv.FormatInt(time.Now().Unix(), 10)}}
+
+	tests := []struct {
+		name     string
+		initData string
+		want     error
+	}{
+		{"changed field", strings.Replace(testInitData, "279058397", "279058398", 1), ErrInitDataHash},
+		{"added field", testInitData + "&can_send_after=0", ErrInitDataHash},
+		{"missing hash", testInitData[:strings.Index(testInitData, "&hash=")], ErrInitDataHash},
+		{"bad hash", testInitData[:strings.Index(testInitData, "&hash=")] + "&hash=not-hex", ErrInitDataHash},
+		{"other bot token", signInitData(fresh, "654321:OTHER-token"), ErrInitDataHash},
+		{"expired", signInitData(old, testBotToken), ErrInitDataExpired},
+		{"no user", signInitData(noUser, testBotToken), ErrInitData},
+		{"malformed", "%zz", ErrInitData},
+	}
+	for _, test := range tests {
+		if _, err := ValidateInitData(test.initData, testBotToken, time.Hour); !errors.Is(err, test.want) {
+			t.Errorf("%s: ValidateInitData = %v, want %v", test.name, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,429 @@
+// Package webapp serves the Telegram Mini App for editing the settings, glossary and history of a user.
+//
+// The page is served at /app and calls the JSON endpoints under /app/api. Every API request carries the
+// init data Telegram passes to the Mini App in an "Authorization: tma <init data>" header, validated with the bot token.
+package webapp
+
+import (
+	_ "embed"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mzfarshad/tlg_bot/internal/key"
+	"github.com/mzfarshad/tlg_bot/internal/setting"
+	"github.com/mzfarshad/tlg_bot/internal/storange"
+	"github.com/mzfarshad/tlg_bot/internal/translation"
+)
+
+// initDataMaxAge is the time a Mini App session stays valid after Telegram opened it.
+const initDataMaxAge = 24 * time.Hour
+
+// pageSize is the number of glossary terms or history entries returned at once.
+const pageSize = 20
+
+// userIDKey is the key of the authenticated user ID in the gin context.
+const userIDKey = "userID"
+
+//go:embed static/index.html
+var index []byte
+
+// Server serves the Mini App and its API.
+
+type Server struct {
+	token string // Bot token the init data is signed with
+}
+
+// New creates the Mini App server of the bot with the given token.
+
+func New(token string) *Server {
+	return &Server{token: token}
+}
+
+// Register adds the routes of the Mini App to the router.
+
+func (s *Server) Register(router gin.IRouter) {
+
+	router.GET("/app", func(ctx *gin.Context) {
+		ctx.Data(http.StatusOK, "text/html; charset=utf-8", index)
+	})
+
+	api := router.Group("/app/api", s.authenticate)
+	api.GET("/settings", getSettings)
+	api.PUT("/settings/language", putLanguage)
+	api.PUT("/settings/pair", putPair)
+	api.DELETE("/settings/pair", deletePair)
+	api.GET("/glossary", getGlossary)
+	api.POST("/glossary", postGlossaryTerm)
+	api.DELETE("/glossary/:id", deleteGlossaryTerm)
+	api.GET("/history", getHistory)
+	api.PUT("/history/enabled", putHistoryEnabled)
+	api.DELETE("/history/:id", deleteHistoryEntry)
+	api.DELETE("/history", clearHistory)
+}
+
+// authenticate validates the init data of the request and stores the ID of its user in the context.
+// Requests of banned users are refused.
+
+func (s *Server) authenticate(ctx *gin.Context) {
+
+	initData, found := strings.CutPrefix(ctx.GetHeader("Authorization"), "tma ")
+	if !found {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "missing init data"})
+		return
+	}
+	user, err := ValidateInitData(initData, s.token, initDataMaxAge)
+	if err != nil {
+		log.Printf("rejected mini app request: %v", err)
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Banned users are kept out of the Mini App as they are out of the chat
+	banned, err := storange.IsBanned(int(user.ID))
+	if err != nil {
+		fail(ctx, err)
+		ctx.Abort()
+		return
+	}
+	if banned {
+		log.Printf("rejected mini app request of banned user %d", user.ID)
+		ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "user is banned"})
+		return
+	}
+
+	ctx.Set(userIDKey, int(user.ID))
+	ctx.Next()
+}
+
+// userID returns the ID of the authenticated user.
+
+func userID(ctx *gin.Context) int {
+	return ctx.GetInt(userIDKey)
+}
+
+// fail logs an error and responds with an internal error.
+
+func fail(ctx *gin.Context, err error) {
+	log.Println(err)
+	ctx.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+}
+
+// badRequest responds with a client error.
+
+func badRequest(ctx *gin.Context, message string) {
+	ctx.JSON(http.StatusBadRequest, gin.H{"error": message})
+}
+
+// page returns the offset of the page given in the page query parameter, counted from zero.
+
+func page(ctx *gin.Context) int {
+	page, err := strconv.Atoi(ctx.Query("page"))
+	if err != nil || page < 0 {
+		return 0
+	}
+	return page * pageSize
+}
+
+// validPair reports whether source and target form a language pair that can be translated.
+
+func validPair(source, target string) bool {
+	return (source == translation.AutoDetect || translation.IsSupportedLanguage(source)) &&
+		translation.IsSupportedLanguage(target) && source != target
+}
+
+// languageOption is a language offered by the Mini App.
+
+type languageOption struct {
+	Code string `json:"code"`
+	Name string `json:"name"`
+}
+
+// pairJSON is a language pair in requests and responses.
+
+type pairJSON struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+}
+
+// getSettings returns the effective settings of the user, where they come from and the choices for them.
+
+func getSettings(ctx *gin.Context) {
+
+	target := setting.ForUser(userID(ctx))
+	lang, langScope, err := setting.BotLanguage(target)
+	if err != nil {
+		fail(ctx, err)
+		return
+	}
+	current, err := setting.GetTranslationSetting(target)
+	if err != nil {
+		fail(ctx, err)
+		return
+	}
+	inferred, err := setting.LanguageInferred(userID(ctx))
+	if err != nil {
+		fail(ctx, err)
+		return
+	}
+	recent, err := storange.GetRecentPairs(userID(ctx))
+	if err != nil {
+		fail(ctx, err)
+		return
+	}
+
+	var botLanguages []languageOption
+	for _, code := range key.Languages() {
+		botLanguages = append(botLanguages, languageOption{Code: string(code), Name: key.LanguageName(code)})
+	}
+
+	var languages []languageOption
+	for _, language := range translation.Languages {
+		languages = append(languages, languageOption{Code: language.Code, Name: language.Native})
+	}
+
+	recentPairs := make([]pairJSON, 0, len(recent))
+	for _, pair := range recent {
+		recentPairs = append(recentPairs, pairJSON{Source: pair.Source, Target: pair.Target})
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"language":       lang,
+		"language_scope": langScope,
+		"inferred":       inferred && langScope == setting.User,
+		"bot_languages":  botLanguages,
+		"languages":      languages,
+		"pair":           pairJSON{Source: current.SourceLanguage, Target: current.TargetLanguage},
+		"pair_scope":     current.Sources[setting.SourceLanguageSetting],
+		"active":         current.ActiveTranslation,
+		"recent":         recentPairs,
+	})
+}
+
+// putLanguage sets the user's bot language.
+
+func putLanguage(ctx *gin.Context) {
+
+	var request struct {
+		Language string `json:"language"`
+	}
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		badRequest(ctx, "invalid request")
+		return
+	}
+	if !key.Supported(key.Language(request.Language)) {
+		badRequest(ctx, "unknown language")
+		return
+	}
+	if err := setting.SaveBotLanguage(setting.User, setting.ForUser(userID(ctx)), key.Language(request.Language)); err != nil {
+		fail(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// putPair activates a language pair for the user.
+
+func putPair(ctx *gin.Context) {
+
+	var request pairJSON
+	if err := ctx.ShouldBindJSON(&request); err != nil || !validPair(request.Source, request.Target) {
+		badRequest(ctx, "invalid language pair")
+		return
+	}
+	if err := setting.ActivateLanguagePairs(setting.User, setting.ForUser(userID(ctx)), request.Source, request.Target); err != nil {
+		fail(ctx, err)
+		return
+	}
+	if err := storange.SaveRecentPair(userID(ctx), request.Source, request.Target); err != nil {
+		log.Println(err)
+	}
+	ctx.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// deletePair resets the user's translation settings to the inherited ones.
+
+func deletePair(ctx *gin.Context) {
+	if _, err := setting.ResetTranslationSettings(setting.User, setting.ForUser(userID(ctx))); err != nil {
+		fail(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// glossaryPair returns the language pair of a glossary request, the user's active pair if none is given.
+
+func glossaryPair(ctx *gin.Context, source, target string) (string, string, bool) {
+	if source == "" && target == "" {
+		current, err := setting.GetTranslationSetting(setting.ForUser(userID(ctx)))
+		if err != nil {
+			log.Println(err)
+			return "", "", false
+		}
+		source, target = current.SourceLanguage, current.TargetLanguage
+	}
+	return source, target, validPair(source, target)
+}
+
+// getGlossary returns one page of the user's glossary for a language pair.
+
+func getGlossary(ctx *gin.Context) {
+
+	source, target, ok := glossaryPair(ctx, ctx.Query("source"), ctx.Query("target"))
+	if !ok {
+		badRequest(ctx, "invalid language pair")
+		return
+	}
+	terms, total, err := storange.GetGlossary(userID(ctx), source, target, page(ctx), pageSize)
+	if err != nil {
+		fail(ctx, err)
+		return
+	}
+
+	type termJSON struct {
+		ID          int64  `json:"id"`
+		Term        string `json:"term"`
+		Translation string `json:"translation"`
+	}
+	items := make([]termJSON, 0, len(terms))
+	for _, term := range terms {
+		items = append(items, termJSON{ID: term.ID, Term: term.Term, Translation: term.Translation})
+	}
+	ctx.JSON(http.StatusOK, gin.H{"pair": pairJSON{Source: source, Target: target}, "terms": items, "total": total})
+}
+
+// postGlossaryTerm adds a term to the user's glossary, replacing the translation of an existing term.
+
+func postGlossaryTerm(ctx *gin.Context) {
+
+	var request struct {
+		pairJSON
+		Term        string `json:"term"`
+		Translation string `json:"translation"`
+	}
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		badRequest(ctx, "invalid request")
+		return
+	}
+	source, target, ok := glossaryPair(ctx, request.Source, request.Target)
+	if !ok {
+		badRequest(ctx, "invalid language pair")
+		return
+	}
+	if strings.TrimSpace(request.Term) == "" || strings.TrimSpace(request.Translation) == "" {
+		badRequest(ctx, "term and translation are required")
+		return
+	}
+
+	err := storange.SaveGlossaryTerm(storange.GlossaryTerm{
+		UserID:         userID(ctx),
+		SourceLanguage: source,
+		TargetLanguage: target,
+		Term:           request.Term,
+		Translation:    request.Translation,
+		CreatedAt:      time.Now(),
+	})
+	if err != nil {
+		fail(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// deleteGlossaryTerm removes a term from the user's glossary.
+
+func deleteGlossaryTerm(ctx *gin.Context) {
+
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	if err != nil {
+		badRequest(ctx, "invalid id")
+		return
+	}
+	if err := storange.DeleteGlossaryTerm(userID(ctx), id); err != nil {
+		fail(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// getHistory returns one page of the user's translation history, optionally filtered by a keyword.
+
+func getHistory(ctx *gin.Context) {
+
+	entries, total, err := storange.GetHistory(userID(ctx), strings.TrimSpace(ctx.Query("q")), page(ctx), pageSize)
+	if err != nil {
+		fail(ctx, err)
+		return
+	}
+	enabled, err := storange.HistoryEnabled(userID(ctx))
+	if err != nil {
+		fail(ctx, err)
+		return
+	}
+
+	type entryJSON struct {
+		ID             int64     `json:"id"`
+		SourceText     string    `json:"source_text"`
+		TranslatedText string    `json:"translated_text"`
+		Pair           pairJSON  `json:"pair"`
+		CreatedAt      time.Time `json:"created_at"`
+	}
+	items := make([]entryJSON, 0, len(entries))
+	for _, entry := range entries {
+		items = append(items, entryJSON{
+			ID:             entry.ID,
+			SourceText:     entry.SourceText,
+			TranslatedText: entry.TranslatedText,
+			Pair:           pairJSON{Source: entry.SourceLanguage, Target: entry.TargetLanguage},
+			CreatedAt:      entry.CreatedAt,
+		})
+	}
+	ctx.JSON(http.StatusOK, gin.H{"entries": items, "total": total, "enabled": enabled})
+}
+
+// putHistoryEnabled pauses or resumes saving the user's translations.
+
+func putHistoryEnabled(ctx *gin.Context) {
+
+	var request struct {
+		Enabled *bool `json:"enabled"`
+	}
+	if err := ctx.ShouldBindJSON(&request); err != nil || request.Enabled == nil {
+		badRequest(ctx, "invalid request")
+		return
+	}
+	if err := storange.SetHistoryEnabled(userID(ctx), *request.Enabled); err != nil {
+		fail(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// deleteHistoryEntry removes one entry of the user's history.
+
+func deleteHistoryEntry(ctx *gin.Context) {
+
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	if err != nil {
+		badRequest(ctx, "invalid id")
+		return
+	}
+	if err := storange.DeleteHistory(userID(ctx), id); err != nil {
+		fail(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// clearHistory removes the user's whole history.
+
+func clearHistory(ctx *gin.Context) {
+	if err := storange.ClearHistory(userID(ctx)); err != nil {
+		fail(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
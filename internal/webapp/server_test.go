@@ -0,0 +1,57 @@
+package webapp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mzfarshad/tlg_bot/internal/storange"
+)
+
+func TestAuthenticateRejectsBannedUsers(t *testing.T) {
+	// The database is created in the working directory
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	if err := storange.InitDB(); err != nil {
+		t.Fatal(err)
+	}
+	if err := storange.BanUser(7, "spam", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/app/api/me", New(testBotToken).authenticate, func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, gin.H{"id": userID(ctx)})
+	})
+
+	request := func(userID int) int {
+		initData := signInitData(url.Values{
+			"auth_date": {strconv.FormatInt(time.Now().Unix(), 10)},
+			"user":      {`{"id":` + strconv.Itoa(userID) + `,"first_name":"Test"}`},
+		}, testBotToken)
+		req := httptest.NewRequest(http.MethodGet, "/app/api/me", nil)
+		req.Header.Set("Authorization", "tma "+initData)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+
+	if code := request(8); code != http.StatusOK {
+		t.Errorf("request of a user = %d, want 200", code)
+	}
+	if code := request(7); code != http.StatusForbidden {
+		t.Errorf("request of a banned user = %d, want 403", code)
+	}
+}
@@ -0,0 +1,97 @@
+// Package webhook serves the route Telegram posts the updates of the bot to.
+//
+// The webhook is always protected by a secret token: it is given to Telegram with setWebhook and every request
+// must carry it in the X-Telegram-Bot-Api-Secret-Token header; requests without it are rejected before their body is read.
+//
+// Updates are queued in the workers of the bot and acknowledged at once, so a slow handler doesn't make Telegram
+// time out and deliver the update again. When the queue is full and the bot rejects the update, the request is
+// answered with 503 and Telegram retries it later, as are updates arriving while the bot stops.
+package webhook
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mzfarshad/tlg_bot/internal/bot"
+)
+
+// SecretTokenHeader is the header carrying the secret token in the requests of Telegram.
+const SecretTokenHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+// secretBytes is the number of random bytes of a generated secret token.
+const secretBytes = 32
+
+// Dispatcher takes the updates received by the webhook, such as the bot.
+
+type Dispatcher interface {
+	EnqueueUpdateJSON(body []byte) error
+}
+
+// Server receives the updates of the bot.
+
+type Server struct {
+	bot    Dispatcher
+	secret string // Secret token the requests must carry
+}
+
+// New creates the webhook server of the bot, accepting only requests with the secret token.
+
+func New(bot Dispatcher, secret string) (*Server, error) {
+	if secret == "" {
+		return nil, errors.New("webhook needs a secret token")
+	}
+	return &Server{bot: bot, secret: secret}, nil
+}
+
+// NewSecret generates a random secret token, for a webhook registered without a configured one.
+
+func NewSecret() (string, error) {
+	random := make([]byte, secretBytes)
+	if _, err := rand.Read(random); err != nil {
+		return "", fmt.Errorf("error generating webhook secret: %v", err)
+	}
+	return hex.EncodeToString(random), nil
+}
+
+// Register adds the webhook route to the router.
+
+func (s *Server) Register(router gin.IRouter) {
+	router.POST("/webhook", s.verify, s.receive)
+}
+
+// verify rejects requests without the secret token.
+
+func (s *Server) verify(ctx *gin.Context) {
+	token := ctx.GetHeader(SecretTokenHeader)
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.secret)) != 1 {
+		log.Printf("webhook request from %s without a valid secret token", ctx.ClientIP())
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid secret token"})
+	}
+}
+
+// receive queues the update posted by Telegram.
+
+func (s *Server) receive(ctx *gin.Context) {
+
+	body, err := ctx.GetRawData()
+	if err == nil {
+		err = s.bot.EnqueueUpdateJSON(body)
+	}
+	if errors.Is(err, bot.ErrQueueFull) || errors.Is(err, bot.ErrQueueClosed) {
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{"error": "too many updates"})
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
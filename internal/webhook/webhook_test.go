@@ -0,0 +1,101 @@
+package webhook
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mzfarshad/tlg_bot/internal/bot"
+)
+
+// recordingDispatcher remembers the updates it received and answers with err.
+
+type recordingDispatcher struct {
+	bodies []string
+	err    error
+}
+
+func (d *recordingDispatcher) EnqueueUpdateJSON(body []byte) error {
+	d.bodies = append(d.bodies, string(body))
+	return d.err
+}
+
+// post sends an update to the webhook with the secret token header, if it isn't empty.
+
+func post(router *gin.Engine, token string) int {
+	req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(`{"update_id":1}`))
+	if token != "" {
+		req.Header.Set(SecretTokenHeader, token)
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	return recorder.Code
+}
+
+func newTestRouter(t *testing.T, dispatcher Dispatcher) *gin.Engine {
+	server, err := New(dispatcher, "s3cret-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	server.Register(router)
+	return router
+}
+
+func TestVerifySecretToken(t *testing.T) {
+	dispatcher := &recordingDispatcher{}
+	router := newTestRouter(t, dispatcher)
+
+	for _, token := range []string{"", "wrong", "s3cret-token-and-more", "s3cret-toke"} {
+		if code := post(router, token); code != http.StatusUnauthorized {
+			t.Errorf("request with token %q = %d, want 401", token, code)
+		}
+	}
+	if len(dispatcher.bodies) != 0 {
+		t.Fatalf("rejected requests reached the dispatcher: %v", dispatcher.bodies)
+	}
+
+	if code := post(router, "s3cret-token"); code != http.StatusOK {
+		t.Errorf("request with the secret token = %d, want 200", code)
+	}
+	if len(dispatcher.bodies) != 1 || dispatcher.bodies[0] != `{"update_id":1}` {
+		t.Errorf("dispatched updates = %v", dispatcher.bodies)
+	}
+}
+
+var errTest = errors.New("invalid update")
+
+func TestReceiveErrors(t *testing.T) {
+	dispatcher := &recordingDispatcher{err: bot.ErrQueueFull}
+	router := newTestRouter(t, dispatcher)
+	if code := post(router, "s3cret-token"); code != http.StatusServiceUnavailable {
+		t.Errorf("request with a full queue = %d, want 503", code)
+	}
+
+	dispatcher.err = errTest
+	if code := post(router, "s3cret-token"); code != http.StatusBadRequest {
+		t.Errorf("request with an invalid update = %d, want 400", code)
+	}
+}
+
+func TestNewRequiresSecret(t *testing.T) {
+	if _, err := New(&recordingDispatcher{}, ""); err == nil {
+		t.Error("New accepted an empty secret token")
+	}
+
+	secret, err := NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secret) != 2*secretBytes || secret == other {
+		t.Errorf("generated secrets %q and %q", secret, other)
+	}
+}